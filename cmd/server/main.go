@@ -2,26 +2,48 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/redis/go-redis/v9"
 
+	"comp/internal/auth"
 	"comp/internal/cleanup"
 	cfgpkg "comp/internal/config"
 	"comp/internal/httpapi"
+	"comp/internal/jobs"
+	"comp/internal/limits"
 	"comp/internal/logx"
+	"comp/internal/metrics"
+	"comp/internal/storage"
 	"comp/internal/store"
+	"comp/internal/tracing"
+	"comp/internal/webhook"
 )
 
 func main() {
+	createKey := flag.String("create-key", "", "store a new API key for `owner` in Redis, print it and exit")
+	flag.Parse()
+
 	cfg, _ := cfgpkg.Load()
 	logger, _ := logx.Init(cfg.LogLevel)
 	if logger != nil {
 		defer logger.Sync()
 	}
 
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "tracing: %v\n", err)
+		os.Exit(1)
+	}
+	defer shutdownTracing(context.Background())
+
 	var rdb *redis.Client
 	if cfg.RedisAddr != "" {
 		rdb = redis.NewClient(&redis.Options{Addr: cfg.RedisAddr, DB: cfg.RedisDB, Password: cfg.RedisPassword})
@@ -33,17 +55,96 @@ func main() {
 		}
 	}
 	st := store.NewRedisStore(rdb)
+	st.Retain = time.Duration(cfg.TaskRetentionHours) * time.Hour
+	st.LogLimit = cfg.TaskLogKB << 10
+	metrics.QueueDepth(func() (int, error) { return st.Len(context.Background()) })
+	authn := auth.New(cfg.APIKeys, cfg.SessionSecret, cfg.AuthRequired, rdb, logger)
+	if *createKey != "" {
+		key, err := authn.CreateKey(context.Background(), *createKey)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "create key: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(key)
+		return
+	}
 
-	deps := httpapi.Deps{Cfg: cfg, Logger: logger, Store: st, Redis: rdb}
+	stor, err := storage.New(context.Background(), cfg.Storage, cfg.UploadsDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "storage: %v\n", err)
+		os.Exit(1)
+	}
+
+	lim := limits.New(cfg.RateLimitPerMinute, cfg.MaxActiveJobs, cfg.DailyQuotaMB*1024*1024, cfg.DailyQuotaMinutes, st, rdb, logger)
+	proc := &jobs.Processor{Cfg: cfg, Store: st, Storage: stor, Logger: logger, Usage: lim}
+	hooks := webhook.New(cfg.WebhookSecret, st, rdb, logger, cfg.WebhookMaxAttempts, cfg.WebhookAllowPrivate)
+	if cfg.WebhookSecret == "" && logger != nil {
+		logger.Warnf("webhook_secret is not set; requests with callback_url are rejected")
+	}
+	pool := &jobs.Pool{Queue: st, Store: st, Logger: logger, Workers: cfg.Workers, Handler: proc.Process, Discard: proc.Discard, Redis: rdb, OnFinish: hooks.Notify, Requeue: cfg.RequeueOnShutdown}
+	// batches queue their items through the pool
+	proc.Submit = pool.Submit
+	// Tasks and scratch dirs of a previous run that was killed mid-job
+	if n, err := pool.RecoverOrphans(context.Background()); err != nil && logger != nil {
+		logger.Warnf("orphaned task scan failed: %v", err)
+	} else if n > 0 && logger != nil {
+		logger.Warnf("marked %d orphaned processing task(s) as interrupted", n)
+	}
+	if n, err := pool.RemoveOrphanDirs(context.Background()); err != nil && logger != nil {
+		logger.Warnf("orphaned job dir cleanup failed: %v", err)
+	} else if n > 0 && logger != nil {
+		logger.Infof("removed %d orphaned job dir(s)", n)
+	}
+	pool.Start(context.Background())
+	hooks.Start(context.Background())
+
+	deps := httpapi.Deps{Cfg: cfg, Logger: logger, Store: st, Queue: st, Jobs: pool, Auth: authn, Limits: lim, Redis: rdb, Storage: stor, Uploads: st}
 	r := httpapi.NewRouter(deps)
 
 	// Optional: trust proxy headers if behind reverse proxy
 	gin.SetMode(gin.ReleaseMode)
-	// Start cleanup for uploads dir; attempt both common locations
-	cleanup.Start("uploads", cfg.CleanupMinutes)
-	cleanup.Start("web/uploads", cfg.CleanupMinutes)
+	// Start cleanup of expired outputs, abandoned sources and stale partial uploads
+	cleanup.Start(stor, cfg.CleanupMinutes)
 	addr := fmt.Sprintf("0.0.0.0:%d", cfg.Port)
-	_ = r.Run(addr)
+	srv := &http.Server{Addr: addr, Handler: r}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- srv.ListenAndServe() }()
+	select {
+	case <-ctx.Done():
+	case err := <-serveErr:
+		if logger != nil {
+			logger.Errorf("http server: %v", err)
+		}
+	}
+	// a second signal kills the process right away
+	stop()
+
+	// Keep serving status and downloads while running jobs finish; new
+	// submissions are rejected from here on.
+	timeout := time.Duration(cfg.ShutdownTimeoutSeconds) * time.Second
+	if logger != nil {
+		logger.Infof("shutting down, waiting up to %s for running jobs", timeout)
+	}
+	drainCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	if err := pool.Shutdown(drainCtx); err != nil && logger != nil {
+		logger.Warnf("shutdown deadline reached; running jobs were interrupted")
+	}
+	cancel()
+	// callbacks of the jobs that just finished are sent by whichever
+	// instance runs next if they do not make it out in time
+	hookCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	if err := hooks.Shutdown(hookCtx); err != nil && logger != nil {
+		logger.Warnf("pending callbacks left for the next start")
+	}
+	cancel()
+	httpCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	if err := srv.Shutdown(httpCtx); err != nil {
+		// event streams stay open until closed
+		_ = srv.Close()
+	}
+	cancel()
 	// give logger time to flush
 	time.Sleep(50 * time.Millisecond)
 }
@@ -0,0 +1,207 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	redis "github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	ownerKey      = "auth.owner"
+	sessionCookie = "session"
+	sessionTTL    = 7 * 24 * time.Hour
+)
+
+// Authenticator resolves API keys (from config or Redis) and web sessions to
+// an owner name.
+type Authenticator struct {
+	// Keys maps API key -> owner, as configured in config.json.
+	Keys map[string]string
+	// Redis holds additional keys as apikey:<sha256 hex> -> owner (optional).
+	Redis *redis.Client
+	// Secret signs session cookies.
+	Secret []byte
+	// Required rejects anonymous requests; otherwise they run as owner "".
+	Required bool
+	Logger   *zap.SugaredLogger
+}
+
+// New builds an Authenticator. Without a configured secret a random one is
+// generated, so sessions do not survive a restart.
+func New(keys map[string]string, secret string, required bool, rdb *redis.Client, logger *zap.SugaredLogger) *Authenticator {
+	a := &Authenticator{Keys: keys, Redis: rdb, Secret: []byte(secret), Required: required, Logger: logger}
+	if len(a.Secret) == 0 {
+		a.Secret = make([]byte, 32)
+		_, _ = rand.Read(a.Secret)
+		if required && logger != nil {
+			logger.Warnf("session_secret not set; web sessions will reset on restart")
+		}
+	}
+	return a
+}
+
+func redisKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "apikey:" + hex.EncodeToString(sum[:])
+}
+
+// Lookup returns the owner of an API key.
+func (a *Authenticator) Lookup(ctx context.Context, key string) (string, bool) {
+	if key == "" {
+		return "", false
+	}
+	for k, owner := range a.Keys {
+		if subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1 {
+			return owner, true
+		}
+	}
+	if a.Redis != nil {
+		owner, err := a.Redis.Get(ctx, redisKey(key)).Result()
+		if err == nil && owner != "" {
+			return owner, true
+		}
+	}
+	return "", false
+}
+
+// CreateKey generates a new API key for owner and stores its hash in Redis.
+func (a *Authenticator) CreateKey(ctx context.Context, owner string) (string, error) {
+	if a.Redis == nil {
+		return "", fmt.Errorf("redis is required to store API keys")
+	}
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	key := base64.RawURLEncoding.EncodeToString(b)
+	if err := a.Redis.Set(ctx, redisKey(key), owner, 0).Err(); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// Owner returns the owner resolved by Middleware ("" for anonymous).
+func Owner(c *gin.Context) string {
+	return c.GetString(ownerKey)
+}
+
+func bearer(c *gin.Context) string {
+	h := c.GetHeader("Authorization")
+	if len(h) > 7 && strings.EqualFold(h[:7], "bearer ") {
+		return strings.TrimSpace(h[7:])
+	}
+	return strings.TrimSpace(c.GetHeader("X-API-Key"))
+}
+
+// Middleware authenticates by bearer token, X-API-Key or session cookie.
+func (a *Authenticator) Middleware() gin.HandlerFunc {
+	return a.MiddlewareFunc(func(c *gin.Context, status int, msg string) {
+		c.AbortWithStatusJSON(status, gin.H{"error": msg})
+	})
+}
+
+// MiddlewareFunc is Middleware with a custom writer for 401 responses, for
+// APIs that use their own error shape.
+func (a *Authenticator) MiddlewareFunc(deny func(c *gin.Context, status int, msg string)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if key := bearer(c); key != "" {
+			owner, ok := a.Lookup(c.Request.Context(), key)
+			if !ok {
+				c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+				deny(c, http.StatusUnauthorized, "invalid api key")
+				return
+			}
+			c.Set(ownerKey, owner)
+			c.Next()
+			return
+		}
+		if owner, ok := a.session(c); ok {
+			c.Set(ownerKey, owner)
+			c.Next()
+			return
+		}
+		if !a.Required {
+			c.Next()
+			return
+		}
+		if c.Request.Method == http.MethodGet && strings.Contains(c.GetHeader("Accept"), "text/html") {
+			c.Redirect(http.StatusFound, "/login")
+			c.Abort()
+			return
+		}
+		c.Header("WWW-Authenticate", "Bearer")
+		deny(c, http.StatusUnauthorized, "authentication required")
+	}
+}
+
+// Login exchanges an API key posted as form field api_key for a session cookie.
+func (a *Authenticator) Login(c *gin.Context) {
+	owner, ok := a.Lookup(c.Request.Context(), strings.TrimSpace(c.PostForm("api_key")))
+	if !ok {
+		c.HTML(http.StatusUnauthorized, "login.html", gin.H{"error": "Неверный ключ"})
+		return
+	}
+	exp := time.Now().Add(sessionTTL).Unix()
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(sessionCookie, a.sign(owner, exp), int(sessionTTL.Seconds()), "/", "", c.Request.TLS != nil, true)
+	c.Redirect(http.StatusFound, "/")
+}
+
+// Logout drops the session cookie.
+func (a *Authenticator) Logout(c *gin.Context) {
+	c.SetCookie(sessionCookie, "", -1, "/", "", c.Request.TLS != nil, true)
+	c.Redirect(http.StatusFound, "/login")
+}
+
+// session cookies are base64(owner).expiry.hmac
+func (a *Authenticator) sign(owner string, exp int64) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(owner)) + "." + strconv.FormatInt(exp, 10)
+	return payload + "." + a.mac(payload)
+}
+
+func (a *Authenticator) mac(payload string) string {
+	m := hmac.New(sha256.New, a.Secret)
+	m.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(m.Sum(nil))
+}
+
+func (a *Authenticator) session(c *gin.Context) (string, bool) {
+	v, err := c.Cookie(sessionCookie)
+	if err != nil || v == "" {
+		return "", false
+	}
+	i := strings.LastIndexByte(v, '.')
+	if i < 0 {
+		return "", false
+	}
+	payload, sig := v[:i], v[i+1:]
+	if !hmac.Equal([]byte(sig), []byte(a.mac(payload))) {
+		return "", false
+	}
+	ownerB64, expStr, ok := strings.Cut(payload, ".")
+	if !ok {
+		return "", false
+	}
+	exp, err := strconv.ParseInt(expStr, 10, 64)
+	if err != nil || time.Now().Unix() > exp {
+		return "", false
+	}
+	owner, err := base64.RawURLEncoding.DecodeString(ownerB64)
+	if err != nil {
+		return "", false
+	}
+	return string(owner), true
+}
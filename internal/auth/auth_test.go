@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	redis "github.com/redis/go-redis/v9"
+)
+
+func TestLookup(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer rdb.Close()
+	a := New(map[string]string{"static-key": "alice"}, "s", true, rdb, nil)
+
+	key, err := a.CreateKey(ctx, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// only the hash is stored
+	if mr.Exists("apikey:"+key) || !mr.Exists(redisKey(key)) {
+		t.Error("CreateKey did not store the key by its hash")
+	}
+	tests := map[string]string{"static-key": "alice", key: "bob", "": "", "unknown": ""}
+	for k, want := range tests {
+		if owner, ok := a.Lookup(ctx, k); owner != want || ok != (want != "") {
+			t.Errorf("Lookup(%q) = %q, %v; want %q", k, owner, ok, want)
+		}
+	}
+	if _, err := New(nil, "s", true, nil, nil).CreateKey(ctx, "bob"); err == nil {
+		t.Error("CreateKey without Redis succeeded")
+	}
+}
+
+// serve runs req through Middleware and reports the status and owner seen
+// by the handler.
+func serve(a *Authenticator, req *http.Request) (int, string) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	owner := "-"
+	r.GET("/", a.Middleware(), func(c *gin.Context) { owner = Owner(c) })
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w.Code, owner
+}
+
+func TestMiddleware(t *testing.T) {
+	a := New(map[string]string{"k1": "alice"}, "secret", true, nil, nil)
+	tests := []struct {
+		name   string
+		header map[string]string
+		status int
+		owner  string
+	}{
+		{"bearer", map[string]string{"Authorization": "Bearer k1"}, http.StatusOK, "alice"},
+		{"lowercase bearer", map[string]string{"Authorization": "bearer k1"}, http.StatusOK, "alice"},
+		{"x-api-key", map[string]string{"X-API-Key": "k1"}, http.StatusOK, "alice"},
+		{"wrong key", map[string]string{"Authorization": "Bearer nope"}, http.StatusUnauthorized, "-"},
+		{"anonymous", nil, http.StatusUnauthorized, "-"},
+		{"browser", map[string]string{"Accept": "text/html"}, http.StatusFound, "-"},
+		{"session", map[string]string{"Cookie": sessionCookie + "=" + a.sign("carol", time.Now().Add(time.Hour).Unix())}, http.StatusOK, "carol"},
+		{"expired session", map[string]string{"Cookie": sessionCookie + "=" + a.sign("carol", time.Now().Add(-time.Hour).Unix())}, http.StatusUnauthorized, "-"},
+		{"session of another secret", map[string]string{"Cookie": sessionCookie + "=" + New(nil, "other", true, nil, nil).sign("carol", time.Now().Add(time.Hour).Unix())}, http.StatusUnauthorized, "-"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			status, owner := serve(a, req)
+			if status != tt.status || owner != tt.owner {
+				t.Errorf("got %d as %q, want %d as %q", status, owner, tt.status, tt.owner)
+			}
+		})
+	}
+}
+
+func TestMiddlewareOptional(t *testing.T) {
+	a := New(nil, "secret", false, nil, nil)
+	status, owner := serve(a, httptest.NewRequest(http.MethodGet, "/", nil))
+	if status != http.StatusOK || owner != "" {
+		t.Errorf("anonymous: %d as %q, want 200 as \"\"", status, owner)
+	}
+}
+
+func TestSessionTampered(t *testing.T) {
+	a := New(nil, "secret", true, nil, nil)
+	exp := time.Now().Add(time.Hour).Unix()
+	cookie := a.sign("alice", exp)
+	// swap the owner but keep alice's signature
+	forged := a.sign("mallory", exp)
+	forged = forged[:len(forged)-len(a.mac(""))] + cookie[len(cookie)-len(a.mac("")):]
+	for _, v := range []string{forged, "garbage", "a.b", strconv.FormatInt(exp, 10)} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.AddCookie(&http.Cookie{Name: sessionCookie, Value: v})
+		if status, _ := serve(a, req); status != http.StatusUnauthorized {
+			t.Errorf("cookie %q: %d, want 401", v, status)
+		}
+	}
+}
@@ -1,35 +1,73 @@
 package cleanup
 
 import (
-	"os"
-	"path/filepath"
+	"context"
+	"strings"
 	"time"
+
+	"comp/internal/storage"
 )
 
-// Start periodically removes files older than keepMinutes from uploadsDir.
-func Start(uploadsDir string, keepMinutes int) {
+// orphanAge is how long an incoming source may wait before it is considered
+// abandoned (its job lost or never picked up).
+const orphanAge = 24 * time.Hour
+
+// Start periodically removes outputs older than keepMinutes and abandoned
+// incoming sources from st, plus resumable uploads that saw no data for a
+// day (their store record has expired by then). Batch items wait for the
+// rest of their batch, so they are kept for a day as well.
+func Start(st storage.Storage, keepMinutes int) {
 	if keepMinutes <= 0 {
 		return
 	}
 	ticker := time.NewTicker(1 * time.Minute)
 	go func() {
 		for range ticker.C {
-			entries, err := os.ReadDir(uploadsDir)
-			if err != nil {
-				continue
-			}
-			for _, e := range entries {
-				if e.IsDir() {
-					continue
-				}
-				fi, err := e.Info()
-				if err != nil {
-					continue
-				}
-				if time.Since(fi.ModTime()) > time.Duration(keepMinutes)*time.Minute {
-					_ = os.Remove(filepath.Join(uploadsDir, e.Name()))
-				}
-			}
+			sweep(st, "outputs/", time.Duration(keepMinutes)*time.Minute)
+			sweep(st, "incoming/", orphanAge)
+			sweep(st, "batches/", orphanAge)
+			sweepUploads(st, orphanAge)
 		}
 	}()
 }
+
+func sweep(st storage.Storage, prefix string, maxAge time.Duration) {
+	ctx := context.Background()
+	objs, err := st.List(ctx, prefix)
+	if err != nil {
+		return
+	}
+	for _, o := range objs {
+		if time.Since(o.ModTime) > maxAge {
+			_ = st.Delete(ctx, o.Key)
+		}
+	}
+}
+
+// sweepUploads removes the chunks of resumable uploads whose newest chunk
+// is older than maxAge; chunks of an upload still in progress are kept
+// however old they are.
+func sweepUploads(st storage.Storage, maxAge time.Duration) {
+	ctx := context.Background()
+	objs, err := st.List(ctx, "partial/")
+	if err != nil {
+		return
+	}
+	uploads := make(map[string][]storage.Object)
+	newest := make(map[string]time.Time)
+	for _, o := range objs {
+		id, _, _ := strings.Cut(strings.TrimPrefix(o.Key, "partial/"), "/")
+		uploads[id] = append(uploads[id], o)
+		if o.ModTime.After(newest[id]) {
+			newest[id] = o.ModTime
+		}
+	}
+	for id, parts := range uploads {
+		if time.Since(newest[id]) <= maxAge {
+			continue
+		}
+		for _, o := range parts {
+			_ = st.Delete(ctx, o.Key)
+		}
+	}
+}
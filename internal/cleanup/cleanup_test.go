@@ -0,0 +1,72 @@
+package cleanup
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"comp/internal/storage"
+)
+
+func TestSweep(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	st, err := storage.NewLocal(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	for _, key := range []string{"outputs/old.mp4", "outputs/new.mp4", "incoming/t1/src.mp4"} {
+		if err := st.Put(ctx, key, strings.NewReader("x"), 1, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, key := range []string{"outputs/old.mp4", "incoming/t1/src.mp4"} {
+		if err := os.Chtimes(filepath.Join(root, key), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sweep(st, "outputs/", time.Hour)
+	sweep(st, "incoming/", orphanAge)
+
+	for key, kept := range map[string]bool{"outputs/old.mp4": false, "outputs/new.mp4": true, "incoming/t1/src.mp4": true} {
+		_, _, err := st.Get(ctx, key)
+		if (err == nil) != kept {
+			t.Errorf("%s: Get = %v, want kept=%v", key, err, kept)
+		}
+	}
+}
+
+func TestSweepUploads(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	st, err := storage.NewLocal(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []string{"partial/stale/00", "partial/stale/04", "partial/active/00", "partial/active/04"}
+	for _, key := range keys {
+		if err := st.Put(ctx, key, strings.NewReader("x"), 1, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-orphanAge - time.Minute)
+	// an upload in progress keeps its first chunk even when that is old
+	for _, key := range keys[:3] {
+		if err := os.Chtimes(filepath.Join(root, key), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sweepUploads(st, orphanAge)
+	for i, key := range keys {
+		_, _, err := st.Get(ctx, key)
+		if kept := i >= 2; (err == nil) != kept {
+			t.Errorf("%s: Get = %v, want kept=%v", key, err, kept)
+		}
+	}
+}
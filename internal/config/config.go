@@ -16,6 +16,111 @@ type Config struct {
 	RedisDB        int    `json:"redis_db"`
 	RedisPassword  string `json:"redis_password"`
 	LogLevel       string `json:"log_level"`
+	UploadsDir     string `json:"uploads_dir"`
+	// Workers is the number of jobs processed concurrently.
+	Workers int `json:"workers"`
+	// ShutdownTimeoutSeconds is how long SIGTERM waits for running jobs.
+	// Jobs still running then are requeued (RequeueOnShutdown) or marked
+	// interrupted.
+	ShutdownTimeoutSeconds int  `json:"shutdown_timeout_seconds"`
+	RequeueOnShutdown      bool `json:"requeue_on_shutdown"`
+	// APIKeys maps API key -> owner name. More keys can live in Redis.
+	APIKeys map[string]string `json:"api_keys"`
+	// AuthRequired rejects requests without a valid key or session.
+	AuthRequired  bool   `json:"auth_required"`
+	SessionSecret string `json:"session_secret"`
+	// Admins are owners allowed to list everybody's tasks via GET /tasks.
+	Admins []string `json:"admins"`
+	// TaskRetentionHours keeps finished task records (and their history
+	// listing) at least this long.
+	TaskRetentionHours int `json:"task_retention_hours"`
+	// TaskLogKB is how much ffmpeg/yt-dlp output is kept per task.
+	TaskLogKB int `json:"task_log_kb"`
+	// MaxBatchItems caps the URLs of a batch and the videos taken from its
+	// playlists.
+	MaxBatchItems int `json:"max_batch_items"`
+	// Per-client limits (API key owner, or IP when anonymous); 0 disables a limit.
+	RateLimitPerMinute int     `json:"rate_limit_per_minute"`
+	MaxActiveJobs      int     `json:"max_active_jobs"`
+	DailyQuotaMB       int64   `json:"daily_quota_mb"`
+	DailyQuotaMinutes  float64 `json:"daily_quota_minutes"`
+	// MaxUploadMB limits uploaded file size per processing type; "default"
+	// applies to types without their own entry.
+	MaxUploadMB map[string]int64 `json:"max_upload_mb"`
+	// DownloadSecret signs download links; share it between replicas.
+	DownloadSecret      string `json:"download_secret"`
+	DownloadLinkMinutes int    `json:"download_link_minutes"`
+	// WebhookSecret signs callback_url deliveries (HMAC-SHA256).
+	WebhookSecret      string `json:"webhook_secret"`
+	WebhookMaxAttempts int    `json:"webhook_max_attempts"`
+	// WebhookAllowPrivate permits callbacks to loopback and private networks.
+	WebhookAllowPrivate bool `json:"webhook_allow_private"`
+	// MetricsToken, when set, is required as a bearer token on /metrics.
+	MetricsToken string `json:"metrics_token"`
+	// Storage holds sources and outputs; the local backend uses UploadsDir.
+	Storage StorageConfig `json:"storage"`
+	Tracing TracingConfig `json:"tracing"`
+}
+
+type StorageConfig struct {
+	// Backend is "local" (default) or "s3".
+	Backend string   `json:"backend"`
+	S3      S3Config `json:"s3"`
+}
+
+// S3Config points at an S3-compatible service (AWS, MinIO, ...).
+type S3Config struct {
+	Endpoint  string `json:"endpoint"`
+	Region    string `json:"region"`
+	Bucket    string `json:"bucket"`
+	AccessKey string `json:"access_key"`
+	SecretKey string `json:"secret_key"`
+	UseSSL    bool   `json:"use_ssl"`
+	// Prefix is prepended to every key, so several deployments can share a bucket.
+	Prefix string `json:"prefix"`
+}
+
+// TracingConfig controls OpenTelemetry span export.
+type TracingConfig struct {
+	// Exporter is "otlp", "stdout" or empty to disable tracing.
+	Exporter string `json:"exporter"`
+	// Endpoint is the OTLP/HTTP collector as host:port; empty falls back to
+	// OTEL_EXPORTER_OTLP_ENDPOINT or localhost:4318.
+	Endpoint string `json:"endpoint"`
+	Insecure bool   `json:"insecure"`
+	// SampleRatio is the fraction of new traces kept; 0 means all.
+	SampleRatio float64 `json:"sample_ratio"`
+	ServiceName string  `json:"service_name"`
+}
+
+// UploadLimit returns the maximum upload size in bytes for a processing type.
+func (c Config) UploadLimit(pType string) int64 {
+	mb, ok := c.MaxUploadMB[pType]
+	if !ok {
+		mb = c.MaxUploadMB["default"]
+	}
+	return mb * 1024 * 1024
+}
+
+// IsAdmin reports whether owner is listed in Admins.
+func (c Config) IsAdmin(owner string) bool {
+	for _, a := range c.Admins {
+		if owner != "" && a == owner {
+			return true
+		}
+	}
+	return false
+}
+
+// MaxUploadLimit returns the largest per-type upload limit in bytes.
+func (c Config) MaxUploadLimit() int64 {
+	var max int64
+	for _, mb := range c.MaxUploadMB {
+		if mb > max {
+			max = mb
+		}
+	}
+	return max * 1024 * 1024
 }
 
 func Load() (Config, error) {
@@ -26,6 +131,22 @@ func Load() (Config, error) {
 		RedisAddr:      "localhost:6379",
 		RedisDB:        0,
 		LogLevel:       "info",
+		Workers:        2,
+
+		ShutdownTimeoutSeconds: 60,
+		RequeueOnShutdown:      true,
+
+		DownloadLinkMinutes: 60,
+		TaskRetentionHours:  24,
+		TaskLogKB:           64,
+		MaxBatchItems:       50,
+		WebhookMaxAttempts:  6,
+		RateLimitPerMinute:  30,
+		MaxActiveJobs:       3,
+		MaxUploadMB: map[string]int64{
+			"default":        2048,
+			"image_compress": 50,
+		},
 	}
 
 	paths := []string{"config.json", filepath.Join("web", "config.json")}
@@ -42,5 +163,14 @@ func Load() (Config, error) {
 		break
 	}
 	cfg.Proxy = strings.TrimSpace(cfg.Proxy)
+	if cfg.UploadsDir == "" {
+		cfg.UploadsDir = "uploads"
+		if st, err := os.Stat(filepath.Join("web", "uploads")); err == nil && st.IsDir() {
+			cfg.UploadsDir = filepath.Join("web", "uploads")
+		}
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = 1
+	}
 	return cfg, nil
 }
@@ -0,0 +1,69 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUploadLimit(t *testing.T) {
+	c := Config{MaxUploadMB: map[string]int64{"default": 100, "image_compress": 5, "video_compress": 300}}
+	tests := map[string]int64{"image_compress": 5 << 20, "video_to_gif": 100 << 20, "": 100 << 20}
+	for pType, want := range tests {
+		if got := c.UploadLimit(pType); got != want {
+			t.Errorf("UploadLimit(%q) = %d, want %d", pType, got, want)
+		}
+	}
+	if got := c.MaxUploadLimit(); got != 300<<20 {
+		t.Errorf("MaxUploadLimit = %d, want %d", got, 300<<20)
+	}
+}
+
+func TestIsAdmin(t *testing.T) {
+	c := Config{Admins: []string{"alice", ""}}
+	if !c.IsAdmin("alice") || c.IsAdmin("bob") {
+		t.Error("IsAdmin does not follow Admins")
+	}
+	// anonymous requests never get admin rights
+	if c.IsAdmin("") {
+		t.Error("anonymous owner is an admin")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Port != 3000 || cfg.UploadsDir != "uploads" || cfg.UploadLimit("image_compress") != 50<<20 {
+		t.Errorf("defaults = port %d, uploads %q, image limit %d", cfg.Port, cfg.UploadsDir, cfg.UploadLimit("image_compress"))
+	}
+
+	_ = os.MkdirAll(filepath.Join("web", "uploads"), 0o755)
+	err = os.WriteFile(filepath.Join("web", "config.json"), []byte(`{"port": 8080, "workers": -1, "proxy": " http://p:3128 "}`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err = Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Port != 8080 || cfg.Workers != 1 || cfg.Proxy != "http://p:3128" || cfg.UploadsDir != filepath.Join("web", "uploads") {
+		t.Errorf("loaded = port %d, workers %d, proxy %q, uploads %q", cfg.Port, cfg.Workers, cfg.Proxy, cfg.UploadsDir)
+	}
+
+	_ = os.WriteFile("config.json", []byte(`{"port": `), 0o644)
+	if _, err := Load(); err == nil {
+		t.Error("broken config.json accepted")
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package execx
+
+import "os/exec"
+
+// Without process groups only the direct child is killed on cancel.
+func setProcessGroup(cmd *exec.Cmd) {}
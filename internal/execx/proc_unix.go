@@ -0,0 +1,16 @@
+//go:build unix
+
+package execx
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		// negative pid signals the whole group
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
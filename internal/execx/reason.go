@@ -0,0 +1,66 @@
+package execx
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Error is a failed command together with a short, user-facing Reason
+// derived from its output.
+type Error struct {
+	Reason string
+	Err    error
+}
+
+func (e *Error) Error() string {
+	if e.Reason != "" {
+		return e.Reason
+	}
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// Rule maps output matching Match to Reason; Reason may refer to capture
+// groups as $1.
+type Rule struct {
+	Match  *regexp.Regexp
+	Reason string
+}
+
+// NewRule compiles pattern into a Rule.
+func NewRule(pattern, reason string) Rule {
+	return Rule{Match: regexp.MustCompile(pattern), Reason: reason}
+}
+
+// Reason returns the reason of the first rule matching output, checking
+// the newest lines first since tools print the fatal error last. Without a
+// match it returns "".
+func Reason(output string, rules []Rule) string {
+	lines := strings.Split(output, "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		for _, r := range rules {
+			if m := r.Match.FindStringSubmatchIndex(lines[i]); m != nil {
+				return string(r.Match.ExpandString(nil, r.Reason, lines[i], m))
+			}
+		}
+	}
+	return ""
+}
+
+// LastLine returns the last non-empty line of output for which keep
+// returns true, shortened to a readable length.
+func LastLine(output string, keep func(string) bool) string {
+	lines := strings.Split(output, "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		l := strings.TrimSpace(lines[i])
+		if l == "" || (keep != nil && !keep(l)) {
+			continue
+		}
+		if r := []rune(l); len(r) > 200 {
+			l = string(r[:200]) + "..."
+		}
+		return l
+	}
+	return ""
+}
@@ -0,0 +1,55 @@
+package execx
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+var testRules = []Rule{
+	NewRule(`No such file or directory`, "input file is missing"),
+	NewRule(`Unknown encoder '([^']+)'`, "encoder $1 is not available"),
+}
+
+func TestReason(t *testing.T) {
+	tests := []struct {
+		output string
+		want   string
+	}{
+		{"frame=1\nUnknown encoder 'libfoo'\n", "encoder libfoo is not available"},
+		// the newest matching line wins
+		{"Unknown encoder 'libfoo'\nin.mp4: No such file or directory\n", "input file is missing"},
+		{"frame=1\nConversion failed!\n", ""},
+	}
+	for _, tt := range tests {
+		if got := Reason(tt.output, testRules); got != tt.want {
+			t.Errorf("Reason(%q) = %q, want %q", tt.output, got, tt.want)
+		}
+	}
+}
+
+func TestLastLine(t *testing.T) {
+	out := "first\n[debug] noise\nreal error\n\n  \n"
+	if got := LastLine(out, nil); got != "real error" {
+		t.Errorf("LastLine = %q, want real error", got)
+	}
+	skipErr := func(l string) bool { return !strings.Contains(l, "error") }
+	if got := LastLine(out, skipErr); got != "[debug] noise" {
+		t.Errorf("LastLine with filter = %q", got)
+	}
+	long := strings.Repeat("é", 300)
+	if got := LastLine(long, nil); len([]rune(got)) != 203 {
+		t.Errorf("LastLine kept %d runes, want 200 and an ellipsis", len([]rune(got)))
+	}
+}
+
+func TestError(t *testing.T) {
+	base := errors.New("exit status 1")
+	e := &Error{Reason: "input file is missing", Err: base}
+	if e.Error() != "input file is missing" || !errors.Is(e, base) {
+		t.Errorf("Error = %q, unwraps to base: %v", e, errors.Is(e, base))
+	}
+	if (&Error{Err: base}).Error() != "exit status 1" {
+		t.Error("Error without a reason does not fall back to Err")
+	}
+}
@@ -2,15 +2,32 @@ package execx
 
 import (
 	"bytes"
+	"context"
 	"os/exec"
 )
 
 // Run runs an external command and returns stdout, stderr, and error.
 func Run(name string, args ...string) (string, string, error) {
-	cmd := exec.Command(name, args...)
+	return RunContext(context.Background(), name, args...)
+}
+
+// RunContext is Run with a context; cancelling it kills the whole process tree.
+func RunContext(ctx context.Context, name string, args ...string) (string, string, error) {
+	cmd := Command(ctx, name, args...)
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
+	end := Span(ctx, cmd)
 	err := cmd.Run()
+	end(err)
 	return stdout.String(), stderr.String(), err
 }
+
+// Command is exec.CommandContext, but the child gets its own process group
+// and cancellation kills the group, so helpers spawned by the child
+// (e.g. ffmpeg launched by yt-dlp) die with it.
+func Command(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	setProcessGroup(cmd)
+	return cmd
+}
@@ -0,0 +1,62 @@
+package execx
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// TailSize is how much output Tail keeps by default.
+const TailSize = 256 << 10
+
+// Tail is an io.Writer that keeps only the last Size bytes written to it,
+// for capturing the stderr of long-running tools.
+type Tail struct {
+	Size int
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (t *Tail) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	size := t.Size
+	if size <= 0 {
+		size = TailSize
+	}
+	n := len(p)
+	if len(p) >= size {
+		t.buf = append(t.buf[:0], p[len(p)-size:]...)
+		return n, nil
+	}
+	if over := len(t.buf) + len(p) - size; over > 0 {
+		t.buf = append(t.buf[:0], t.buf[over:]...)
+	}
+	t.buf = append(t.buf, p...)
+	return n, nil
+}
+
+func (t *Tail) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return string(t.buf)
+}
+
+// Transcript formats a finished command for a task log: the command line,
+// its captured output and how it ended.
+func Transcript(cmd *exec.Cmd, output string, err error) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "$ %s\n", strings.Join(redactArgs(cmd.Args), " "))
+	b.WriteString(output)
+	if output != "" && !strings.HasSuffix(output, "\n") {
+		b.WriteByte('\n')
+	}
+	if err != nil {
+		fmt.Fprintf(&b, "[%v]\n", err)
+	} else {
+		b.WriteString("[exit status 0]\n")
+	}
+	return b.String()
+}
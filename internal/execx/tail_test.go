@@ -0,0 +1,43 @@
+package execx
+
+import (
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestTail(t *testing.T) {
+	tail := &Tail{Size: 8}
+	for _, s := range []string{"abc", "defg", "hij"} {
+		if n, err := tail.Write([]byte(s)); n != len(s) || err != nil {
+			t.Fatalf("Write(%q) = %d, %v", s, n, err)
+		}
+	}
+	if got := tail.String(); got != "cdefghij" {
+		t.Errorf("String = %q, want cdefghij", got)
+	}
+	// a write larger than Size replaces everything
+	_, _ = tail.Write([]byte("0123456789"))
+	if got := tail.String(); got != "23456789" {
+		t.Errorf("String = %q, want 23456789", got)
+	}
+}
+
+func TestTailDefaultSize(t *testing.T) {
+	var tail Tail
+	_, _ = tail.Write([]byte(strings.Repeat("a", TailSize)))
+	_, _ = tail.Write([]byte("b"))
+	if got := tail.String(); len(got) != TailSize || !strings.HasSuffix(got, "ab") {
+		t.Errorf("kept %d bytes ending in %q, want %d", len(got), got[len(got)-2:], TailSize)
+	}
+}
+
+func TestTranscript(t *testing.T) {
+	cmd := exec.Command("yt-dlp", "--proxy", "http://user:pw@proxy:8080", "URL")
+	got := Transcript(cmd, "some output", errors.New("exit status 1"))
+	want := "$ yt-dlp --proxy REDACTED URL\nsome output\n[exit status 1]\n"
+	if got != want {
+		t.Errorf("Transcript = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,49 @@
+package execx
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"path/filepath"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"comp/internal/tracing"
+)
+
+var tracer = otel.Tracer("comp/internal/execx")
+
+// Span starts a span for cmd, named after the binary and carrying its
+// arguments. Call the returned func with the result of Run or Wait; it
+// records the exit code and ends the span.
+func Span(ctx context.Context, cmd *exec.Cmd) func(error) {
+	name := filepath.Base(cmd.Path)
+	_, span := tracer.Start(ctx, "exec "+name, trace.WithAttributes(
+		attribute.String("process.executable.name", name),
+		attribute.StringSlice("process.command_args", redactArgs(cmd.Args)),
+	))
+	return func(err error) {
+		if cmd.ProcessState != nil {
+			span.SetAttributes(attribute.Int("process.exit.code", cmd.ProcessState.ExitCode()))
+		}
+		var exitErr *exec.ExitError
+		if ctx.Err() != nil && errors.As(err, &exitErr) {
+			err = ctx.Err()
+		}
+		tracing.End(span, err)
+	}
+}
+
+// redactArgs hides values that may carry credentials, like proxy URLs.
+func redactArgs(args []string) []string {
+	out := make([]string, len(args))
+	copy(out, args)
+	for i := 1; i < len(out); i++ {
+		if out[i-1] == "--proxy" {
+			out[i] = "REDACTED"
+		}
+	}
+	return out
+}
@@ -0,0 +1,328 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"comp/internal/auth"
+	"comp/internal/jobs"
+	"comp/internal/limits"
+	"comp/internal/store"
+)
+
+// apiError is the error envelope of /api/v1:
+// {"error": {"code": "not_found", "message": "task not found"}}.
+type apiError struct {
+	Error apiErrorBody `json:"error"`
+}
+
+type apiErrorBody struct {
+	// Code is stable and meant for programs; Message is for humans.
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// errorCodes maps HTTP statuses to the codes /api/v1 clients switch on.
+var errorCodes = map[int]string{
+	http.StatusBadRequest:            "invalid_request",
+	http.StatusUnauthorized:          "unauthorized",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not_found",
+	http.StatusConflict:              "conflict",
+	http.StatusRequestEntityTooLarge: "file_too_large",
+	http.StatusUnsupportedMediaType:  "unsupported_media_type",
+	http.StatusUnprocessableEntity:   "unprocessable",
+	http.StatusTooManyRequests:       "rate_limited",
+	http.StatusInternalServerError:   "internal_error",
+	http.StatusServiceUnavailable:    "unavailable",
+}
+
+func apiFail(c *gin.Context, status int, msg string) {
+	code, ok := errorCodes[status]
+	if !ok {
+		code = "error"
+	}
+	c.AbortWithStatusJSON(status, apiError{apiErrorBody{Code: code, Message: msg}})
+}
+
+// apiFailErr renders the errors of the shared submit path.
+func apiFailErr(c *gin.Context, err error) {
+	var ue *uploadError
+	var le *limits.LimitError
+	switch {
+	case errors.As(err, &ue):
+		apiFail(c, ue.status, ue.msg)
+	case errors.As(err, &le):
+		c.Header("Retry-After", strconv.Itoa(le.RetryAfterSeconds()))
+		apiFail(c, http.StatusTooManyRequests, le.Reason)
+	default:
+		apiFail(c, http.StatusInternalServerError, "internal error")
+	}
+}
+
+// createTaskRequest is the JSON body of POST /api/v1/tasks. Multipart
+// requests use the same fields plus a "file" part.
+type createTaskRequest struct {
+	Type string `json:"type"`
+	URL  string `json:"url,omitempty"`
+	// URLs and Playlist make a batch task with one item per link or
+	// playlist entry, each processed as Type.
+	URLs     []string `json:"urls,omitempty"`
+	Playlist bool     `json:"playlist,omitempty"`
+	// FormatID picks a format listed by /info (e.g. "137+bestaudio");
+	// otherwise MaxHeight limits the resolution downloaded from the URL.
+	FormatID  string `json:"format_id,omitempty"`
+	MaxHeight int    `json:"max_height,omitempty"`
+	// Start, End or Duration clip the source (seconds or [hh:]mm:ss) for
+	// video_compress, video_to_gif and video_to_audio.
+	Start    timeParam `json:"start,omitempty"`
+	End      timeParam `json:"end,omitempty"`
+	Duration timeParam `json:"duration,omitempty"`
+	// Scaling and quality for videos, GIFs and images; crf 0 or omitted
+	// takes the video codec's default.
+	CRF     int `json:"crf,omitempty"`
+	Width   int `json:"width,omitempty"`
+	FPS     int `json:"fps,omitempty"`
+	Quality int `json:"quality,omitempty"`
+	// TargetSizeMB is required for video_target_size.
+	TargetSizeMB float64 `json:"target_size_mb,omitempty"`
+	ImgFormat    string  `json:"img_format,omitempty"`
+	VideoCodec   string  `json:"video_codec,omitempty"`
+	Preset       string  `json:"preset,omitempty"`
+	Container    string  `json:"container,omitempty"`
+	AudioCodec   string  `json:"audio_codec,omitempty"`
+	AudioBitrate string  `json:"audio_bitrate,omitempty"`
+	// Animation settings for video_to_gif: gif, webp or apng output, palette
+	// colours, stats_mode and dither (gif only), plays (0 = forever) and a
+	// size cap reached by lowering fps, width and colours.
+	AnimFormat string  `json:"anim_format,omitempty"`
+	Colors     int     `json:"colors,omitempty"`
+	StatsMode  string  `json:"stats_mode,omitempty"`
+	Dither     string  `json:"dither,omitempty"`
+	Loop       int     `json:"loop,omitempty"`
+	MaxSizeMB  float64 `json:"max_size_mb,omitempty"`
+	// Audio settings for video_to_audio: mp3, m4a, opus, flac or wav with
+	// audio_bitrate as CBR or VBR target, optional two-pass loudness
+	// normalization to lufs, silence trimming and tags from the URL's info.
+	AudioFormat string  `json:"audio_format,omitempty"`
+	BitrateMode string  `json:"bitrate_mode,omitempty"`
+	SampleRate  int     `json:"sample_rate,omitempty"`
+	Channels    int     `json:"channels,omitempty"`
+	Loudnorm    bool    `json:"loudnorm,omitempty"`
+	LUFS        float64 `json:"lufs,omitempty"`
+	TrimSilence bool    `json:"trim_silence,omitempty"`
+	Metadata    bool    `json:"metadata,omitempty"`
+	// CallbackURL gets a signed POST when the task completes, fails or is cancelled.
+	CallbackURL string `json:"callback_url,omitempty"`
+}
+
+// timeParam is a position given either as a JSON number of seconds or as a
+// string such as "1:30"; jobFromParams parses it.
+type timeParam string
+
+func (t *timeParam) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*t = timeParam(s)
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(b, &n); err != nil {
+		return err
+	}
+	*t = timeParam(n)
+	return nil
+}
+
+// params exposes the request like a form, so jobFromParams validates both.
+// Fields left at their zero value read as empty, like absent form fields;
+// numbers are written out in full so large values parse back unchanged.
+func (r createTaskRequest) params() func(string) string {
+	m := map[string]string{}
+	v := reflect.ValueOf(r)
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i)
+		if f.IsZero() {
+			continue
+		}
+		name, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("json"), ",")
+		switch f.Kind() {
+		case reflect.String:
+			m[name] = f.String()
+		case reflect.Int:
+			m[name] = strconv.FormatInt(f.Int(), 10)
+		case reflect.Float64:
+			m[name] = strconv.FormatFloat(f.Float(), 'f', -1, 64)
+		case reflect.Bool:
+			m[name] = strconv.FormatBool(f.Bool())
+		case reflect.Slice:
+			m[name] = strings.Join(f.Interface().([]string), "\n")
+		}
+	}
+	return func(k string) string { return m[k] }
+}
+
+// taskResource is a task as returned by /api/v1.
+type taskResource struct {
+	*store.TaskStatus
+	Links taskLinks `json:"links"`
+}
+
+type taskLinks struct {
+	Self     string `json:"self"`
+	Events   string `json:"events"`
+	Download string `json:"download,omitempty"`
+}
+
+type taskList struct {
+	Tasks  []taskResource `json:"tasks"`
+	Limit  int            `json:"limit"`
+	Offset int            `json:"offset"`
+	// Next is the offset of the following page; it is absent on the last one.
+	Next int `json:"next,omitempty"`
+}
+
+func resource(c *gin.Context, d Deps, links *linkSigner, t *store.TaskStatus) taskResource {
+	decorate(c, d, links, t)
+	id := url.PathEscape(t.ID)
+	return taskResource{TaskStatus: t, Links: taskLinks{
+		Self:     "/api/v1/tasks/" + id,
+		Events:   "/api/v1/tasks/" + id + "/events",
+		Download: t.DownloadURL,
+	}}
+}
+
+func registerAPIv1(r *gin.Engine, d Deps, links *linkSigner) {
+	g := r.Group("/api/v1", d.Auth.MiddlewareFunc(apiFail))
+	v := &apiRoutes{group: g}
+
+	v.handle(apiOp{
+		Method:    http.MethodPost,
+		Path:      "/tasks",
+		Summary:   "Create a task from a URL (JSON or form) or an uploaded file (multipart)",
+		Body:      createTaskRequest{},
+		Multipart: true,
+		Status:    http.StatusAccepted,
+		Response:  taskResource{},
+		Errors:    []int{400, 401, 413, 415, 429, 503},
+	}, func(c *gin.Context) {
+		if err := d.Limits.Allow(c.Request.Context(), limits.Client(c)); err != nil {
+			apiFailErr(c, err)
+			return
+		}
+		var j jobs.Job
+		if c.ContentType() == "application/json" {
+			var req createTaskRequest
+			if err := c.ShouldBindJSON(&req); err != nil {
+				apiFail(c, http.StatusBadRequest, "invalid JSON body")
+				return
+			}
+			if strings.TrimSpace(req.URL) == "" && len(req.URLs) == 0 {
+				apiFail(c, http.StatusBadRequest, "url is required; send files as multipart/form-data or via /uploads")
+				return
+			}
+			var err error
+			if j, err = jobFromParams(c.Request.Context(), req.params(), ""); err == nil {
+				j.ID = uuid.New().String()
+				err = submitJob(c, d, &j, nil)
+			}
+			if err != nil {
+				apiFailErr(c, err)
+				return
+			}
+		} else {
+			var err error
+			if j, err = submitForm(c, d); err != nil {
+				apiFailErr(c, err)
+				return
+			}
+		}
+		t, ok := d.Store.Get(c.Request.Context(), j.ID)
+		if !ok {
+			t = &store.TaskStatus{ID: j.ID, Status: "queued"}
+		}
+		res := resource(c, d, links, t)
+		c.Header("Location", res.Links.Self)
+		c.JSON(http.StatusAccepted, res)
+	})
+
+	v.handle(apiOp{
+		Method:   http.MethodGet,
+		Path:     "/tasks/:id",
+		Summary:  "Get a task",
+		Response: taskResource{},
+		Errors:   []int{401, 404},
+	}, func(c *gin.Context) {
+		t, ok := loadOwned(c, d, c.Param("id"))
+		if !ok {
+			apiFail(c, http.StatusNotFound, "task not found")
+			return
+		}
+		c.JSON(http.StatusOK, resource(c, d, links, t))
+	})
+
+	v.handle(apiOp{
+		Method:   http.MethodGet,
+		Path:     "/tasks/:id/events",
+		Summary:  "Stream a task's progress as Server-Sent Events until it finishes",
+		Response: store.TaskStatus{},
+		Stream:   true,
+		Errors:   []int{401, 404},
+	}, taskEvents(d, links, apiFail))
+
+	v.handle(apiOp{
+		Method:  http.MethodGet,
+		Path:    "/tasks",
+		Summary: "List the caller's tasks, newest first",
+		Query: []apiParam{
+			{Name: "status", Description: "only tasks in this status (queued, processing, completed, failed, cancelled, interrupted)"},
+			{Name: "type", Description: "only tasks of this processing type"},
+			{Name: "limit", Description: "page size, 1-100 (default 20)", Integer: true},
+			{Name: "offset", Description: "number of tasks to skip; the next field of a page gives the offset of the following one", Integer: true},
+		},
+		Response: taskList{},
+		Errors:   []int{400, 401, 503},
+	}, func(c *gin.Context) {
+		limit, offset := 20, 0
+		var err error
+		if s := c.Query("limit"); s != "" {
+			if limit, err = strconv.Atoi(s); err != nil || limit < 1 || limit > 100 {
+				apiFail(c, http.StatusBadRequest, "limit must be between 1 and 100")
+				return
+			}
+		}
+		if s := c.Query("offset"); s != "" {
+			if offset, err = strconv.Atoi(s); err != nil || offset < 0 {
+				apiFail(c, http.StatusBadRequest, "offset must be a non-negative integer")
+				return
+			}
+		}
+		status, pType := c.Query("status"), c.Query("type")
+		ctx := c.Request.Context()
+		owner := auth.Owner(c)
+		q := store.TaskQuery{Owner: &owner, Status: status, Type: pType, Offset: offset, Limit: limit}
+		tasks, next, err := d.Store.List(ctx, q)
+		if err != nil {
+			apiFail(c, http.StatusServiceUnavailable, "failed to list tasks")
+			return
+		}
+		out := taskList{Tasks: []taskResource{}, Limit: limit, Offset: offset, Next: next}
+		for _, t := range tasks {
+			out.Tasks = append(out.Tasks, resource(c, d, links, t))
+		}
+		c.JSON(http.StatusOK, out)
+	})
+
+	spec := v.openAPI()
+	r.GET("/api/v1/openapi.json", func(c *gin.Context) {
+		c.JSON(http.StatusOK, spec)
+	})
+}
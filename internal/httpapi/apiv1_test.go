@@ -0,0 +1,112 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"comp/internal/auth"
+	"comp/internal/store"
+)
+
+func TestCreateTaskRequestParams(t *testing.T) {
+	req := createTaskRequest{
+		Type:         "video_target_size",
+		URLs:         []string{"https://a.example/1", "https://a.example/2"},
+		Width:        10000000,
+		TargetSizeMB: 1e6,
+		MaxSizeMB:    0.25,
+		Loudnorm:     true,
+		Start:        "1:30",
+	}
+	get := req.params()
+	tests := map[string]string{
+		"type":           "video_target_size",
+		"urls":           "https://a.example/1\nhttps://a.example/2",
+		"width":          "10000000",
+		"target_size_mb": "1000000",
+		"max_size_mb":    "0.25",
+		"loudnorm":       "true",
+		"start":          "1:30",
+		// zero values read like absent form fields
+		"crf":      "",
+		"playlist": "",
+		"url":      "",
+	}
+	for k, want := range tests {
+		if got := get(k); got != want {
+			t.Errorf("params(%q) = %q, want %q", k, got, want)
+		}
+	}
+}
+
+func TestCreateTaskRequestLargeNumbers(t *testing.T) {
+	req := createTaskRequest{Type: "video_target_size", URL: "https://a.example/v", TargetSizeMB: 2e6, Width: 1e7}
+	j, err := jobFromParams(context.Background(), req.params(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j.TargetSizeMB != 2e6 || j.Width != 1e7 {
+		t.Errorf("job has target_size_mb %v, width %d; want 2e6, 1e7", j.TargetSizeMB, j.Width)
+	}
+}
+
+func TestOpenAPIListsEvents(t *testing.T) {
+	spec := testSpec(t, NewRouter(testDeps(t)))
+	paths := spec["paths"].(map[string]any)
+	ev, ok := paths["/api/v1/tasks/{id}/events"].(map[string]any)
+	if !ok {
+		keys := make([]string, 0, len(paths))
+		for k := range paths {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		t.Fatalf("events path missing from %v", keys)
+	}
+	get := ev["get"].(map[string]any)
+	content := get["responses"].(map[string]any)["200"].(map[string]any)["content"].(map[string]any)
+	if _, ok := content["text/event-stream"]; !ok {
+		t.Errorf("events response is %v, want text/event-stream", content)
+	}
+}
+
+func TestAPIListTasksPages(t *testing.T) {
+	ctx := context.Background()
+	d := testDeps(t)
+	d.Auth = auth.New(map[string]string{"ka": "alice"}, "test", true, nil, nil)
+	for _, id := range []string{"t1", "t2", "t3"} {
+		_ = d.Store.SetOwner(ctx, id, "alice", 0)
+		_ = d.Store.Set(ctx, &store.TaskStatus{ID: id, Status: "completed"}, time.Hour)
+		time.Sleep(2 * time.Millisecond) // distinct creation times
+	}
+	r := NewRouter(d)
+	page := func(query string) ([]string, int) {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/tasks"+query, nil)
+		req.Header.Set("Authorization", "Bearer ka")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s: %d %s", query, w.Code, w.Body)
+		}
+		var body struct {
+			Tasks []store.TaskStatus
+			Next  int
+		}
+		_ = json.Unmarshal(w.Body.Bytes(), &body)
+		var ids []string
+		for _, t := range body.Tasks {
+			ids = append(ids, t.ID)
+		}
+		return ids, body.Next
+	}
+	if ids, next := page("?limit=2"); !slices.Equal(ids, []string{"t3", "t2"}) || next != 2 {
+		t.Errorf("first page = %v, next %d; want [t3 t2], next 2", ids, next)
+	}
+	if ids, next := page("?limit=2&offset=2"); !slices.Equal(ids, []string{"t1"}) || next != 0 {
+		t.Errorf("last page = %v, next %d; want [t1] without next", ids, next)
+	}
+}
@@ -0,0 +1,209 @@
+package httpapi
+
+import (
+	"archive/zip"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"comp/internal/storage"
+	"comp/internal/store"
+)
+
+// linkSigner issues and checks expiring download URLs of the form
+// /tasks/:id/download?expires=<unix>&sig=<hmac(id|expires)>.
+type linkSigner struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+func newLinkSigner(secret string, ttl time.Duration) *linkSigner {
+	s := &linkSigner{secret: []byte(secret), ttl: ttl}
+	if len(s.secret) == 0 {
+		// links then stop working after a restart and across replicas
+		s.secret = make([]byte, 32)
+		_, _ = rand.Read(s.secret)
+	}
+	if s.ttl <= 0 {
+		s.ttl = time.Hour
+	}
+	return s
+}
+
+func (s *linkSigner) sign(id string, expires int64) string {
+	m := hmac.New(sha256.New, s.secret)
+	m.Write([]byte(id + "|" + strconv.FormatInt(expires, 10)))
+	return base64.RawURLEncoding.EncodeToString(m.Sum(nil))
+}
+
+// URL returns a signed download path for task id.
+func (s *linkSigner) URL(id string) string {
+	exp := time.Now().Add(s.ttl).Unix()
+	q := url.Values{"expires": {strconv.FormatInt(exp, 10)}, "sig": {s.sign(id, exp)}}
+	return "/tasks/" + url.PathEscape(id) + "/download?" + q.Encode()
+}
+
+// Valid checks signature and expiry of a download request.
+func (s *linkSigner) Valid(id, expires, sig string) bool {
+	exp, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > exp {
+		return false
+	}
+	return hmac.Equal([]byte(sig), []byte(s.sign(id, exp)))
+}
+
+// decorate fills the read-only fields of a task before it is sent to its owner.
+func decorate(c *gin.Context, d Deps, links *linkSigner, t *store.TaskStatus) {
+	if t.Status == "queued" && d.Queue != nil {
+		t.QueuePosition, _ = d.Queue.Position(c.Request.Context(), t.ID)
+	}
+	if t.Type == "batch" {
+		decorateBatch(c, d, links, t)
+	}
+	if t.Status == "completed" && (t.StoredFile != "" || t.Type == "batch") {
+		t.DownloadURL = links.URL(t.ID)
+	}
+	t.StoredFile = ""
+}
+
+// decorateBatch lists the items of batch t with their own links. While the
+// batch runs its percent is recomputed from them, as the stored one only
+// moves when an item finishes.
+func decorateBatch(c *gin.Context, d Deps, links *linkSigner, t *store.TaskStatus) {
+	sum := 0
+	for _, id := range t.Children {
+		it := store.BatchItem{ID: id, Status: "expired", Percent: 100}
+		if ct, ok := d.Store.Get(c.Request.Context(), id); ok {
+			it = store.BatchItem{ID: id, Status: ct.Status, Percent: ct.Percent, SourceName: ct.SourceName,
+				OutputFile: ct.OutputFile, Error: ct.Error}
+			if store.Finished(ct.Status) {
+				it.Percent = 100
+			}
+			if ct.Status == "completed" && ct.StoredFile != "" {
+				it.DownloadURL = links.URL(id)
+			}
+		}
+		sum += it.Percent
+		t.Items = append(t.Items, it)
+	}
+	if t.Status == "processing" && t.Stage == "items" && len(t.Children) > 0 {
+		t.Percent = sum / len(t.Children)
+	}
+}
+
+// downloadTask serves a finished output to holders of a valid signed link.
+// Backends with their own signed URLs get a redirect; otherwise the object is
+// streamed through http.ServeContent, so Range requests and resumes work.
+func downloadTask(d Deps, links *linkSigner) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		if !links.Valid(id, c.Query("expires"), c.Query("sig")) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "invalid or expired link"})
+			return
+		}
+		ctx := c.Request.Context()
+		t, ok := d.Store.Get(ctx, id)
+		if ok && t.Type == "batch" && t.Status == "completed" {
+			downloadBatch(c, d, t)
+			return
+		}
+		if !ok || t.Status != "completed" || t.StoredFile == "" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+			return
+		}
+		c.Header("Cache-Control", "private, no-store")
+		if u, err := d.Storage.SignedURL(ctx, t.StoredFile, t.OutputFile, 5*time.Minute); err == nil {
+			c.Redirect(http.StatusFound, u)
+			return
+		} else if !errors.Is(err, storage.ErrNoSignedURL) && d.Logger != nil {
+			d.Logger.Warnf("signed url for %s: %v", id, err)
+		}
+		obj, info, err := d.Storage.Get(ctx, t.StoredFile)
+		if errors.Is(err, storage.ErrNotFound) {
+			c.JSON(http.StatusGone, gin.H{"error": "file expired"})
+			return
+		}
+		if err != nil {
+			if d.Logger != nil {
+				d.Logger.Errorf("read %s: %v", t.StoredFile, err)
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read file"})
+			return
+		}
+		defer obj.Close()
+		c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": t.OutputFile}))
+		http.ServeContent(c.Writer, c.Request, t.OutputFile, info.ModTime, obj)
+	}
+}
+
+// downloadBatch streams the completed items of batch t as one ZIP. Media is
+// already compressed, so entries are stored as they are.
+func downloadBatch(c *gin.Context, d Deps, t *store.TaskStatus) {
+	ctx := c.Request.Context()
+	c.Header("Cache-Control", "private, no-store")
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": "batch-" + shortID(t.ID) + ".zip"}))
+	zw := zip.NewWriter(c.Writer)
+	names := map[string]int{}
+	for _, id := range t.Children {
+		ct, ok := d.Store.Get(ctx, id)
+		if !ok || ct.Status != "completed" || ct.StoredFile == "" {
+			continue
+		}
+		obj, info, err := d.Storage.Get(ctx, ct.StoredFile)
+		if err != nil {
+			if !errors.Is(err, storage.ErrNotFound) && d.Logger != nil {
+				d.Logger.Warnf("zip %s: read %s: %v", t.ID, ct.StoredFile, err)
+			}
+			continue
+		}
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: uniqueName(names, ct.OutputFile), Method: zip.Store, Modified: info.ModTime})
+		if err == nil {
+			_, err = io.Copy(w, obj)
+		}
+		obj.Close()
+		if err != nil {
+			// the client went away or storage failed mid-stream; the archive is cut short
+			if d.Logger != nil {
+				d.Logger.Warnf("zip %s: %v", t.ID, err)
+			}
+			return
+		}
+	}
+	_ = zw.Close()
+}
+
+// uniqueName returns name, or name with a counter before the extension if
+// it was used before.
+func uniqueName(seen map[string]int, name string) string {
+	if name == "" {
+		name = "file"
+	}
+	n := seen[name]
+	seen[name] = n + 1
+	if n == 0 {
+		return name
+	}
+	ext := path.Ext(name)
+	return fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(name, ext), n, ext)
+}
+
+func shortID(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
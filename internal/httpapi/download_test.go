@@ -0,0 +1,156 @@
+package httpapi
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"comp/internal/store"
+)
+
+func TestLinkSigner(t *testing.T) {
+	s := newLinkSigner("secret", time.Hour)
+	u, err := url.Parse(s.URL("t1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Path != "/tasks/t1/download" {
+		t.Errorf("path = %q", u.Path)
+	}
+	exp, sig := u.Query().Get("expires"), u.Query().Get("sig")
+	if !s.Valid("t1", exp, sig) {
+		t.Fatal("own link rejected")
+	}
+	past := time.Now().Add(-time.Minute).Unix()
+	tests := map[string][3]string{
+		"other task":      {"t2", exp, sig},
+		"later expiry":    {"t1", exp + "0", sig},
+		"bad signature":   {"t1", exp, "A" + sig},
+		"no expiry":       {"t1", "", sig},
+		"expired":         {"t1", strconv.FormatInt(past, 10), s.sign("t1", past)},
+		"other secret":    {"t1", exp, newLinkSigner("other", time.Hour).sign("t1", mustInt(t, exp))},
+		"empty signature": {"t1", exp, ""},
+	}
+	for name, tt := range tests {
+		if s.Valid(tt[0], tt[1], tt[2]) {
+			t.Errorf("%s: link accepted", name)
+		}
+	}
+}
+
+func mustInt(t *testing.T, s string) int64 {
+	t.Helper()
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestDownloadBatchZip(t *testing.T) {
+	ctx := context.Background()
+	d := testDeps(t)
+	for key, content := range map[string]string{"batches/b1/x1.mp3": "one", "batches/b1/x2.mp3": "two", "batches/b1/x4.mp3": "four"} {
+		if err := d.Storage.Put(ctx, key, strings.NewReader(content), int64(len(content)), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, c := range []store.TaskStatus{
+		{ID: "i1", Status: "completed", OutputFile: "song.mp3", StoredFile: "batches/b1/x1.mp3"},
+		// same name as the first item
+		{ID: "i2", Status: "completed", OutputFile: "song.mp3", StoredFile: "batches/b1/x2.mp3"},
+		{ID: "i3", Status: "failed", Error: "boom"},
+		{ID: "i4", Status: "cancelled", OutputFile: "late.mp3", StoredFile: "batches/b1/x4.mp3"},
+		// output already swept
+		{ID: "i5", Status: "completed", OutputFile: "gone.mp3", StoredFile: "batches/b1/x5.mp3"},
+	} {
+		_ = d.Store.Set(ctx, &c, time.Hour)
+	}
+	_ = d.Store.Set(ctx, &store.TaskStatus{ID: "b1", Type: "batch", Status: "completed", Children: []string{"i1", "i2", "i3", "i4", "i5"}}, time.Hour)
+	links := newLinkSigner(d.Cfg.DownloadSecret, time.Hour)
+
+	w := httptest.NewRecorder()
+	NewRouter(d).ServeHTTP(w, httptest.NewRequest(http.MethodGet, links.URL("b1"), nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("download: %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(rc)
+		rc.Close()
+		got = append(got, f.Name+"="+string(b))
+	}
+	if want := []string{"song.mp3=one", "song (1).mp3=two"}; !slices.Equal(got, want) {
+		t.Errorf("zip holds %v, want %v", got, want)
+	}
+}
+
+func TestLinkSignerRandomSecret(t *testing.T) {
+	a, b := newLinkSigner("", 0), newLinkSigner("", 0)
+	if a.ttl != time.Hour {
+		t.Errorf("default ttl = %s, want 1h", a.ttl)
+	}
+	if a.sign("t1", 1) == b.sign("t1", 1) {
+		t.Error("signers without a secret share one")
+	}
+}
+
+func TestDownloadTask(t *testing.T) {
+	ctx := context.Background()
+	d := testDeps(t)
+	content := "0123456789"
+	if err := d.Storage.Put(ctx, "outputs/t1.mp4", strings.NewReader(content), int64(len(content)), "video/mp4"); err != nil {
+		t.Fatal(err)
+	}
+	_ = d.Store.Set(ctx, &store.TaskStatus{ID: "t1", Status: "completed", OutputFile: "clip.mp4", StoredFile: "outputs/t1.mp4"}, time.Hour)
+	_ = d.Store.Set(ctx, &store.TaskStatus{ID: "gone", Status: "completed", OutputFile: "old.mp4", StoredFile: "outputs/gone.mp4"}, time.Hour)
+	r := NewRouter(d)
+	links := newLinkSigner(d.Cfg.DownloadSecret, time.Hour)
+
+	get := func(target, rng string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if rng != "" {
+			req.Header.Set("Range", rng)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get(links.URL("t1"), "")
+	if w.Code != http.StatusOK || w.Body.String() != content {
+		t.Fatalf("download: %d %q", w.Code, w.Body)
+	}
+	if cd := w.Header().Get("Content-Disposition"); !strings.Contains(cd, "clip.mp4") {
+		t.Errorf("Content-Disposition = %q", cd)
+	}
+	if w := get(links.URL("t1"), "bytes=4-"); w.Code != http.StatusPartialContent || w.Body.String() != "456789" {
+		t.Errorf("range: %d %q", w.Code, w.Body)
+	}
+	if w := get("/tasks/t1/download?expires=9999999999&sig=x", ""); w.Code != http.StatusForbidden {
+		t.Errorf("forged link: %d, want 403", w.Code)
+	}
+	if w := get(links.URL("gone"), ""); w.Code != http.StatusGone {
+		t.Errorf("deleted output: %d, want 410", w.Code)
+	}
+	if w := get(links.URL("missing"), ""); w.Code != http.StatusNotFound {
+		t.Errorf("unknown task: %d, want 404", w.Code)
+	}
+}
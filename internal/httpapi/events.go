@@ -0,0 +1,73 @@
+package httpapi
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"comp/internal/jobs"
+	"comp/internal/store"
+)
+
+// taskEvents streams stage/percent changes of a task as Server-Sent Events.
+// The stream ends once the task reaches a terminal status. fail writes the
+// errors in the caller's API shape.
+func taskEvents(d Deps, links *linkSigner, fail func(c *gin.Context, status int, msg string)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		ctx := c.Request.Context()
+		if _, ok := loadOwned(c, d, id); !ok {
+			fail(c, http.StatusNotFound, "not found")
+			return
+		}
+		// subscribe before reading the snapshot so no update falls in between
+		updates, stop := d.Store.Subscribe(ctx, id)
+		defer stop()
+		t, ok := d.Store.Get(ctx, id)
+		if !ok {
+			fail(c, http.StatusNotFound, "not found")
+			return
+		}
+		c.Header("Cache-Control", "no-cache")
+		c.Header("X-Accel-Buffering", "no")
+
+		last := *t
+		decorate(c, d, links, &last)
+		c.SSEvent("progress", last)
+		c.Writer.Flush()
+		if jobs.Finished(last.Status) {
+			return
+		}
+		heartbeat := time.NewTicker(15 * time.Second)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-heartbeat.C:
+				// comment line keeps proxies from closing an idle stream
+				_, _ = c.Writer.WriteString(": ping\n\n")
+				c.Writer.Flush()
+			case u, ok := <-updates:
+				if !ok {
+					return
+				}
+				if sameProgress(last, u) {
+					continue
+				}
+				decorate(c, d, links, &u)
+				last = u
+				c.SSEvent("progress", u)
+				c.Writer.Flush()
+				if jobs.Finished(u.Status) {
+					return
+				}
+			}
+		}
+	}
+}
+
+func sameProgress(a, b store.TaskStatus) bool {
+	return a.Status == b.Status && a.Stage == b.Stage && a.Percent == b.Percent
+}
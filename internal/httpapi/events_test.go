@@ -0,0 +1,110 @@
+package httpapi
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"comp/internal/store"
+)
+
+// readEvents returns the progress events of an SSE stream until it ends,
+// calling each after every event.
+func readEvents(t *testing.T, url string, each func(store.TaskStatus)) []store.TaskStatus {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		t.Fatalf("GET %s: %d %s", url, resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	var events []store.TaskStatus
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() {
+		data, ok := strings.CutPrefix(sc.Text(), "data:")
+		if !ok {
+			continue
+		}
+		var ev store.TaskStatus
+		if err := json.Unmarshal([]byte(data), &ev); err != nil {
+			t.Fatalf("event %q: %v", data, err)
+		}
+		events = append(events, ev)
+		if each != nil {
+			each(ev)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		t.Fatalf("stream did not end: %v", err)
+	}
+	return events
+}
+
+func TestTaskEvents(t *testing.T) {
+	ctx := context.Background()
+	d := testDeps(t)
+	_ = d.Store.SetOwner(ctx, "t1", "", 0)
+	_ = d.Store.Set(ctx, &store.TaskStatus{ID: "t1", Status: "processing", Stage: "transcode", Percent: 10}, time.Hour)
+	srv := httptest.NewServer(NewRouter(d))
+	defer srv.Close()
+
+	// the writes that follow each event
+	steps := [][]store.TaskStatus{
+		// the same progress twice is sent once
+		{{ID: "t1", Percent: 50}, {ID: "t1", Percent: 50}},
+		// nothing after the final state reaches the client
+		{{ID: "t1", Status: "completed", Stage: "finalize", Percent: 100}, {ID: "t1", Stage: "late"}},
+	}
+	events := readEvents(t, srv.URL+"/tasks/t1/events", func(store.TaskStatus) {
+		if len(steps) == 0 {
+			return
+		}
+		for _, u := range steps[0] {
+			_ = d.Store.Set(ctx, &u, time.Hour)
+		}
+		steps = steps[1:]
+	})
+	var got []string
+	for _, ev := range events {
+		got = append(got, fmt.Sprintf("%s/%s/%d", ev.Status, ev.Stage, ev.Percent))
+	}
+	want := []string{"processing/transcode/10", "processing/transcode/50", "completed/finalize/100"}
+	if !slices.Equal(got, want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+}
+
+func TestTaskEventsOfFinishedTask(t *testing.T) {
+	ctx := context.Background()
+	d := testDeps(t)
+	_ = d.Store.SetOwner(ctx, "t1", "", 0)
+	_ = d.Store.Set(ctx, &store.TaskStatus{ID: "t1", Status: "failed", Error: "boom"}, time.Hour)
+	srv := httptest.NewServer(NewRouter(d))
+	defer srv.Close()
+
+	events := readEvents(t, srv.URL+"/tasks/t1/events", nil)
+	if len(events) != 1 || events[0].Status != "failed" || events[0].Error != "boom" {
+		t.Errorf("events = %+v, want the failed snapshot only", events)
+	}
+
+	resp, err := http.Get(srv.URL + "/tasks/none/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("events of an unknown task: %d, want 404", resp.StatusCode)
+	}
+}
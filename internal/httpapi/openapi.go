@@ -0,0 +1,217 @@
+package httpapi
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// apiOp documents one /api/v1 route. Routes are registered through
+// apiRoutes.handle, so the OpenAPI document is built from the same list
+// that wires the handlers and cannot drift from it.
+type apiOp struct {
+	Method  string
+	Path    string // gin syntax, relative to the group
+	Summary string
+	Query   []apiParam
+	// Body is a zero value of the JSON request type; with Multipart the same
+	// fields plus a "file" part are also accepted as multipart/form-data.
+	Body      any
+	Multipart bool
+	Status    int // success status, 200 if zero
+	Response  any
+	// Stream marks a Server-Sent Events response whose "progress" events
+	// each carry a Response.
+	Stream bool
+	Errors []int
+}
+
+type apiParam struct {
+	Name        string
+	Description string
+	Integer     bool
+}
+
+type apiRoutes struct {
+	group *gin.RouterGroup
+	ops   []apiOp
+}
+
+func (v *apiRoutes) handle(op apiOp, h ...gin.HandlerFunc) {
+	v.ops = append(v.ops, op)
+	v.group.Handle(op.Method, op.Path, h...)
+}
+
+// openAPI renders the registered routes as an OpenAPI 3.0 document.
+func (v *apiRoutes) openAPI() map[string]any {
+	schemas := map[string]any{}
+	errRef := schemaRef(reflect.TypeOf(apiError{}), schemas)
+	paths := map[string]map[string]any{}
+	for _, op := range v.ops {
+		path, params := openAPIPath(v.group.BasePath() + op.Path)
+		for _, q := range op.Query {
+			typ := "string"
+			if q.Integer {
+				typ = "integer"
+			}
+			params = append(params, map[string]any{
+				"name": q.Name, "in": "query", "description": q.Description,
+				"schema": map[string]any{"type": typ},
+			})
+		}
+		status := op.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		ctype := "application/json"
+		if op.Stream {
+			ctype = "text/event-stream"
+		}
+		responses := map[string]any{
+			strconv.Itoa(status): map[string]any{
+				"description": http.StatusText(status),
+				"content":     map[string]any{ctype: map[string]any{"schema": schemaRef(reflect.TypeOf(op.Response), schemas)}},
+			},
+		}
+		for _, code := range op.Errors {
+			responses[strconv.Itoa(code)] = map[string]any{
+				"description": http.StatusText(code) + " (error.code " + errorCodes[code] + ")",
+				"content":     map[string]any{"application/json": map[string]any{"schema": errRef}},
+			}
+		}
+		o := map[string]any{"summary": op.Summary, "responses": responses}
+		if len(params) > 0 {
+			o["parameters"] = params
+		}
+		if op.Body != nil {
+			bt := reflect.TypeOf(op.Body)
+			content := map[string]any{"application/json": map[string]any{"schema": schemaRef(bt, schemas)}}
+			if op.Multipart {
+				form := schemaOf(bt, schemas)
+				props := form["properties"].(map[string]any)
+				props["file"] = map[string]any{"type": "string", "format": "binary"}
+				content["multipart/form-data"] = map[string]any{"schema": form}
+			}
+			o["requestBody"] = map[string]any{"required": true, "content": content}
+		}
+		if paths[path] == nil {
+			paths[path] = map[string]any{}
+		}
+		paths[path][strings.ToLower(op.Method)] = o
+	}
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info":    map[string]any{"title": "Compressor API", "version": "1"},
+		"paths":   paths,
+		"components": map[string]any{
+			"schemas": schemas,
+			"securitySchemes": map[string]any{
+				"bearer": map[string]any{"type": "http", "scheme": "bearer"},
+				"apiKey": map[string]any{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+			},
+		},
+		"security": []any{map[string]any{"bearer": []any{}}, map[string]any{"apiKey": []any{}}},
+	}
+}
+
+// openAPIPath turns "/tasks/:id" into "/tasks/{id}" plus its path parameters.
+func openAPIPath(p string) (string, []map[string]any) {
+	var params []map[string]any
+	parts := strings.Split(p, "/")
+	for i, part := range parts {
+		if strings.HasPrefix(part, ":") {
+			name := part[1:]
+			parts[i] = "{" + name + "}"
+			params = append(params, map[string]any{
+				"name": name, "in": "path", "required": true,
+				"schema": map[string]any{"type": "string"},
+			})
+		}
+	}
+	return strings.Join(parts, "/"), params
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaRef returns a $ref for named structs (adding them to schemas) and an
+// inline schema for everything else.
+func schemaRef(t reflect.Type, schemas map[string]any) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() == reflect.Struct && t != timeType && t.Name() != "" {
+		name := strings.ToUpper(t.Name()[:1]) + t.Name()[1:]
+		if _, ok := schemas[name]; !ok {
+			schemas[name] = nil // guards recursive types
+			schemas[name] = schemaOf(t, schemas)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	}
+	return schemaOf(t, schemas)
+}
+
+// schemaOf derives a JSON schema from a Go type using its json tags.
+// Fields without omitempty are listed as required; fields tagged
+// openapi:"-" are internal and left out.
+func schemaOf(t reflect.Type, schemas map[string]any) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.String:
+		return map[string]any{"type": "string"}
+	case t.Kind() == reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		return map[string]any{"type": "number"}
+	case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+		return map[string]any{"type": "array", "items": schemaRef(t.Elem(), schemas)}
+	case t.Kind() == reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaRef(t.Elem(), schemas)}
+	case t.Kind() == reflect.Struct:
+		props := map[string]any{}
+		var required []string
+		addStructFields(t, props, &required, schemas)
+		s := map[string]any{"type": "object", "properties": props}
+		if len(required) > 0 {
+			s["required"] = required
+		}
+		return s
+	}
+	return map[string]any{}
+}
+
+func addStructFields(t reflect.Type, props map[string]any, required *[]string, schemas map[string]any) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" || f.Tag.Get("openapi") == "-" || (!f.IsExported() && !f.Anonymous) {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		ft := f.Type
+		for ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		// embedded structs are flattened by encoding/json
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			addStructFields(ft, props, required, schemas)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = schemaRef(f.Type, schemas)
+		if !strings.Contains(opts, "omitempty") {
+			*required = append(*required, name)
+		}
+	}
+}
@@ -2,37 +2,47 @@ package httpapi
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
 	redis "github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
 
+	"comp/internal/auth"
 	cfgpkg "comp/internal/config"
+	"comp/internal/jobs"
+	"comp/internal/limits"
 	"comp/internal/media/ffmpeg"
 	"comp/internal/media/yt"
+	"comp/internal/metrics"
+	"comp/internal/storage"
 	"comp/internal/store"
+	"comp/internal/tracing"
 )
 
 type Deps struct {
-	Cfg    cfgpkg.Config
-	Logger *zap.SugaredLogger
-	Store  store.Store
-	Redis  *redis.Client
+	Cfg     cfgpkg.Config
+	Logger  *zap.SugaredLogger
+	Store   store.Store
+	Queue   store.Queue
+	Jobs    *jobs.Pool
+	Auth    *auth.Authenticator
+	Limits  *limits.Limiter
+	Redis   *redis.Client
+	Storage storage.Storage
+	Uploads store.Uploads
 }
 
 func NewRouter(d Deps) *gin.Engine {
 	gin.SetMode(gin.ReleaseMode)
 	r := gin.Default()
+	r.Use(tracing.Middleware(), metrics.Middleware())
 
 	templatesPath := chooseFirstExisting([]string{"web/templates/*", "templates/*"})
 	if templatesPath != "" {
@@ -42,214 +52,218 @@ func NewRouter(d Deps) *gin.Engine {
 	if staticPath != "" {
 		r.Static("/static", staticPath)
 	}
-	uploadsPath := chooseFirstExisting([]string{"web/uploads", "uploads"})
-	if uploadsPath == "" {
-		uploadsPath = "uploads"
-	}
+	uploadsPath := d.Cfg.UploadsDir
 	_ = os.MkdirAll(uploadsPath, 0o755)
-	r.Static("/uploads", uploadsPath)
 
-	r.GET("/", func(c *gin.Context) {
-		c.HTML(http.StatusOK, "index.html", gin.H{})
+	links := newLinkSigner(d.Cfg.DownloadSecret, time.Duration(d.Cfg.DownloadLinkMinutes)*time.Minute)
+	// Signed links are the credential here, so this sits outside the auth group
+	r.GET("/tasks/:id/download", downloadTask(d, links))
+
+	r.GET("/login", func(c *gin.Context) {
+		c.HTML(http.StatusOK, "login.html", gin.H{})
+	})
+	r.POST("/login", d.Auth.Login)
+	r.POST("/logout", d.Auth.Logout)
+
+	// Prometheus scrape endpoint, guarded by metrics_token instead of user auth
+	r.GET("/metrics", metrics.Handler(d.Cfg.MetricsToken))
+
+	// Versioned JSON API; it authenticates itself and uses the error envelope
+	registerAPIv1(r, d, links)
+
+	// Everything below needs an API key or session when auth_required is set
+	api := r.Group("/", d.Auth.Middleware())
+
+	api.GET("/", func(c *gin.Context) {
+		c.HTML(http.StatusOK, "index.html", gin.H{"owner": auth.Owner(c)})
 	})
 
-	r.GET("/status/:id", func(c *gin.Context) {
+	api.GET("/status/:id", func(c *gin.Context) {
 		id := c.Param("id")
-		t, ok := d.Store.Get(context.Background(), id)
+		t, ok := ownedTask(c, d, id)
 		if !ok {
-			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
 			return
 		}
+		decorate(c, d, links, t)
 		c.JSON(http.StatusOK, t)
 	})
 
-	// Metadata endpoint for URLs: returns basic info using yt-dlp without downloading
-	r.GET("/info", func(c *gin.Context) {
-		url := strings.TrimSpace(c.Query("url"))
-		if url == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "url is required"})
-			return
-		}
-		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-		defer cancel()
-		args := []string{"--dump-single-json", "--no-playlist", "--skip-download", url}
-		if strings.TrimSpace(d.Cfg.Proxy) != "" {
-			args = append([]string{"--proxy", d.Cfg.Proxy}, args...)
-		}
-		if d.Logger != nil {
-			d.Logger.Debugf("/info yt-dlp %v", args)
-		}
-		cmd := exec.CommandContext(ctx, "yt-dlp", args...)
-		out, err := cmd.Output()
+	api.GET("/tasks", listTasks(d, links))
+	api.GET("/tasks/:id/events", taskEvents(d, links, func(c *gin.Context, status int, msg string) {
+		c.JSON(status, gin.H{"error": msg})
+	}))
+
+	// Encoders usable for video_compress with the local ffmpeg build
+	api.GET("/encoders", func(c *gin.Context) {
+		enc, err := ffmpeg.Encoders(c.Request.Context())
 		if err != nil {
 			if d.Logger != nil {
-				d.Logger.Warnf("yt-dlp info failed: %v", err)
+				d.Logger.Warnf("list encoders failed: %v", err)
 			}
-			c.JSON(http.StatusBadGateway, gin.H{"error": "failed to fetch info"})
-			return
-		}
-		// yt-dlp --dump-single-json returns either a single object or a wrapper with 'entries'
-		// We'll try to unmarshal into a generic map and normalize
-		var raw map[string]any
-		if err := json.Unmarshal(out, &raw); err != nil {
-			c.JSON(http.StatusBadGateway, gin.H{"error": "invalid info format"})
+			c.JSON(http.StatusBadGateway, gin.H{"error": "failed to list encoders"})
 			return
 		}
-		// pick first entry if playlist wrapper
-		if entries, ok := raw["entries"].([]any); ok && len(entries) > 0 {
-			if first, ok := entries[0].(map[string]any); ok {
-				raw = first
-			}
-		}
-		pickNum := func(m map[string]any, keys ...string) float64 {
-			for _, k := range keys {
-				if v, ok := m[k]; ok {
-					switch t := v.(type) {
-					case float64:
-						return t
-					case int:
-						return float64(t)
-					case int64:
-						return float64(t)
-					}
+		available := func(names []string) []string {
+			out := []string{}
+			for _, n := range names {
+				if enc[n] {
+					out = append(out, n)
 				}
 			}
-			return 0
+			return out
 		}
-		title, _ := raw["title"].(string)
-		ext, _ := raw["ext"].(string)
-		format, _ := raw["format"].(string)
-		if format == "" {
-			format, _ = raw["format_id"].(string)
-		}
-		filesize := pickNum(raw, "filesize", "filesize_approx")
-		duration := pickNum(raw, "duration")
-		width := pickNum(raw, "width")
-		height := pickNum(raw, "height")
-		fps := pickNum(raw, "fps")
-		// Bitrate may be in tbr (total bitrate)
-		bitrate := pickNum(raw, "tbr")
 		c.JSON(http.StatusOK, gin.H{
-			"title":    title,
-			"ext":      ext,
-			"format":   format,
-			"filesize": filesize,
-			"duration": duration,
-			"width":    width,
-			"height":   height,
-			"fps":      fps,
-			"bitrate":  bitrate,
+			"video":      available(ffmpeg.VideoCodecs),
+			"audio":      available(ffmpeg.AudioCodecs),
+			"containers": ffmpeg.Containers,
 		})
 	})
 
-	r.POST("/upload", func(c *gin.Context) {
-		pType := c.PostForm("type")
-		crf, _ := strconv.Atoi(c.PostForm("crf"))
-		width, _ := strconv.Atoi(c.PostForm("width"))
-		fps, _ := strconv.Atoi(c.PostForm("fps"))
-		quality, _ := strconv.Atoi(c.PostForm("quality"))
-		imgFormat := strings.ToLower(strings.TrimSpace(c.PostForm("img_format")))
-		url := strings.TrimSpace(c.PostForm("url"))
+	// Fresh signed link for the owner, e.g. after the previous one expired
+	api.GET("/tasks/:id/link", func(c *gin.Context) {
+		t, ok := ownedTask(c, d, c.Param("id"))
+		if !ok {
+			return
+		}
+		if t.Status != "completed" {
+			c.JSON(http.StatusConflict, gin.H{"error": "task not completed"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"download_url": links.URL(t.ID)})
+	})
 
-		var filename string
-		var srcPath string
+	// Output of the task's ffmpeg/yt-dlp runs, newest last
+	api.GET("/tasks/:id/log", func(c *gin.Context) {
+		id := c.Param("id")
+		if _, ok := ownedTask(c, d, id); !ok {
+			return
+		}
+		log, _ := d.Store.Log(c.Request.Context(), id)
+		c.Header("Cache-Control", "no-store")
+		c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(log))
+	})
 
-		if url == "" {
-			file, err := c.FormFile("file")
-			if err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "File or URL is required"})
-				return
-			}
-			filename = file.Filename
-			// temporarily save to uploads before moving to tmp job dir
-			srcPath = filepath.Join(uploadsPath, filename)
-			if err := c.SaveUploadedFile(file, srcPath); err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save file"})
-				return
+	cancelTask := func(c *gin.Context) {
+		id := c.Param("id")
+		if _, ok := ownedTask(c, d, id); !ok {
+			return
+		}
+		err := d.Jobs.Cancel(c.Request.Context(), id)
+		switch {
+		case errors.Is(err, jobs.ErrNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		case errors.Is(err, jobs.ErrFinished):
+			c.JSON(http.StatusConflict, gin.H{"error": "task already finished"})
+		case err != nil:
+			if d.Logger != nil {
+				d.Logger.Warnf("cancel %s failed: %v", id, err)
 			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to cancel task"})
+		default:
+			c.JSON(http.StatusOK, gin.H{"id": id, "status": "cancelled"})
 		}
+	}
+	api.DELETE("/tasks/:id", cancelTask)
+	api.POST("/tasks/:id/cancel", cancelTask)
 
-		taskID := uuid.New().String()
-		_ = d.Store.Set(context.Background(), &store.TaskStatus{ID: taskID, Status: "processing", Stage: "init", Percent: 0}, 30*time.Minute)
-
-		go func(taskID, pType, url, srcPath, filename, imgFormat string, crf, width, fps, quality int) {
-			ctx := context.Background()
-			runner := ffmpeg.Runner{Store: d.Store, Logger: d.Logger}
-			jobDir := filepath.Join(os.TempDir(), "app", taskID)
-			_ = os.MkdirAll(jobDir, 0o755)
-			curPath := srcPath
-			curName := filename
-			// Download if URL provided
-			if url != "" {
-				f, err := yt.DownloadWithProgress(ctx, d.Store, d.Logger, taskID, url, d.Cfg.Proxy, jobDir)
-				if err != nil {
-					_ = d.Store.Set(ctx, &store.TaskStatus{ID: taskID, Status: "failed", Error: "download failed: " + err.Error()}, 30*time.Minute)
-					return
+	// Metadata endpoint for URLs: returns basic info and the available formats
+	// using yt-dlp without downloading
+	api.GET("/info", d.Limits.Middleware(), func(c *gin.Context) {
+		url := strings.TrimSpace(c.Query("url"))
+		if url == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "url is required"})
+			return
+		}
+		// playlist=1 lists the entries a batch task would get instead
+		if c.Query("playlist") == "1" || c.Query("playlist") == "true" {
+			ctx, cancel := context.WithTimeout(c.Request.Context(), 60*time.Second)
+			defer cancel()
+			pl, err := yt.Expand(ctx, url, d.Cfg.Proxy)
+			if err != nil {
+				if d.Logger != nil {
+					d.Logger.Warnf("yt-dlp playlist info failed: %v", err)
 				}
-				curPath = f
-				curName = filepath.Base(f)
+				c.JSON(http.StatusBadGateway, gin.H{"error": "failed to fetch info"})
+				return
 			}
-			// If local file: move into tmpfs job dir
-			if url == "" && curPath != "" {
-				dst := filepath.Join(jobDir, filepath.Base(curPath))
-				_ = os.Rename(curPath, dst)
-				curPath = dst
-				curName = filepath.Base(dst)
+			c.JSON(http.StatusOK, gin.H{"title": pl.Title, "count": len(pl.Entries), "entries": pl.Entries})
+			return
+		}
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 15*time.Second)
+		defer cancel()
+		info, err := yt.GetInfo(ctx, url, d.Cfg.Proxy)
+		if err != nil {
+			if d.Logger != nil {
+				d.Logger.Warnf("yt-dlp info failed: %v", err)
 			}
+			c.JSON(http.StatusBadGateway, gin.H{"error": "failed to fetch info"})
+			return
+		}
+		c.JSON(http.StatusOK, info)
+	})
 
-			ext := filepath.Ext(curName)
-			outName := "out_" + curName
-			outPath := filepath.Join(jobDir, outName)
-			var errProc error
-			switch pType {
-			case "video_compress":
-				outName = "compressed_" + curName
-				outPath = filepath.Join(jobDir, outName)
-				_ = d.Store.Set(ctx, &store.TaskStatus{ID: taskID, Status: "processing", Stage: "transcode", Percent: 0}, 30*time.Minute)
-				errProc = runner.Compress(taskID, curPath, outPath, crf, width, fps)
-			case "video_to_gif":
-				outName = strings.TrimSuffix(curName, ext) + ".gif"
-				outPath = filepath.Join(jobDir, outName)
-				_ = d.Store.Set(ctx, &store.TaskStatus{ID: taskID, Status: "processing", Stage: "transcode", Percent: 0}, 30*time.Minute)
-				errProc = runner.GIF(taskID, curPath, outPath, width, fps)
-			case "video_to_audio":
-				outName = strings.TrimSuffix(curName, ext) + ".mp3"
-				outPath = filepath.Join(jobDir, outName)
-				_ = d.Store.Set(ctx, &store.TaskStatus{ID: taskID, Status: "processing", Stage: "transcode", Percent: 0}, 30*time.Minute)
-				errProc = runner.Audio(taskID, curPath, outPath, "128k")
-			case "image_compress":
-				// choose target image format if provided
-				targetExt := ""
-				switch imgFormat {
-				case "jpg", "jpeg":
-					targetExt = ".jpg"
-				case "png":
-					targetExt = ".png"
-				}
-				if targetExt == "" {
-					// keep original extension
-					outName = "compressed_" + curName
-				} else {
-					outName = "compressed_" + strings.TrimSuffix(curName, ext) + targetExt
-				}
-				outPath = filepath.Join(jobDir, outName)
-				_ = d.Store.Set(ctx, &store.TaskStatus{ID: taskID, Status: "processing", Stage: "transcode", Percent: 0}, 30*time.Minute)
-				errProc = runner.Image(taskID, curPath, outPath, quality, width)
-			}
-			if errProc != nil {
-				_ = d.Store.Set(ctx, &store.TaskStatus{ID: taskID, Status: "failed", Error: "processing failed: " + errProc.Error()}, 30*time.Minute)
-				return
+	// Media inspection for local files: the upload is probed and discarded
+	api.POST("/probe", d.Limits.Middleware(), func(c *gin.Context) {
+		if err := parseUploadForm(c, d); err != nil {
+			writeUploadError(c, err)
+			return
+		}
+		file, err := c.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "file is required"})
+			return
+		}
+		// any processing type may follow, so the largest limit applies
+		if max := d.Cfg.MaxUploadLimit(); max > 0 && file.Size > max {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("file too large (max %d MB)", max>>20)})
+			return
+		}
+		if err := sniffUpload(file); err != nil {
+			writeUploadError(c, err)
+			return
+		}
+		dir, err := os.MkdirTemp("", "probe-")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save file"})
+			return
+		}
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, sanitizeFilename(file.Filename))
+		if err := c.SaveUploadedFile(file, path); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save file"})
+			return
+		}
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
+		defer cancel()
+		info, err := ffmpeg.Probe(ctx, path)
+		if err != nil {
+			if d.Logger != nil {
+				d.Logger.Debugf("/probe %s: %v", file.Filename, err)
 			}
-			// Move final file to uploads
-			final := filepath.Join(uploadsPath, outName)
-			_ = os.Rename(outPath, final)
-			_ = d.Store.Set(ctx, &store.TaskStatus{ID: taskID, Status: "completed", OutputFile: outName, Stage: "finalize", Percent: 100}, 30*time.Minute)
-			_ = os.RemoveAll(jobDir)
-		}(taskID, pType, url, srcPath, filename, imgFormat, crf, width, fps, quality)
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "not a recognizable media file"})
+			return
+		}
+		c.JSON(http.StatusOK, info)
+	})
 
-		c.JSON(http.StatusOK, gin.H{"task_id": taskID})
+	api.POST("/upload", d.Limits.Middleware(), func(c *gin.Context) {
+		j, err := submitForm(c, d)
+		if err != nil {
+			writeUploadError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"task_id": j.ID})
 	})
 
+	// Resumable alternative to POST /upload for large files (tus 1.0)
+	tus := newTusUploads(d)
+	tg := api.Group("/uploads", tus.headers)
+	tg.OPTIONS("", tus.options)
+	tg.POST("", d.Limits.Middleware(), tus.create)
+	tg.HEAD("/:id", tus.head)
+	tg.PATCH("/:id", tus.patch)
+	tg.DELETE("/:id", tus.terminate)
+
 	if d.Logger != nil {
 		d.Logger.Infof("Server started on http://0.0.0.0:%d", d.Cfg.Port)
 	} else {
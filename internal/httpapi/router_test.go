@@ -0,0 +1,124 @@
+package httpapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"comp/internal/auth"
+	cfgpkg "comp/internal/config"
+	"comp/internal/jobs"
+	"comp/internal/limits"
+	"comp/internal/storage"
+	"comp/internal/store"
+)
+
+// testDeps wires the router to in-memory stores and local storage in a
+// temporary directory, with uploads capped at 1 MB.
+func testDeps(t *testing.T) Deps {
+	t.Helper()
+	dir := t.TempDir()
+	st := store.NewRedisStore(nil)
+	stor, err := storage.NewLocal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := cfgpkg.Config{
+		UploadsDir:          dir,
+		MaxUploadMB:         map[string]int64{"default": 1},
+		DownloadSecret:      "test",
+		DownloadLinkMinutes: 60,
+	}
+	return Deps{
+		Cfg:     cfg,
+		Store:   st,
+		Queue:   st,
+		Jobs:    &jobs.Pool{Queue: st, Store: st},
+		Auth:    auth.New(nil, "test", false, nil, nil),
+		Limits:  limits.New(0, 0, 0, 0, st, nil, nil),
+		Storage: stor,
+		Uploads: st,
+	}
+}
+
+func testSpec(t *testing.T, r *gin.Engine) map[string]any {
+	t.Helper()
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/openapi.json", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET openapi.json: %d %s", w.Code, w.Body)
+	}
+	var spec map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &spec); err != nil {
+		t.Fatal(err)
+	}
+	return spec
+}
+
+// multipartFile builds a form with content as its "file" part.
+func multipartFile(t *testing.T, name string, content []byte) (*bytes.Buffer, string) {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("file", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = fw.Write(content)
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &body, mw.FormDataContentType()
+}
+
+func TestProbeUploadLimits(t *testing.T) {
+	r := NewRouter(testDeps(t))
+	tests := []struct {
+		name    string
+		content []byte
+		status  int
+	}{
+		{"over the upload limit", bytes.Repeat([]byte{0}, 3<<20), http.StatusRequestEntityTooLarge},
+		{"zip archive", append([]byte("PK\x03\x04"), make([]byte, 100)...), http.StatusUnsupportedMediaType},
+		{"empty", nil, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, ctype := multipartFile(t, "../x.mp4", tt.content)
+			req := httptest.NewRequest(http.MethodPost, "/probe", body)
+			req.Header.Set("Content-Type", ctype)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Errorf("POST /probe: %d %s, want %d", w.Code, w.Body, tt.status)
+			}
+		})
+	}
+}
+
+func TestCallbackNeedsSecret(t *testing.T) {
+	form := url.Values{
+		"type":           {"video_target_size"},
+		"target_size_mb": {"10"},
+		"url":            {"https://videos.example/v"},
+		"callback_url":   {"https://hooks.example/done"},
+	}
+	for secret, status := range map[string]int{"": http.StatusBadRequest, "k": http.StatusOK} {
+		d := testDeps(t)
+		d.Cfg.WebhookSecret = secret
+		req := httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		NewRouter(d).ServeHTTP(w, req)
+		if w.Code != status {
+			t.Errorf("webhook_secret %q: %d %s, want %d", secret, w.Code, w.Body, status)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package httpapi
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"comp/internal/auth"
+	"comp/internal/store"
+)
+
+// ownedTask loads a task and checks that the caller owns it. Foreign tasks
+// are reported as missing so their IDs cannot be probed.
+func ownedTask(c *gin.Context, d Deps, id string) (*store.TaskStatus, bool) {
+	t, ok := loadOwned(c, d, id)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+	}
+	return t, ok
+}
+
+// loadOwned is ownedTask without writing a response.
+func loadOwned(c *gin.Context, d Deps, id string) (*store.TaskStatus, bool) {
+	ctx := c.Request.Context()
+	t, ok := d.Store.Get(ctx, id)
+	if ok {
+		// a task without an owner record is nobody's, not the anonymous
+		// caller's
+		owner, found := d.Store.Owner(ctx, id)
+		ok = found && owner == auth.Owner(c)
+	}
+	if !ok {
+		return nil, false
+	}
+	return t, true
+}
+
+// listTasks serves GET /tasks for operators: tasks by status, type and
+// creation time, newest first. Admins see every owner's tasks (optionally
+// ?owner=), everyone else only their own.
+//
+// since and until take RFC 3339, unix seconds or a duration meaning "that
+// long ago", so /tasks?status=processing&until=30m lists jobs that have been
+// running for more than half an hour.
+func listTasks(d Deps, links *linkSigner) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		q := store.TaskQuery{Status: c.Query("status"), Type: c.Query("type"), Limit: 100}
+		var err error
+		if q.Since, err = parseTimeParam(c.Query("since")); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid since"})
+			return
+		}
+		if q.Until, err = parseTimeParam(c.Query("until")); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid until"})
+			return
+		}
+		if s := c.Query("limit"); s != "" {
+			if q.Limit, err = strconv.Atoi(s); err != nil || q.Limit < 1 || q.Limit > 1000 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 1000"})
+				return
+			}
+		}
+		owner := auth.Owner(c)
+		if !d.Cfg.IsAdmin(owner) {
+			q.Owner = &owner
+		} else if o, ok := c.GetQuery("owner"); ok {
+			q.Owner = &o
+		}
+		tasks, _, err := d.Store.List(c.Request.Context(), q)
+		if err != nil {
+			if d.Logger != nil {
+				d.Logger.Warnf("list tasks: %v", err)
+			}
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "failed to list tasks"})
+			return
+		}
+		for _, t := range tasks {
+			decorate(c, d, links, t)
+		}
+		if tasks == nil {
+			tasks = []*store.TaskStatus{}
+		}
+		c.JSON(http.StatusOK, gin.H{"tasks": tasks, "count": len(tasks)})
+	}
+}
+
+func parseTimeParam(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(n, 0), nil
+	}
+	dur, err := time.ParseDuration(s)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Now().Add(-dur), nil
+}
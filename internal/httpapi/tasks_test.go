@@ -0,0 +1,112 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"comp/internal/auth"
+	"comp/internal/store"
+)
+
+func TestOwnerlessTaskIsNotFound(t *testing.T) {
+	ctx := context.Background()
+	d := testDeps(t)
+	// finished task whose owner record is gone
+	_ = d.Store.Set(ctx, &store.TaskStatus{ID: "t1", Status: "completed", OutputFile: "a.mp4", StoredFile: "outputs/t1/a.mp4"}, time.Hour)
+	_ = d.Store.Set(ctx, &store.TaskStatus{ID: "t2", Status: "completed"}, time.Hour)
+	_ = d.Store.SetOwner(ctx, "t2", "", 0)
+	r := NewRouter(d)
+	for _, path := range []string{"/status/t1", "/tasks/t1/link", "/tasks/t1/log"} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("GET %s: %d, want 404", path, w.Code)
+		}
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/status/t2", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("GET /status/t2 of the anonymous owner: %d, want 200", w.Code)
+	}
+}
+
+func TestListTasksScope(t *testing.T) {
+	ctx := context.Background()
+	d := testDeps(t)
+	d.Auth = auth.New(map[string]string{"ka": "alice", "kb": "bob", "kr": "root"}, "test", true, nil, nil)
+	d.Cfg.Admins = []string{"root"}
+	for _, tk := range []struct{ id, owner, status string }{
+		{"a1", "alice", "completed"}, {"b1", "bob", "processing"}, {"a2", "alice", "failed"},
+	} {
+		_ = d.Store.SetOwner(ctx, tk.id, tk.owner, 0)
+		_ = d.Store.Set(ctx, &store.TaskStatus{ID: tk.id, Status: tk.status}, time.Hour)
+	}
+	r := NewRouter(d)
+	list := func(key, query string) (int, []string) {
+		req := httptest.NewRequest(http.MethodGet, "/tasks"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+key)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var body struct{ Tasks []store.TaskStatus }
+		_ = json.Unmarshal(w.Body.Bytes(), &body)
+		var ids []string
+		for _, t := range body.Tasks {
+			ids = append(ids, t.ID)
+		}
+		slices.Sort(ids)
+		return w.Code, ids
+	}
+	tests := []struct {
+		key, query string
+		want       []string
+	}{
+		{"ka", "", []string{"a1", "a2"}},
+		// only admins may look at other owners
+		{"ka", "?owner=bob", []string{"a1", "a2"}},
+		{"kb", "", []string{"b1"}},
+		{"kr", "", []string{"a1", "a2", "b1"}},
+		{"kr", "?owner=bob", []string{"b1"}},
+		{"kr", "?status=failed", []string{"a2"}},
+		{"kr", "?since=1h&until=" + strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10), []string{"a1", "a2", "b1"}},
+		{"kr", "?until=1h", nil},
+	}
+	for _, tt := range tests {
+		code, got := list(tt.key, tt.query)
+		if code != http.StatusOK || !slices.Equal(got, tt.want) {
+			t.Errorf("%s GET /tasks%s: %d %v, want %v", tt.key, tt.query, code, got, tt.want)
+		}
+	}
+	for _, query := range []string{"?since=yesterday", "?limit=0", "?limit=1001"} {
+		if code, _ := list("kr", query); code != http.StatusBadRequest {
+			t.Errorf("GET /tasks%s: %d, want 400", query, code)
+		}
+	}
+}
+
+func TestParseTimeParam(t *testing.T) {
+	now := time.Now()
+	tests := map[string]time.Time{
+		"":                     {},
+		"2024-05-01T12:00:00Z": time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		"1700000000":           time.Unix(1700000000, 0),
+	}
+	for in, want := range tests {
+		got, err := parseTimeParam(in)
+		if err != nil || !got.Equal(want) {
+			t.Errorf("parseTimeParam(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	got, err := parseTimeParam("30m")
+	if err != nil || got.Sub(now.Add(-30*time.Minute)).Abs() > time.Second {
+		t.Errorf("parseTimeParam(30m) = %v, %v; want half an hour ago", got, err)
+	}
+	if _, err := parseTimeParam("soon"); err == nil {
+		t.Error("parseTimeParam(soon) succeeded")
+	}
+}
@@ -0,0 +1,486 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	redis "github.com/redis/go-redis/v9"
+
+	"comp/internal/auth"
+	"comp/internal/jobs"
+	"comp/internal/limits"
+	"comp/internal/store"
+)
+
+// Resumable uploads following tus 1.0 (core, creation, expiration and
+// termination). Each PATCH stores the bytes it received as one chunk object
+// in storage, keyed by the offset it starts at; the committed offset lives
+// in the store. An upload therefore survives restarts and dropped
+// connections, and any instance can take the next PATCH. The last PATCH
+// assembles the chunks, hands the file to the pipeline and answers with
+// Upload-Task-Id.
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,expiration,termination"
+	// tusTTL is refreshed by every PATCH; idle uploads expire after it.
+	tusTTL = 24 * time.Hour
+	// tusLockTTL bounds how long a crashed instance keeps an upload locked;
+	// a running request refreshes its lock.
+	tusLockTTL = 30 * time.Second
+)
+
+// errUploadBusy is returned by lock while another request holds the upload.
+var errUploadBusy = errors.New("upload is busy")
+
+// unlockScript deletes lock KEYS[1] if it still holds token ARGV[1];
+// extendScript renews it to ARGV[2] ms on the same condition.
+var (
+	unlockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+	extendScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+)
+
+type tusUploads struct {
+	d     Deps
+	locks sync.Map // upload id -> *sync.Mutex, when there is no Redis
+}
+
+func newTusUploads(d Deps) *tusUploads {
+	return &tusUploads{d: d}
+}
+
+// partPrefix is the storage prefix of the chunks of upload id.
+func partPrefix(id string) string {
+	return "partial/" + id + "/"
+}
+
+// partKey names the chunk starting at offset; the padding makes keys sort
+// by offset.
+func partKey(id string, offset int64) string {
+	return fmt.Sprintf("%s%020d", partPrefix(id), offset)
+}
+
+// lock takes the per-upload lock in Redis so PATCH and DELETE of one upload
+// never overlap, whichever instance they reach. It does not wait: a busy
+// upload yields errUploadBusy. Without Redis there is only one instance and
+// a local mutex does.
+func (t *tusUploads) lock(ctx context.Context, id string) (func(), error) {
+	if t.d.Redis == nil {
+		v, _ := t.locks.LoadOrStore(id, &sync.Mutex{})
+		mu := v.(*sync.Mutex)
+		if !mu.TryLock() {
+			return nil, errUploadBusy
+		}
+		return mu.Unlock, nil
+	}
+	bg := context.WithoutCancel(ctx)
+	key, token := "upload:"+id+":lock", uuid.New().String()
+	ok, err := t.d.Redis.SetNX(bg, key, token, tusLockTTL).Result()
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errUploadBusy
+	}
+	stop := make(chan struct{})
+	go func() {
+		tk := time.NewTicker(tusLockTTL / 3)
+		defer tk.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-tk.C:
+				_ = extendScript.Run(bg, t.d.Redis, []string{key}, token, tusLockTTL.Milliseconds()).Err()
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		_ = unlockScript.Run(bg, t.d.Redis, []string{key}, token).Err()
+	}, nil
+}
+
+// locked takes the lock of the upload in the request path, answering the
+// request itself if that fails.
+func (t *tusUploads) locked(c *gin.Context) (func(), bool) {
+	unlock, err := t.lock(c.Request.Context(), c.Param("id"))
+	switch {
+	case errors.Is(err, errUploadBusy):
+		c.JSON(http.StatusLocked, gin.H{"error": "upload is busy"})
+		return nil, false
+	case err != nil:
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "failed to lock upload"})
+		return nil, false
+	}
+	return unlock, true
+}
+
+// headers sets the protocol headers and rejects clients speaking another version.
+func (t *tusUploads) headers(c *gin.Context) {
+	c.Header("Tus-Resumable", tusVersion)
+	if c.Request.Method == http.MethodOptions {
+		c.Next()
+		return
+	}
+	if v := c.GetHeader("Tus-Resumable"); v != tusVersion {
+		c.Header("Tus-Version", tusVersion)
+		c.AbortWithStatusJSON(http.StatusPreconditionFailed, gin.H{"error": "unsupported tus version"})
+		return
+	}
+	c.Next()
+}
+
+func (t *tusUploads) options(c *gin.Context) {
+	c.Header("Tus-Version", tusVersion)
+	c.Header("Tus-Extension", tusExtensions)
+	if max := t.d.Cfg.MaxUploadLimit(); max > 0 {
+		c.Header("Tus-Max-Size", strconv.FormatInt(max, 10))
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// owned loads an upload of the caller; foreign ones are reported as missing.
+func (t *tusUploads) owned(c *gin.Context) (*store.Upload, bool) {
+	u, ok := t.d.Uploads.GetUpload(c.Request.Context(), c.Param("id"))
+	if !ok || u.Owner != auth.Owner(c) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "upload not found"})
+		return nil, false
+	}
+	return u, true
+}
+
+func (t *tusUploads) progressHeaders(c *gin.Context, u *store.Upload) {
+	c.Header("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+	c.Header("Upload-Expires", time.Now().Add(tusTTL).UTC().Format(http.TimeFormat))
+	if u.TaskID != "" {
+		c.Header("Upload-Task-Id", u.TaskID)
+	}
+}
+
+func (t *tusUploads) create(c *gin.Context) {
+	length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Length is required"})
+		return
+	}
+	if length == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "empty file"})
+		return
+	}
+	meta, err := parseTusMetadata(c.GetHeader("Upload-Metadata"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid Upload-Metadata"})
+		return
+	}
+	meta = uploadParams(meta)
+	filename := sanitizeFilename(meta["filename"])
+	// validate the processing options now rather than after a long upload
+	j, err := jobFromParams(c.Request.Context(), func(k string) string { return meta[k] }, filename)
+	if err != nil {
+		writeUploadError(c, err)
+		return
+	}
+	if err := checkUploadSize(t.d, j.Type, length); err != nil {
+		writeUploadError(c, err)
+		return
+	}
+	u := &store.Upload{
+		ID:        uuid.New().String(),
+		Owner:     auth.Owner(c),
+		Length:    length,
+		Filename:  filename,
+		Params:    meta,
+		CreatedAt: time.Now(),
+	}
+	if err := t.d.Uploads.SetUpload(c.Request.Context(), u, tusTTL); err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "failed to create upload"})
+		return
+	}
+	c.Header("Location", "/uploads/"+u.ID)
+	c.Header("Upload-Expires", time.Now().Add(tusTTL).UTC().Format(http.TimeFormat))
+	c.Status(http.StatusCreated)
+}
+
+func (t *tusUploads) head(c *gin.Context) {
+	u, ok := t.owned(c)
+	if !ok {
+		return
+	}
+	t.progressHeaders(c, u)
+	c.Header("Upload-Length", strconv.FormatInt(u.Length, 10))
+	c.Header("Cache-Control", "no-store")
+	c.Status(http.StatusOK)
+}
+
+func (t *tusUploads) patch(c *gin.Context) {
+	if c.ContentType() != "application/offset+octet-stream" {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Content-Type must be application/offset+octet-stream"})
+		return
+	}
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Offset is required"})
+		return
+	}
+	unlock, ok := t.locked(c)
+	if !ok {
+		return
+	}
+	defer unlock()
+	// read after locking so a concurrent PATCH cannot move the offset under us
+	u, ok := t.owned(c)
+	if !ok {
+		return
+	}
+	if offset != u.Offset {
+		t.progressHeaders(c, u)
+		c.JSON(http.StatusConflict, gin.H{"error": "offset mismatch"})
+		return
+	}
+	if u.Offset < u.Length {
+		n, err := t.appendChunk(c.Request.Context(), u, c.Request.Body)
+		u.Offset += n
+		// commit whatever arrived, even from an interrupted request
+		if serr := t.d.Uploads.SetUpload(context.Background(), u, tusTTL); serr != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "failed to save upload state"})
+			return
+		}
+		if err != nil {
+			if t.d.Logger != nil {
+				t.d.Logger.Debugf("upload %s interrupted at %d: %v", u.ID, u.Offset, err)
+			}
+			t.progressHeaders(c, u)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "upload interrupted"})
+			return
+		}
+	}
+	// a retried final PATCH after e.g. a 429 only re-runs the hand-off
+	if u.Offset == u.Length && u.TaskID == "" && !t.finish(c, u) {
+		return
+	}
+	t.progressHeaders(c, u)
+	c.Status(http.StatusNoContent)
+}
+
+// appendChunk stores body as the chunk at the committed offset, replacing
+// whatever a request that failed to commit left there, and cuts off
+// anything past Upload-Length. The body is spooled to a temp file first so
+// the bytes of an interrupted request are kept.
+func (t *tusUploads) appendChunk(ctx context.Context, u *store.Upload, body io.Reader) (int64, error) {
+	f, err := os.CreateTemp("", "tus-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	n, cerr := io.Copy(f, io.LimitReader(body, u.Length-u.Offset))
+	if n == 0 {
+		return 0, cerr
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	// store what arrived even if the request was cancelled
+	if err := t.d.Storage.Put(context.WithoutCancel(ctx), partKey(u.ID, u.Offset), f, n, "application/octet-stream"); err != nil {
+		return 0, err
+	}
+	return n, cerr
+}
+
+// assemble writes the committed bytes of u to w, following the chunks from
+// offset 0. Chunks at other offsets were never committed and are skipped.
+func (t *tusUploads) assemble(ctx context.Context, u *store.Upload, w io.Writer) error {
+	objs, err := t.d.Storage.List(ctx, partPrefix(u.ID))
+	if err != nil {
+		return err
+	}
+	parts := make(map[int64]string, len(objs))
+	for _, o := range objs {
+		if off, err := strconv.ParseInt(strings.TrimPrefix(o.Key, partPrefix(u.ID)), 10, 64); err == nil {
+			parts[off] = o.Key
+		}
+	}
+	for pos := int64(0); pos < u.Offset; {
+		key, ok := parts[pos]
+		if !ok {
+			return fmt.Errorf("upload %s: no chunk at offset %d", u.ID, pos)
+		}
+		r, _, err := t.d.Storage.Get(ctx, key)
+		if err != nil {
+			return err
+		}
+		n, err := io.Copy(w, io.LimitReader(r, u.Offset-pos))
+		r.Close()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return fmt.Errorf("upload %s: empty chunk at offset %d", u.ID, pos)
+		}
+		pos += n
+	}
+	return nil
+}
+
+// finish assembles and validates the file and submits it as a job, like a
+// multipart POST /upload would. On a limit error the upload is kept so the
+// client can retry later; other failures discard it.
+func (t *tusUploads) finish(c *gin.Context, u *store.Upload) bool {
+	ctx := c.Request.Context()
+	dir, err := os.MkdirTemp("", "upload-")
+	if err != nil {
+		writeUploadError(c, &uploadError{http.StatusInternalServerError, "failed to assemble upload"})
+		return false
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, u.Filename)
+	if err := t.assembleFile(ctx, u, path); err != nil {
+		if t.d.Logger != nil {
+			t.d.Logger.Errorf("assemble upload %s: %v", u.ID, err)
+		}
+		writeUploadError(c, &uploadError{http.StatusInternalServerError, "failed to assemble upload"})
+		return false
+	}
+	params := uploadParams(u.Params)
+	j, err := jobFromParams(ctx, func(k string) string { return params[k] }, u.Filename)
+	if err == nil {
+		err = sniffFile(path)
+	}
+	if err != nil {
+		t.discard(ctx, u.ID)
+		writeUploadError(c, err)
+		return false
+	}
+	j.ID = uuid.New().String()
+	j.SrcKey = jobs.IncomingKey(j.ID, u.Filename)
+	err = submitJob(c, t.d, &j, func() error { return stageFile(ctx, t.d, path, j.SrcKey, j.Type) })
+	if err != nil {
+		var le *limits.LimitError
+		if !errors.As(err, &le) {
+			t.discard(ctx, u.ID)
+		}
+		writeUploadError(c, err)
+		return false
+	}
+	u.TaskID = j.ID
+	// keep the record a while so a client that missed the response can HEAD it
+	_ = t.d.Uploads.SetUpload(context.WithoutCancel(ctx), u, tusTTL)
+	t.deleteParts(context.WithoutCancel(ctx), u.ID)
+	t.locks.Delete(u.ID)
+	return true
+}
+
+func (t *tusUploads) assembleFile(ctx context.Context, u *store.Upload, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := t.assemble(ctx, u, f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (t *tusUploads) terminate(c *gin.Context) {
+	unlock, ok := t.locked(c)
+	if !ok {
+		return
+	}
+	defer unlock()
+	u, ok := t.owned(c)
+	if !ok {
+		return
+	}
+	t.discard(c.Request.Context(), u.ID)
+	c.Status(http.StatusNoContent)
+}
+
+func (t *tusUploads) discard(ctx context.Context, id string) {
+	ctx = context.WithoutCancel(ctx)
+	t.deleteParts(ctx, id)
+	_ = t.d.Uploads.DeleteUpload(ctx, id)
+	t.locks.Delete(id)
+}
+
+func (t *tusUploads) deleteParts(ctx context.Context, id string) {
+	objs, err := t.d.Storage.List(ctx, partPrefix(id))
+	if err != nil {
+		return
+	}
+	for _, o := range objs {
+		_ = t.d.Storage.Delete(ctx, o.Key)
+	}
+}
+
+func sniffFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return sniffReader(f)
+}
+
+// tusParams are the metadata keys an upload may set. Download options such
+// as url, urls or playlist would turn the upload into a different job.
+var tusParams = map[string]bool{
+	"filename": true, "type": true, "callback_url": true,
+	"start": true, "end": true, "duration": true,
+	"img_format": true, "width": true, "fps": true, "quality": true, "target_size_mb": true,
+	"crf": true, "video_codec": true, "preset": true, "container": true, "audio_codec": true, "audio_bitrate": true,
+	"anim_format": true, "stats_mode": true, "dither": true, "colors": true, "loop": true, "max_size_mb": true,
+	"audio_format": true, "bitrate_mode": true, "loudnorm": true, "trim_silence": true,
+	"sample_rate": true, "channels": true, "lufs": true, "metadata": true,
+}
+
+// uploadParams returns the entries of meta listed in tusParams.
+func uploadParams(meta map[string]string) map[string]string {
+	out := make(map[string]string, len(meta))
+	for k, v := range meta {
+		if tusParams[k] {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// parseTusMetadata decodes "key base64,key2 base64" pairs; values may be absent.
+func parseTusMetadata(h string) (map[string]string, error) {
+	meta := map[string]string{}
+	for _, pair := range strings.Split(h, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, val, _ := strings.Cut(pair, " ")
+		b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(val))
+		if err != nil {
+			return nil, errors.New("bad metadata value for " + key)
+		}
+		meta[key] = string(b)
+	}
+	return meta, nil
+}
@@ -0,0 +1,236 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	redis "github.com/redis/go-redis/v9"
+
+	"comp/internal/store"
+)
+
+// tusRequest sends a tus request with the protocol header set.
+func tusRequest(t *testing.T, h http.Handler, method, target string, body io.Reader, header map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, target, body)
+	req.Header.Set("Tus-Resumable", tusVersion)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func tusMetadata(kv ...string) string {
+	var pairs []string
+	for i := 0; i < len(kv); i += 2 {
+		pairs = append(pairs, kv[i]+" "+base64.StdEncoding.EncodeToString([]byte(kv[i+1])))
+	}
+	return strings.Join(pairs, ",")
+}
+
+func TestTusOffsets(t *testing.T) {
+	d := testDeps(t)
+	r := NewRouter(d)
+	w := tusRequest(t, r, http.MethodPost, "/uploads", nil, map[string]string{
+		"Upload-Length":   "10",
+		"Upload-Metadata": tusMetadata("filename", "clip.mp4", "type", "video_target_size", "target_size_mb", "10"),
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: %d %s", w.Code, w.Body)
+	}
+	loc := w.Header().Get("Location")
+	patch := func(offset int, chunk string) *httptest.ResponseRecorder {
+		return tusRequest(t, r, http.MethodPatch, loc, strings.NewReader(chunk), map[string]string{
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": strconv.Itoa(offset),
+		})
+	}
+
+	if w := patch(0, "abcd"); w.Code != http.StatusNoContent || w.Header().Get("Upload-Offset") != "4" {
+		t.Fatalf("first chunk: %d offset %q", w.Code, w.Header().Get("Upload-Offset"))
+	}
+	// a retry of the first chunk conflicts and reports the committed offset
+	if w := patch(0, "abcd"); w.Code != http.StatusConflict || w.Header().Get("Upload-Offset") != "4" {
+		t.Errorf("stale offset: %d offset %q, want 409 at 4", w.Code, w.Header().Get("Upload-Offset"))
+	}
+	if w := patch(6, "gh"); w.Code != http.StatusConflict {
+		t.Errorf("offset past the committed one: %d, want 409", w.Code)
+	}
+	w = tusRequest(t, r, http.MethodHead, loc, nil, nil)
+	if w.Code != http.StatusOK || w.Header().Get("Upload-Offset") != "4" || w.Header().Get("Upload-Length") != "10" {
+		t.Errorf("HEAD: %d offset %q length %q", w.Code, w.Header().Get("Upload-Offset"), w.Header().Get("Upload-Length"))
+	}
+	if w := patch(4, "ef"); w.Code != http.StatusNoContent || w.Header().Get("Upload-Offset") != "6" {
+		t.Errorf("second chunk: %d offset %q", w.Code, w.Header().Get("Upload-Offset"))
+	}
+
+	u, ok := d.Uploads.GetUpload(context.Background(), strings.TrimPrefix(loc, "/uploads/"))
+	if !ok {
+		t.Fatal("upload record missing")
+	}
+	var b bytes.Buffer
+	if err := newTusUploads(d).assemble(context.Background(), u, &b); err != nil || b.String() != "abcdef" {
+		t.Errorf("assembled = %q, %v; want abcdef", b.String(), err)
+	}
+}
+
+func TestTusAppendChunkReplacesUncommitted(t *testing.T) {
+	ctx := context.Background()
+	tus := newTusUploads(testDeps(t))
+	u := &store.Upload{ID: "u1", Length: 8}
+	if _, err := tus.appendChunk(ctx, u, strings.NewReader("abc")); err != nil {
+		t.Fatal(err)
+	}
+	u.Offset = 3
+	// chunks a request stored without committing them
+	for off, b := range map[int64]string{3: "XXXXX", 5: "YYY"} {
+		if err := tus.d.Storage.Put(ctx, partKey(u.ID, off), strings.NewReader(b), int64(len(b)), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	n, err := tus.appendChunk(ctx, u, strings.NewReader("defghijk"))
+	if err != nil || n != 5 {
+		t.Fatalf("appendChunk = %d, %v; want 5 bytes", n, err)
+	}
+	u.Offset += n
+	var b bytes.Buffer
+	if err := tus.assemble(ctx, u, &b); err != nil || b.String() != "abcdefgh" {
+		t.Errorf("assembled = %q, %v; want abcdefgh", b.String(), err)
+	}
+}
+
+func TestTusFinishRemovesChunks(t *testing.T) {
+	d := testDeps(t)
+	r := NewRouter(d)
+	w := tusRequest(t, r, http.MethodPost, "/uploads", nil, map[string]string{
+		"Upload-Length":   "4",
+		"Upload-Metadata": tusMetadata("filename", "clip.mp4", "type", "video_target_size", "target_size_mb", "10"),
+	})
+	loc := w.Header().Get("Location")
+	id := strings.TrimPrefix(loc, "/uploads/")
+	w = tusRequest(t, r, http.MethodPatch, loc, strings.NewReader("text"), map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": "0",
+	})
+	// plain text is not a video, so the upload is refused and discarded
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("final PATCH: %d %s, want 415", w.Code, w.Body)
+	}
+	if objs, _ := d.Storage.List(context.Background(), partPrefix(id)); len(objs) != 0 {
+		t.Errorf("chunks left: %v", objs)
+	}
+	if _, ok := d.Uploads.GetUpload(context.Background(), id); ok {
+		t.Error("upload record kept")
+	}
+}
+
+func TestTusLock(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer rdb.Close()
+	d := testDeps(t)
+	ctx := context.Background()
+	for name, tus := range map[string]*tusUploads{"memory": newTusUploads(d), "redis": newTusUploads(Deps{Redis: rdb})} {
+		unlock, err := tus.lock(ctx, "u1")
+		if err != nil {
+			t.Fatalf("%s: lock: %v", name, err)
+		}
+		if _, err := tus.lock(ctx, "u1"); !errors.Is(err, errUploadBusy) {
+			t.Errorf("%s: second lock = %v, want errUploadBusy", name, err)
+		}
+		if other, err := tus.lock(ctx, "u2"); err != nil {
+			t.Errorf("%s: lock of another upload: %v", name, err)
+		} else {
+			other()
+		}
+		unlock()
+		again, err := tus.lock(ctx, "u1")
+		if err != nil {
+			t.Fatalf("%s: lock after unlock: %v", name, err)
+		}
+		again()
+	}
+
+	// a lock left by a crashed instance expires; its late unlock does not
+	// release the lock that replaced it
+	tus := newTusUploads(Deps{Redis: rdb})
+	stale, _ := tus.lock(ctx, "u3")
+	mr.FastForward(tusLockTTL + time.Second)
+	fresh, err := tus.lock(ctx, "u3")
+	if err != nil {
+		t.Fatalf("lock after expiry: %v", err)
+	}
+	stale()
+	if _, err := tus.lock(ctx, "u3"); !errors.Is(err, errUploadBusy) {
+		t.Errorf("lock after stale unlock = %v, want errUploadBusy", err)
+	}
+	fresh()
+}
+
+func TestTusPatchBusy(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer rdb.Close()
+	d := testDeps(t)
+	d.Redis = rdb
+	r := NewRouter(d)
+	w := tusRequest(t, r, http.MethodPost, "/uploads", nil, map[string]string{
+		"Upload-Length":   "10",
+		"Upload-Metadata": tusMetadata("filename", "clip.mp4", "type", "video_target_size", "target_size_mb", "10"),
+	})
+	loc := w.Header().Get("Location")
+	// another instance is in the middle of a PATCH
+	unlock, err := newTusUploads(d).lock(context.Background(), strings.TrimPrefix(loc, "/uploads/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	patch := func() *httptest.ResponseRecorder {
+		return tusRequest(t, r, http.MethodPatch, loc, strings.NewReader("abcd"), map[string]string{
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": "0",
+		})
+	}
+	if w := patch(); w.Code != http.StatusLocked {
+		t.Errorf("PATCH of a locked upload: %d, want 423", w.Code)
+	}
+	unlock()
+	if w := patch(); w.Code != http.StatusNoContent || w.Header().Get("Upload-Offset") != "4" {
+		t.Errorf("PATCH after unlock: %d offset %q", w.Code, w.Header().Get("Upload-Offset"))
+	}
+}
+
+func TestTusMetadataWhitelist(t *testing.T) {
+	d := testDeps(t)
+	w := tusRequest(t, NewRouter(d), http.MethodPost, "/uploads", nil, map[string]string{
+		"Upload-Length": "10",
+		"Upload-Metadata": tusMetadata("filename", "clip.mp4", "type", "video_target_size", "target_size_mb", "10",
+			"url", "https://videos.example/v", "urls", "https://videos.example/a", "playlist", "1", "format_id", "18"),
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: %d %s", w.Code, w.Body)
+	}
+	u, ok := d.Uploads.GetUpload(context.Background(), strings.TrimPrefix(w.Header().Get("Location"), "/uploads/"))
+	if !ok {
+		t.Fatal("upload record missing")
+	}
+	for _, k := range []string{"url", "urls", "playlist", "format_id"} {
+		if _, ok := u.Params[k]; ok {
+			t.Errorf("metadata key %q kept", k)
+		}
+	}
+	if u.Params["target_size_mb"] != "10" || u.Params["type"] != "video_target_size" {
+		t.Errorf("params = %v, want target_size_mb and type kept", u.Params)
+	}
+}
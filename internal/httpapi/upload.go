@@ -0,0 +1,493 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"comp/internal/auth"
+	"comp/internal/jobs"
+	"comp/internal/limits"
+	"comp/internal/media/ffmpeg"
+	"comp/internal/media/yt"
+	"comp/internal/webhook"
+)
+
+// processingTypes maps each accepted type to the kind of media it expects.
+var processingTypes = map[string]string{
+	"video_compress":    "video",
+	"video_target_size": "video",
+	"video_to_gif":      "video",
+	"video_to_audio":    "audio",
+	"image_compress":    "image",
+}
+
+// jobFromParams builds and validates a job from the processing form fields;
+// filename is the sanitized source name, empty for URL jobs.
+func jobFromParams(ctx context.Context, get func(string) string, filename string) (jobs.Job, error) {
+	pType := get("type")
+	if _, ok := processingTypes[pType]; !ok {
+		return jobs.Job{}, &uploadError{http.StatusBadRequest, "unknown processing type"}
+	}
+	j := jobs.Job{
+		Type:      pType,
+		URL:       strings.TrimSpace(get("url")),
+		Filename:  filename,
+		ImgFormat: strings.ToLower(strings.TrimSpace(get("img_format"))),
+	}
+	j.CRF, _ = strconv.Atoi(get("crf"))
+	j.Width, _ = strconv.Atoi(get("width"))
+	j.FPS, _ = strconv.Atoi(get("fps"))
+	j.Quality, _ = strconv.Atoi(get("quality"))
+	j.TargetSizeMB, _ = strconv.ParseFloat(get("target_size_mb"), 64)
+	if err := batchFromParams(get, &j); err != nil {
+		return jobs.Job{}, err
+	}
+	if err := clipFromParams(get, &j, pType); err != nil {
+		return jobs.Job{}, err
+	}
+	if j.URL != "" || len(j.URLs) > 0 {
+		maxHeight, _ := strconv.Atoi(get("max_height"))
+		if maxHeight < 0 || maxHeight > 8640 {
+			return jobs.Job{}, &uploadError{http.StatusBadRequest, "max_height must be between 0 and 8640"}
+		}
+		format, err := yt.FormatSelector(strings.TrimSpace(get("format_id")), maxHeight, pType == "video_to_audio")
+		if err != nil {
+			return jobs.Job{}, &uploadError{http.StatusBadRequest, err.Error()}
+		}
+		j.Format = format
+	}
+	if cb := strings.TrimSpace(get("callback_url")); cb != "" {
+		if err := webhook.ValidateURL(cb); err != nil {
+			return jobs.Job{}, &uploadError{http.StatusBadRequest, err.Error()}
+		}
+		j.CallbackURL = cb
+	}
+	if pType == "video_target_size" && j.TargetSizeMB <= 0 {
+		return jobs.Job{}, &uploadError{http.StatusBadRequest, "target_size_mb is required"}
+	}
+	if pType == "video_compress" {
+		opts := ffmpeg.CompressOptions{
+			CRF:          j.CRF,
+			VideoCodec:   get("video_codec"),
+			Preset:       get("preset"),
+			Container:    get("container"),
+			AudioCodec:   get("audio_codec"),
+			AudioBitrate: get("audio_bitrate"),
+		}
+		opts.Normalize(filepath.Ext(filename))
+		if err := opts.Validate(ctx); err != nil {
+			return jobs.Job{}, &uploadError{http.StatusBadRequest, err.Error()}
+		}
+		j.CRF, j.VideoCodec, j.Preset, j.Container = opts.CRF, opts.VideoCodec, opts.Preset, opts.Container
+		j.AudioCodec, j.AudioBitrate = opts.AudioCodec, opts.AudioBitrate
+	}
+	if pType == "video_to_gif" {
+		opts := ffmpeg.GIFOptions{
+			Format:    get("anim_format"),
+			StatsMode: get("stats_mode"),
+			Dither:    get("dither"),
+		}
+		opts.Colors, _ = strconv.Atoi(get("colors"))
+		opts.Loop, _ = strconv.Atoi(get("loop"))
+		opts.MaxSizeMB, _ = strconv.ParseFloat(get("max_size_mb"), 64)
+		opts.Normalize()
+		if err := opts.Validate(ctx); err != nil {
+			return jobs.Job{}, &uploadError{http.StatusBadRequest, err.Error()}
+		}
+		j.AnimFormat, j.Colors, j.StatsMode, j.Dither = opts.Format, opts.Colors, opts.StatsMode, opts.Dither
+		j.Loop, j.MaxSizeMB = opts.Loop, opts.MaxSizeMB
+	}
+	if pType == "video_to_audio" {
+		opts := ffmpeg.AudioOptions{
+			Format:      get("audio_format"),
+			Bitrate:     get("audio_bitrate"),
+			BitrateMode: get("bitrate_mode"),
+			Loudnorm:    isTrue(get("loudnorm")),
+			TrimSilence: isTrue(get("trim_silence")),
+		}
+		opts.SampleRate, _ = strconv.Atoi(get("sample_rate"))
+		opts.Channels, _ = strconv.Atoi(get("channels"))
+		opts.LUFS, _ = strconv.ParseFloat(get("lufs"), 64)
+		opts.Normalize()
+		if err := opts.Validate(ctx); err != nil {
+			return jobs.Job{}, &uploadError{http.StatusBadRequest, err.Error()}
+		}
+		j.AudioFormat, j.AudioBitrate, j.BitrateMode = opts.Format, opts.Bitrate, opts.BitrateMode
+		j.SampleRate, j.Channels = opts.SampleRate, opts.Channels
+		j.Loudnorm, j.LUFS, j.TrimSilence = opts.Loudnorm, opts.LUFS, opts.TrimSilence
+		j.Metadata = isTrue(get("metadata"))
+	}
+	return j, nil
+}
+
+// batchFromParams turns j into a batch when the form names a playlist or
+// several links (urls, one per line); a single link stays a plain URL job.
+func batchFromParams(get func(string) string, j *jobs.Job) error {
+	urls := strings.Fields(get("urls"))
+	if j.URL != "" {
+		urls = append([]string{j.URL}, urls...)
+	}
+	j.Playlist = isTrue(get("playlist"))
+	for _, u := range urls {
+		if !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+			return &uploadError{http.StatusBadRequest, "invalid url " + strconv.Quote(u)}
+		}
+	}
+	if !j.Playlist && len(urls) < 2 {
+		if len(urls) == 1 {
+			j.URL = urls[0]
+		}
+		return nil
+	}
+	if len(urls) == 0 {
+		return &uploadError{http.StatusBadRequest, "playlist needs a url"}
+	}
+	j.ItemType, j.Type = j.Type, "batch"
+	j.URL, j.URLs = "", urls
+	return nil
+}
+
+// clipFromParams reads start and end or duration, in seconds or [hh:]mm:ss.
+func clipFromParams(get func(string) string, j *jobs.Job, pType string) error {
+	start, end, length := strings.TrimSpace(get("start")), strings.TrimSpace(get("end")), strings.TrimSpace(get("duration"))
+	if start == "" && end == "" && length == "" {
+		return nil
+	}
+	switch pType {
+	case "video_compress", "video_to_gif", "video_to_audio":
+	default:
+		return &uploadError{http.StatusBadRequest, "start/end are not supported for " + pType}
+	}
+	if end != "" && length != "" {
+		return &uploadError{http.StatusBadRequest, "use either end or duration"}
+	}
+	parse := func(name, v string) (float64, error) {
+		if v == "" {
+			return 0, nil
+		}
+		t, err := ffmpeg.ParseTime(v)
+		if err != nil {
+			return 0, &uploadError{http.StatusBadRequest, name + ": " + err.Error()}
+		}
+		return t, nil
+	}
+	var err error
+	if j.Start, err = parse("start", start); err != nil {
+		return err
+	}
+	if j.End, err = parse("end", end); err != nil {
+		return err
+	}
+	d, err := parse("duration", length)
+	if err != nil {
+		return err
+	}
+	if length != "" {
+		if d <= 0 {
+			return &uploadError{http.StatusBadRequest, "duration must be positive"}
+		}
+		j.End = j.Start + d
+	}
+	if end != "" && j.End <= j.Start {
+		return &uploadError{http.StatusBadRequest, "end must be after start"}
+	}
+	return nil
+}
+
+// isTrue reads a form checkbox or JSON boolean.
+func isTrue(v string) bool {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "1", "true", "on", "yes":
+		return true
+	}
+	return false
+}
+
+// checkUploadSize enforces the per-type upload limit.
+func checkUploadSize(d Deps, pType string, size int64) error {
+	if limit := d.Cfg.UploadLimit(pType); limit > 0 && size > limit {
+		return &uploadError{http.StatusRequestEntityTooLarge, fmt.Sprintf("file too large for %s (max %d MB)", pType, limit>>20)}
+	}
+	return nil
+}
+
+// submitJob admits j against the caller's limits, runs stage (storing the
+// source, may be nil) and queues it. The active-job slot is released again
+// on failure. Errors are *uploadError or *limits.LimitError.
+func submitJob(c *gin.Context, d Deps, j *jobs.Job, stage func() error) error {
+	if max := d.Cfg.MaxBatchItems; max > 0 && len(j.URLs) > max {
+		return &uploadError{http.StatusBadRequest, fmt.Sprintf("too many urls (max %d)", max)}
+	}
+	if j.CallbackURL != "" && d.Cfg.WebhookSecret == "" {
+		// an unsigned callback could not be told apart from a forged one
+		return &uploadError{http.StatusBadRequest, "callback_url is not available: the server has no webhook_secret"}
+	}
+	j.Owner = auth.Owner(c)
+	j.Client = limits.Client(c)
+	if err := d.Limits.AdmitJob(c.Request.Context(), j.Client, j.ID); err != nil {
+		return err
+	}
+	if stage != nil {
+		if err := stage(); err != nil {
+			d.Limits.Release(context.Background(), j.Client, j.ID)
+			return err
+		}
+	}
+	// detached from the request so a disconnect cannot abort queueing, but
+	// keeping its trace for the worker
+	if err := d.Jobs.Submit(context.WithoutCancel(c.Request.Context()), *j); err != nil {
+		d.Limits.Release(context.Background(), j.Client, j.ID)
+		if errors.Is(err, jobs.ErrShuttingDown) {
+			return &uploadError{http.StatusServiceUnavailable, "server is shutting down, retry shortly"}
+		}
+		if d.Logger != nil {
+			d.Logger.Warnf("enqueue %s failed: %v", j.ID, err)
+		}
+		return &uploadError{http.StatusServiceUnavailable, "failed to queue task"}
+	}
+	return nil
+}
+
+// submitForm creates a job from a multipart (or urlencoded) form with either
+// a file or a url/urls field, as posted to /upload.
+func submitForm(c *gin.Context, d Deps) (jobs.Job, error) {
+	// the exact per-type limit is checked below
+	if err := parseUploadForm(c, d); err != nil {
+		return jobs.Job{}, err
+	}
+
+	var filename string
+	var file *multipart.FileHeader
+	if strings.TrimSpace(c.PostForm("url")) == "" && strings.TrimSpace(c.PostForm("urls")) == "" {
+		var err error
+		file, err = c.FormFile("file")
+		if err != nil {
+			return jobs.Job{}, &uploadError{http.StatusBadRequest, "File or URL is required"}
+		}
+		filename = sanitizeFilename(file.Filename)
+	}
+	j, err := jobFromParams(c.Request.Context(), c.PostForm, filename)
+	if err != nil {
+		return jobs.Job{}, err
+	}
+	j.ID = uuid.New().String()
+
+	var stage func() error
+	if file != nil {
+		if err := checkUploadSize(d, j.Type, file.Size); err != nil {
+			return jobs.Job{}, err
+		}
+		if err := sniffUpload(file); err != nil {
+			return jobs.Job{}, err
+		}
+		// keep the source in storage until a worker picks the job up
+		j.SrcKey = jobs.IncomingKey(j.ID, filename)
+		stage = func() error { return stageUpload(c, d, file, j.SrcKey, j.Type) }
+	}
+	if err := submitJob(c, d, &j, stage); err != nil {
+		return jobs.Job{}, err
+	}
+	return j, nil
+}
+
+// parseUploadForm parses a multipart (or urlencoded) form with the body
+// capped at the largest per-type upload limit.
+func parseUploadForm(c *gin.Context, d Deps) error {
+	if max := d.Cfg.MaxUploadLimit(); max > 0 {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, max+1<<20)
+	}
+	if err := c.Request.ParseMultipartForm(32 << 20); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		var tooBig *http.MaxBytesError
+		if errors.As(err, &tooBig) {
+			return &uploadError{http.StatusRequestEntityTooLarge, "file too large"}
+		}
+		return &uploadError{http.StatusBadRequest, "invalid form"}
+	}
+	return nil
+}
+
+// uploadError carries the HTTP status for a rejected upload.
+type uploadError struct {
+	status int
+	msg    string
+}
+
+func (e *uploadError) Error() string { return e.msg }
+
+func writeUploadError(c *gin.Context, err error) {
+	var ue *uploadError
+	var le *limits.LimitError
+	switch {
+	case errors.As(err, &ue):
+		c.JSON(ue.status, gin.H{"error": ue.msg})
+	case errors.As(err, &le):
+		limits.Abort(c, err)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read file"})
+	}
+}
+
+// blockedSignatures are magic numbers of archives and executables that are
+// never valid inputs, whatever the file is called.
+var blockedSignatures = []struct {
+	offset int
+	magic  []byte
+	what   string
+}{
+	{0, []byte("PK\x03\x04"), "zip archive"},
+	{0, []byte("Rar!\x1a\x07"), "rar archive"},
+	{0, []byte("7z\xbc\xaf\x27\x1c"), "7z archive"},
+	{0, []byte("\x1f\x8b"), "gzip archive"},
+	{0, []byte("BZh"), "bzip2 archive"},
+	{0, []byte("\xfd7zXZ\x00"), "xz archive"},
+	{257, []byte("ustar"), "tar archive"},
+	{0, []byte("\x7fELF"), "executable"},
+	{0, []byte("MZ"), "executable"},
+	{0, []byte("\xcf\xfa\xed\xfe"), "executable"},
+	{0, []byte("\xce\xfa\xed\xfe"), "executable"},
+	{0, []byte("\xca\xfe\xba\xbe"), "executable"},
+	{0, []byte("#!"), "script"},
+}
+
+// sniffUpload rejects archives, executables and obvious non-media by content.
+func sniffUpload(fh *multipart.FileHeader) error {
+	f, err := fh.Open()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return sniffReader(f)
+}
+
+func sniffReader(f io.Reader) error {
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(f, head)
+	head = head[:n]
+	if n == 0 {
+		return &uploadError{http.StatusBadRequest, "empty file"}
+	}
+	for _, sig := range blockedSignatures {
+		if len(head) >= sig.offset+len(sig.magic) && bytes.Equal(head[sig.offset:sig.offset+len(sig.magic)], sig.magic) {
+			return &uploadError{http.StatusUnsupportedMediaType, sig.what + " is not accepted"}
+		}
+	}
+	ct := http.DetectContentType(head)
+	for _, bad := range []string{"text/html", "text/xml", "application/pdf", "application/postscript", "application/wasm", "font/"} {
+		if strings.HasPrefix(ct, bad) {
+			return &uploadError{http.StatusUnsupportedMediaType, "unsupported file type " + ct}
+		}
+	}
+	return nil
+}
+
+// probeUpload checks with ffprobe that the saved file has the streams the
+// processing type needs.
+func probeUpload(ctx context.Context, path, pType string) error {
+	info, err := ffmpeg.Probe(ctx, path)
+	if err != nil {
+		return &uploadError{http.StatusUnsupportedMediaType, "file is not a recognizable media file"}
+	}
+	isImage := strings.HasSuffix(info.FormatName, "_pipe") || strings.HasPrefix(info.FormatName, "image2")
+	switch processingTypes[pType] {
+	case "video":
+		if isImage || info.Video() == nil {
+			return &uploadError{http.StatusUnsupportedMediaType, fmt.Sprintf("%s needs a video file", pType)}
+		}
+	case "audio":
+		if info.Audio() == nil {
+			return &uploadError{http.StatusUnsupportedMediaType, "file has no audio track"}
+		}
+	case "image":
+		if !isImage {
+			return &uploadError{http.StatusUnsupportedMediaType, "image_compress needs an image file"}
+		}
+	}
+	return nil
+}
+
+// stageUpload saves the multipart file to a scratch dir, probes it and puts
+// it into storage under key.
+func stageUpload(c *gin.Context, d Deps, fh *multipart.FileHeader, key, pType string) error {
+	dir, err := os.MkdirTemp("", "upload-")
+	if err != nil {
+		return &uploadError{http.StatusInternalServerError, "failed to save file"}
+	}
+	defer os.RemoveAll(dir)
+	local := filepath.Join(dir, path.Base(key))
+	if err := c.SaveUploadedFile(fh, local); err != nil {
+		return &uploadError{http.StatusInternalServerError, "failed to save file"}
+	}
+	return stageFile(c.Request.Context(), d, local, key, pType)
+}
+
+// stageFile probes a complete local file and puts it into storage under key.
+func stageFile(ctx context.Context, d Deps, local, key, pType string) error {
+	pctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	err := probeUpload(pctx, local, pType)
+	cancel()
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(local)
+	if err != nil {
+		return &uploadError{http.StatusInternalServerError, "failed to save file"}
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return &uploadError{http.StatusInternalServerError, "failed to save file"}
+	}
+	ctype := mime.TypeByExtension(strings.ToLower(filepath.Ext(key)))
+	if err := d.Storage.Put(ctx, key, f, fi.Size(), ctype); err != nil {
+		if d.Logger != nil {
+			d.Logger.Errorf("store upload %s: %v", key, err)
+		}
+		return &uploadError{http.StatusInternalServerError, "failed to save file"}
+	}
+	return nil
+}
+
+// sanitizeFilename reduces a client-supplied name to a safe single path
+// element: no directories, no control or shell characters, no leading dots.
+func sanitizeFilename(name string) string {
+	name = strings.ReplaceAll(name, "\\", "/")
+	name = filepath.Base(name)
+	var b strings.Builder
+	for _, r := range name {
+		switch {
+		case unicode.IsLetter(r), unicode.IsDigit(r), r == '.', r == '-', r == '_':
+			b.WriteRune(r)
+		case r == ' ':
+			b.WriteRune('_')
+		}
+	}
+	out := strings.TrimLeft(b.String(), ".")
+	ext := filepath.Ext(out)
+	if len(ext) > 16 {
+		ext = ""
+	}
+	base := strings.TrimSuffix(out, ext)
+	if r := []rune(base); len(r) > 100 {
+		base = string(r[:100])
+	}
+	if base == "" {
+		base = "file"
+	}
+	return base + ext
+}
@@ -0,0 +1,29 @@
+package httpapi
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSanitizeFilename(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"clip.mp4", "clip.mp4"},
+		{"my holiday video.MOV", "my_holiday_video.MOV"},
+		{"../../etc/passwd", "passwd"},
+		{`C:\Users\me\Desktop\clip.mp4`, "clip.mp4"},
+		{".hidden.mp4", "hidden.mp4"},
+		{"...", "file"},
+		{"", "file"},
+		{"$(rm -rf ~);`x`.mp4", "rm_-rf_x.mp4"},
+		{"видео.mp4", "видео.mp4"},
+		{"a\x00b\nc.mp4", "abc.mp4"},
+		// an overlong extension is not kept apart when the name is shortened
+		{strings.Repeat("y", 120) + ".averyveryverylongextension", strings.Repeat("y", 100)},
+		{strings.Repeat("x", 300) + ".mp4", strings.Repeat("x", 100) + ".mp4"},
+	}
+	for _, tt := range tests {
+		if got := sanitizeFilename(tt.in); got != tt.want {
+			t.Errorf("sanitizeFilename(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
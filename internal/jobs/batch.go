@@ -0,0 +1,143 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"comp/internal/media/yt"
+	"comp/internal/store"
+)
+
+// expand turns a batch job into item jobs. The batch itself stays in
+// processing (stage "items") until the pool has seen every item finish.
+func (p *Processor) expand(ctx context.Context, j Job) {
+	_ = p.Store.Set(ctx, &store.TaskStatus{ID: j.ID, Status: "processing", Stage: "expand", Percent: 0}, 30*time.Minute)
+	var entries []yt.Entry
+	for _, u := range j.URLs {
+		if !j.Playlist {
+			entries = append(entries, yt.Entry{URL: u})
+			continue
+		}
+		pl, err := yt.Expand(ctx, u, p.Cfg.Proxy)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			_ = p.Store.Set(ctx, &store.TaskStatus{ID: j.ID, Status: "failed", Error: "playlist expansion failed: " + err.Error()}, 30*time.Minute)
+			return
+		}
+		entries = append(entries, pl.Entries...)
+	}
+	if len(entries) == 0 {
+		_ = p.Store.Set(ctx, &store.TaskStatus{ID: j.ID, Status: "failed", Error: "playlist is empty"}, 30*time.Minute)
+		return
+	}
+	if max := p.Cfg.MaxBatchItems; max > 0 && len(entries) > max {
+		if p.Logger != nil {
+			p.Logger.Infof("[%s] batch truncated from %d to %d items", j.ID, len(entries), max)
+		}
+		entries = entries[:max]
+	}
+
+	// record the items first, so a cancel arriving meanwhile reaches them
+	ids := make([]string, len(entries))
+	for i := range ids {
+		ids[i] = uuid.New().String()
+	}
+	_ = p.Store.Set(ctx, &store.TaskStatus{ID: j.ID, Status: "processing", Stage: "items", Children: ids,
+		Batch: &store.BatchStats{Total: len(ids)}}, 30*time.Minute)
+	for i, e := range entries {
+		item := j
+		item.ID = ids[i]
+		item.Type = j.ItemType
+		item.URL = e.URL
+		item.URLs, item.Playlist, item.ItemType = nil, false, ""
+		item.Parent = j.ID
+		// the batch reports once for all of its items
+		item.CallbackURL = ""
+		if err := p.Submit(ctx, item); err != nil {
+			if p.Logger != nil {
+				p.Logger.Warnf("[%s] queue item %s: %v", j.ID, item.ID, err)
+			}
+			_ = p.Store.Set(context.Background(), &store.TaskStatus{ID: item.ID, Status: "failed", Error: "failed to queue item",
+				Type: item.Type, SourceName: item.URL, Parent: j.ID}, 30*time.Minute)
+		}
+	}
+}
+
+// updateBatch recomputes the progress of batch t from its items and
+// finishes it once all of them have finished: completed if any item
+// completed, failed otherwise.
+func (p *Pool) updateBatch(ctx context.Context, t *store.TaskStatus) {
+	if Finished(t.Status) || len(t.Children) == 0 {
+		return
+	}
+	stats := store.BatchStats{Total: len(t.Children)}
+	sum := 0
+	for _, id := range t.Children {
+		c, ok := p.Store.Get(ctx, id)
+		if !ok {
+			// expired or never stored: nothing will come of it
+			stats.Finished++
+			stats.Failed++
+			sum += 100
+			continue
+		}
+		if !Finished(c.Status) {
+			sum += c.Percent
+			continue
+		}
+		stats.Finished++
+		sum += 100
+		if c.Status == "completed" {
+			stats.Completed++
+		} else {
+			stats.Failed++
+		}
+	}
+	u := &store.TaskStatus{ID: t.ID, Stage: "items", Percent: sum / stats.Total, Batch: &stats}
+	if stats.Finished == stats.Total {
+		u.Status, u.Stage, u.Percent = "completed", "finalize", 100
+		if stats.Completed == 0 {
+			u.Status = "failed"
+			u.Error = "all items failed"
+		} else if stats.Failed > 0 {
+			u.Error = fmt.Sprintf("%d of %d items failed", stats.Failed, stats.Total)
+		}
+	}
+	_ = p.Store.Set(ctx, u, 30*time.Minute)
+	if u.Status != "" && p.settle(ctx, t.ID) {
+		p.finished(t.ID)
+	}
+}
+
+// cancelItems cancels the unfinished items of a cancelled batch.
+func (p *Pool) cancelItems(ctx context.Context, t *store.TaskStatus) {
+	for _, id := range t.Children {
+		if err := p.Cancel(ctx, id); err != nil && err != ErrFinished && err != ErrNotFound && p.Logger != nil {
+			p.Logger.Warnf("cancel item %s of %s: %v", id, t.ID, err)
+		}
+	}
+}
+
+// settle reports whether the caller is the first to finish batch id. Its
+// last items may finish on different workers or instances at once, and
+// the batch must be reported only once. When Redis cannot tell, nobody
+// settles, rather than every caller.
+func (p *Pool) settle(ctx context.Context, id string) bool {
+	if p.Redis == nil {
+		_, loaded := p.settled.LoadOrStore(id, struct{}{})
+		return !loaded
+	}
+	ok, err := p.Redis.SetNX(ctx, "task:"+id+":settled", 1, 48*time.Hour).Result()
+	if err != nil {
+		if p.Logger != nil {
+			p.Logger.Warnf("settle batch %s: %v", id, err)
+		}
+		return false
+	}
+	return ok
+}
@@ -0,0 +1,182 @@
+package jobs
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	redis "github.com/redis/go-redis/v9"
+
+	cfgpkg "comp/internal/config"
+	"comp/internal/store"
+)
+
+func TestSettleOnce(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer rdb.Close()
+	for name, p := range map[string]*Pool{"memory": {}, "redis": {Redis: rdb}} {
+		t.Run(name, func(t *testing.T) {
+			if !p.settle(ctx, "b1") {
+				t.Fatal("first settle lost")
+			}
+			if p.settle(ctx, "b1") {
+				t.Error("batch settled twice")
+			}
+		})
+	}
+}
+
+func TestSettleRedisDown(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})
+	defer rdb.Close()
+	mr.Close()
+	p := &Pool{Redis: rdb}
+	if p.settle(context.Background(), "b1") {
+		t.Error("settle succeeded without Redis")
+	}
+}
+
+// fakePlaylists points yt-dlp at a script that knows two playlists and
+// the videos under v.example.
+func fakePlaylists(t *testing.T) {
+	t.Helper()
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("needs sh")
+	}
+	bin := filepath.Join(t.TempDir(), "yt-dlp")
+	script := `#!/bin/sh
+for a; do url=$a; done
+case "$url" in
+*/list) echo '{"title":"list","entries":[{"url":"https://v.example/1"},{"_type":"playlist","url":"https://v.example/tab"},{"webpage_url":"https://v.example/2","url":"2"}]}';;
+*/empty) echo '{"title":"empty","entries":[]}';;
+https://v.example/*) echo "{\"title\":\"video\",\"webpage_url\":\"$url\"}";;
+*) echo "ERROR: Unsupported URL: $url" >&2; exit 1;;
+esac
+`
+	if err := os.WriteFile(bin, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("YT_DLP", bin)
+}
+
+func TestExpand(t *testing.T) {
+	fakePlaylists(t)
+	tests := []struct {
+		name     string
+		urls     []string
+		playlist bool
+		max      int
+		items    []string
+		err      string
+	}{
+		{"urls", []string{"https://v.example/a", "https://v.example/b"}, false, 0, []string{"https://v.example/a", "https://v.example/b"}, ""},
+		{"playlist", []string{"https://p.example/list", "https://v.example/3"}, true, 0,
+			[]string{"https://v.example/1", "https://v.example/2", "https://v.example/3"}, ""},
+		{"capped", []string{"https://p.example/list", "https://v.example/3"}, true, 2, []string{"https://v.example/1", "https://v.example/2"}, ""},
+		{"empty playlist", []string{"https://p.example/empty"}, true, 0, nil, "playlist is empty"},
+		{"bad playlist", []string{"https://p.example/nope"}, true, 0, nil, "playlist expansion failed: unsupported URL"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			st := store.NewRedisStore(nil)
+			var queued []Job
+			p := &Processor{Cfg: cfgpkg.Config{MaxBatchItems: tt.max}, Store: st, Submit: func(_ context.Context, j Job) error {
+				queued = append(queued, j)
+				return nil
+			}}
+			p.expand(ctx, Job{ID: "b1", Type: "batch", ItemType: "video_to_audio", URLs: tt.urls, Playlist: tt.playlist,
+				CallbackURL: "https://hooks.example/b1", AudioFormat: "mp3"})
+
+			b, _ := st.Get(ctx, "b1")
+			if tt.err != "" {
+				if b.Status != "failed" || b.Error != tt.err || len(queued) != 0 {
+					t.Errorf("batch = %s %q with %d items, want failed %q without items", b.Status, b.Error, len(queued), tt.err)
+				}
+				return
+			}
+			var urls, ids []string
+			for _, j := range queued {
+				urls = append(urls, j.URL)
+				ids = append(ids, j.ID)
+				if j.Type != "video_to_audio" || j.Parent != "b1" || j.CallbackURL != "" || j.AudioFormat != "mp3" || j.URLs != nil || j.Playlist || j.ItemType != "" {
+					t.Errorf("item %+v: want a video_to_audio job of b1 with the batch options and no callback", j)
+				}
+			}
+			if !slices.Equal(urls, tt.items) {
+				t.Errorf("items = %v, want %v", urls, tt.items)
+			}
+			if b.Status != "processing" || b.Stage != "items" || !slices.Equal(b.Children, ids) || b.Batch == nil || b.Batch.Total != len(ids) {
+				t.Errorf("batch = %+v, want processing items with children %v", b, ids)
+			}
+		})
+	}
+}
+
+func TestUpdateBatch(t *testing.T) {
+	type item struct {
+		status  string
+		percent int
+	}
+	tests := []struct {
+		name    string
+		items   []item
+		status  string
+		percent int
+		stats   store.BatchStats
+		err     string
+	}{
+		{"running", []item{{"completed", 100}, {"processing", 50}, {"queued", 0}}, "processing", 50,
+			store.BatchStats{Total: 3, Finished: 1, Completed: 1}, ""},
+		{"partly failed", []item{{"completed", 100}, {"failed", 20}, {"cancelled", 0}}, "completed", 100,
+			store.BatchStats{Total: 3, Finished: 3, Completed: 1, Failed: 2}, "2 of 3 items failed"},
+		{"all failed", []item{{"failed", 0}, {"interrupted", 0}}, "failed", 100,
+			store.BatchStats{Total: 2, Finished: 2, Failed: 2}, "all items failed"},
+		// an item without a record will never finish
+		{"item gone", []item{{"completed", 100}, {"", 0}}, "completed", 100,
+			store.BatchStats{Total: 2, Finished: 2, Completed: 1, Failed: 1}, "1 of 2 items failed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			st := store.NewRedisStore(nil)
+			var done []string
+			p := &Pool{Queue: st, Store: st, OnFinish: func(id string) { done = append(done, id) }}
+			var children []string
+			for i, it := range tt.items {
+				id := "i" + strconv.Itoa(i+1)
+				children = append(children, id)
+				if it.status != "" {
+					_ = st.Set(ctx, &store.TaskStatus{ID: id, Status: it.status, Percent: it.percent, Parent: "b1"}, time.Hour)
+				}
+			}
+			_ = st.Set(ctx, &store.TaskStatus{ID: "b1", Type: "batch", Status: "processing", Stage: "items", Children: children}, time.Hour)
+
+			for range 2 {
+				b, _ := st.Get(ctx, "b1")
+				p.updateBatch(ctx, b)
+			}
+			b, _ := st.Get(ctx, "b1")
+			if b.Status != tt.status || b.Percent != tt.percent || b.Error != tt.err || b.Batch == nil || *b.Batch != tt.stats {
+				t.Errorf("batch = %s %d%% %q %+v; want %s %d%% %q %+v", b.Status, b.Percent, b.Error, b.Batch, tt.status, tt.percent, tt.err, tt.stats)
+			}
+			// a finished batch is reported once
+			want := []string(nil)
+			if store.Finished(tt.status) {
+				want = []string{"b1"}
+			}
+			if !slices.Equal(done, want) {
+				t.Errorf("OnFinish calls = %v, want %v", done, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,490 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	redis "github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+
+	"comp/internal/media/ffmpeg"
+	"comp/internal/metrics"
+	"comp/internal/store"
+	"comp/internal/tracing"
+)
+
+// Job describes one processing request as it is kept in the queue.
+type Job struct {
+	ID        string `json:"id"`
+	Owner     string `json:"owner,omitempty"`
+	Client    string `json:"client,omitempty"`
+	Type      string `json:"type"`
+	URL       string `json:"url,omitempty"`
+	SrcKey    string `json:"src_key,omitempty"`  // uploaded source in storage
+	SrcPath   string `json:"src_path,omitempty"` // local source of jobs queued before storage existed
+	Filename  string `json:"filename,omitempty"`
+	ImgFormat string `json:"img_format,omitempty"`
+	CRF       int    `json:"crf,omitempty"`
+	Width     int    `json:"width,omitempty"`
+	FPS       int    `json:"fps,omitempty"`
+	Quality   int    `json:"quality,omitempty"`
+	// TargetSizeMB is the desired output size for video_target_size.
+	TargetSizeMB float64 `json:"target_size_mb,omitempty"`
+	// Encoder choice for video_compress, already normalized and validated.
+	VideoCodec   string `json:"video_codec,omitempty"`
+	Preset       string `json:"preset,omitempty"`
+	Container    string `json:"container,omitempty"`
+	AudioCodec   string `json:"audio_codec,omitempty"`
+	AudioBitrate string `json:"audio_bitrate,omitempty"`
+	// Animation settings for video_to_gif, already normalized and validated.
+	AnimFormat string  `json:"anim_format,omitempty"`
+	Colors     int     `json:"colors,omitempty"`
+	StatsMode  string  `json:"stats_mode,omitempty"`
+	Dither     string  `json:"dither,omitempty"`
+	Loop       int     `json:"loop,omitempty"`
+	MaxSizeMB  float64 `json:"max_size_mb,omitempty"`
+	// Audio settings for video_to_audio, already normalized and validated;
+	// AudioBitrate above is shared with video_compress.
+	AudioFormat string  `json:"audio_format,omitempty"`
+	BitrateMode string  `json:"bitrate_mode,omitempty"`
+	SampleRate  int     `json:"sample_rate,omitempty"`
+	Channels    int     `json:"channels,omitempty"`
+	Loudnorm    bool    `json:"loudnorm,omitempty"`
+	LUFS        float64 `json:"lufs,omitempty"`
+	TrimSilence bool    `json:"trim_silence,omitempty"`
+	// Metadata tags the audio with title, artist and thumbnail of a URL source.
+	Metadata bool `json:"metadata,omitempty"`
+	// Format is the yt-dlp -f selector for URL sources; empty takes its default.
+	Format string `json:"format,omitempty"`
+	// Start and End (seconds, End 0 = to the end) clip the source for
+	// video_compress, video_to_gif and video_to_audio.
+	Start float64 `json:"start,omitempty"`
+	End   float64 `json:"end,omitempty"`
+	// CallbackURL receives a signed POST once the task finishes.
+	CallbackURL string `json:"callback_url,omitempty"`
+	// A batch job (Type "batch") expands URLs, or the playlists behind them,
+	// into item jobs of ItemType with the same options; items name it as Parent.
+	URLs     []string `json:"urls,omitempty"`
+	Playlist bool     `json:"playlist,omitempty"`
+	ItemType string   `json:"item_type,omitempty"`
+	Parent   string   `json:"parent,omitempty"`
+	// QueuedAt and Trace continue the submitting request's trace in the worker.
+	QueuedAt time.Time         `json:"queued_at,omitempty"`
+	Trace    map[string]string `json:"trace,omitempty"`
+}
+
+// CompressOptions returns the encoder settings of a video_compress job.
+func (j Job) CompressOptions() ffmpeg.CompressOptions {
+	return ffmpeg.CompressOptions{
+		CRF:          j.CRF,
+		MaxWidth:     j.Width,
+		FPS:          j.FPS,
+		VideoCodec:   j.VideoCodec,
+		Preset:       j.Preset,
+		Container:    j.Container,
+		AudioCodec:   j.AudioCodec,
+		AudioBitrate: j.AudioBitrate,
+		Clip:         j.Clip(),
+	}
+}
+
+// GIFOptions returns the animation settings of a video_to_gif job.
+func (j Job) GIFOptions() ffmpeg.GIFOptions {
+	return ffmpeg.GIFOptions{
+		Format:    j.AnimFormat,
+		MaxWidth:  j.Width,
+		FPS:       j.FPS,
+		Colors:    j.Colors,
+		StatsMode: j.StatsMode,
+		Dither:    j.Dither,
+		Loop:      j.Loop,
+		MaxSizeMB: j.MaxSizeMB,
+		Clip:      j.Clip(),
+	}
+}
+
+// AudioOptions returns the settings of a video_to_audio job.
+func (j Job) AudioOptions() ffmpeg.AudioOptions {
+	return ffmpeg.AudioOptions{
+		Format:      j.AudioFormat,
+		Bitrate:     j.AudioBitrate,
+		BitrateMode: j.BitrateMode,
+		SampleRate:  j.SampleRate,
+		Channels:    j.Channels,
+		Loudnorm:    j.Loudnorm,
+		LUFS:        j.LUFS,
+		TrimSilence: j.TrimSilence,
+		Clip:        j.Clip(),
+	}
+}
+
+// Clip returns the time range of the source the job processes.
+func (j Job) Clip() ffmpeg.Clip {
+	return ffmpeg.Clip{Start: j.Start, End: j.End}
+}
+
+// Params returns the processing options that were set, for the task record.
+func (j Job) Params() map[string]string {
+	m := map[string]string{}
+	add := func(k, v string) {
+		if v != "" && v != "0" {
+			m[k] = v
+		}
+	}
+	add("crf", strconv.Itoa(j.CRF))
+	add("width", strconv.Itoa(j.Width))
+	add("fps", strconv.Itoa(j.FPS))
+	add("quality", strconv.Itoa(j.Quality))
+	add("target_size_mb", strconv.FormatFloat(j.TargetSizeMB, 'f', -1, 64))
+	add("img_format", j.ImgFormat)
+	add("video_codec", j.VideoCodec)
+	add("preset", j.Preset)
+	add("container", j.Container)
+	add("audio_codec", j.AudioCodec)
+	add("audio_bitrate", j.AudioBitrate)
+	add("anim_format", j.AnimFormat)
+	add("colors", strconv.Itoa(j.Colors))
+	add("stats_mode", j.StatsMode)
+	add("dither", j.Dither)
+	add("loop", strconv.Itoa(j.Loop))
+	add("max_size_mb", strconv.FormatFloat(j.MaxSizeMB, 'f', -1, 64))
+	add("audio_format", j.AudioFormat)
+	add("bitrate_mode", j.BitrateMode)
+	add("sample_rate", strconv.Itoa(j.SampleRate))
+	add("channels", strconv.Itoa(j.Channels))
+	if j.Loudnorm {
+		add("lufs", strconv.FormatFloat(j.LUFS, 'f', -1, 64))
+	}
+	if j.TrimSilence {
+		add("trim_silence", "true")
+	}
+	if j.Metadata {
+		add("metadata", "true")
+	}
+	add("format", j.Format)
+	add("start", strconv.FormatFloat(j.Start, 'f', -1, 64))
+	add("end", strconv.FormatFloat(j.End, 'f', -1, 64))
+	add("item_type", j.ItemType)
+	if j.Playlist {
+		add("playlist", "true")
+	}
+	return m
+}
+
+// Handler processes a single dequeued job.
+type Handler func(ctx context.Context, j Job)
+
+// Pool runs a fixed number of workers that consume jobs from Queue.
+type Pool struct {
+	Queue   store.Queue
+	Store   store.Store
+	Logger  *zap.SugaredLogger
+	Workers int
+	Handler Handler
+	// Discard removes on-disk leftovers of a cancelled job (optional).
+	Discard func(taskID string)
+	// Redis, when set, broadcasts cancellations to the other instances.
+	Redis *redis.Client
+	// OnFinish is called with the task ID once a job has stopped for good:
+	// processed, failed, cancelled or interrupted (optional).
+	OnFinish func(taskID string)
+	// Requeue puts jobs interrupted by Shutdown back into the queue instead
+	// of marking them interrupted, when their source can be fetched again.
+	Requeue bool
+	// Name identifies this instance's workers in the queue; Start picks a
+	// random one when it is empty.
+	Name string
+
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	running  map[string]context.CancelCauseFunc
+	draining atomic.Bool
+	settled  sync.Map // finished batches, when there is no Redis
+}
+
+var (
+	ErrNotFound = errors.New("task not found")
+	ErrFinished = errors.New("task already finished")
+	// ErrShuttingDown is returned by Submit once Shutdown has begun.
+	ErrShuttingDown = errors.New("server is shutting down")
+)
+
+const cancelChannel = "tasks:cancel"
+
+var tracer = otel.Tracer("comp/internal/jobs")
+
+// Finished reports whether status is terminal.
+func Finished(status string) bool {
+	return store.Finished(status)
+}
+
+// Submit marks the task as queued and appends it to the queue. The queued
+// record has no TTL however long the job waits; the worker sets one.
+func (p *Pool) Submit(ctx context.Context, j Job) error {
+	if p.draining.Load() {
+		return ErrShuttingDown
+	}
+	j.QueuedAt = time.Now()
+	j.Trace = tracing.Inject(ctx)
+	b, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	if err := p.Store.SetOwner(ctx, j.ID, j.Owner, 0); err != nil {
+		return err
+	}
+	source := j.Filename
+	if j.URL != "" {
+		source = j.URL
+	}
+	if len(j.URLs) > 0 {
+		source = strings.Join(j.URLs, "\n")
+	}
+	t := &store.TaskStatus{ID: j.ID, Status: "queued", Stage: "queued", Type: j.Type, Params: j.Params(), SourceName: source, Parent: j.Parent}
+	if j.CallbackURL != "" {
+		t.Callback = &store.Callback{URL: j.CallbackURL, State: "pending"}
+	}
+	if err := p.Store.Set(ctx, t, 0); err != nil {
+		return err
+	}
+	return p.Queue.Enqueue(ctx, j.ID, b)
+}
+
+// Start launches the workers; they stop when ctx is cancelled.
+func (p *Pool) Start(ctx context.Context) {
+	n := p.Workers
+	if n <= 0 {
+		n = 1
+	}
+	p.mu.Lock()
+	if p.running == nil {
+		p.running = make(map[string]context.CancelCauseFunc)
+	}
+	if p.Name == "" {
+		p.Name = uuid.New().String()[:8]
+	}
+	p.mu.Unlock()
+	for i := 0; i < n; i++ {
+		p.wg.Add(1)
+		go p.worker(ctx, i)
+	}
+	if p.Redis != nil {
+		go p.listenCancels(ctx)
+	}
+	if p.Logger != nil {
+		p.Logger.Infof("job pool started with %d workers", n)
+	}
+}
+
+// Wait blocks until all workers have returned.
+func (p *Pool) Wait() { p.wg.Wait() }
+
+// workerName names worker n's processing list in the queue.
+func (p *Pool) workerName(n int) string { return fmt.Sprintf("%s-%d", p.Name, n) }
+
+func (p *Pool) worker(ctx context.Context, n int) {
+	defer p.wg.Done()
+	name := p.workerName(n)
+	// while the worker lives, RecoverOrphans leaves its claims alone
+	defer p.lease(ctx, workerKey(name))()
+	for ctx.Err() == nil && !p.draining.Load() {
+		id, payload, err := p.Queue.Dequeue(ctx, name, 5*time.Second)
+		if err != nil {
+			if errors.Is(err, store.ErrQueueEmpty) || ctx.Err() != nil {
+				continue
+			}
+			if p.Logger != nil {
+				p.Logger.Warnf("worker %d: dequeue failed: %v", n, err)
+			}
+			time.Sleep(time.Second)
+			continue
+		}
+		if p.draining.Load() {
+			// Shutdown began while we were blocked; leave it for the next instance
+			if err := p.Queue.Requeue(context.Background(), name, id, payload); err != nil && p.Logger != nil {
+				p.Logger.Warnf("worker %d: put back %s: %v", n, id, err)
+			}
+			return
+		}
+		var j Job
+		if err := json.Unmarshal(payload, &j); err != nil {
+			if p.Logger != nil {
+				p.Logger.Warnf("worker %d: bad job %s: %v", n, id, err)
+			}
+			_ = p.Store.Set(ctx, &store.TaskStatus{ID: id, Status: "failed", Error: "invalid job payload"}, 30*time.Minute)
+			p.finished(id)
+			p.ack(name, id)
+			continue
+		}
+		if t, ok := p.Store.Get(ctx, id); ok && t.Status == "cancelled" {
+			// cancelled while being dequeued; Cancel could not notify
+			p.finished(id)
+			p.ack(name, id)
+			continue
+		}
+		if p.Logger != nil {
+			p.Logger.Debugf("worker %d: running %s (%s)", n, j.ID, j.Type)
+		}
+		p.run(ctx, name, j)
+	}
+}
+
+func (p *Pool) run(ctx context.Context, worker string, j Job) {
+	ctx = tracing.Extract(ctx, j.Trace)
+	tracing.QueueWait(ctx, j.ID, j.QueuedAt)
+	ctx, span := tracer.Start(ctx, "job "+j.Type, trace.WithAttributes(
+		attribute.String("task.id", j.ID),
+		attribute.String("task.type", j.Type),
+	))
+	defer func() {
+		if t, ok := p.Store.Get(ctx, j.ID); ok {
+			span.SetAttributes(attribute.String("task.status", t.Status))
+			if t.Status == "failed" {
+				span.SetStatus(codes.Error, t.Error)
+			}
+		}
+		span.End()
+	}()
+	jctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	p.mu.Lock()
+	p.running[j.ID] = cancel
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		delete(p.running, j.ID)
+		p.mu.Unlock()
+	}()
+	defer p.lease(jctx, leaseKey(j.ID))()
+	p.Handler(jctx, j)
+	if interrupted(jctx) {
+		p.interrupt(worker, j)
+		return
+	}
+	p.finished(j.ID)
+	p.ack(worker, j.ID)
+}
+
+// ack releases a job the worker is done with. It comes after the task
+// record is final, so a job lost in between is recognised as done.
+func (p *Pool) ack(worker, id string) {
+	if err := p.Queue.Ack(context.Background(), worker, id); err != nil && p.Logger != nil {
+		p.Logger.Warnf("ack %s: %v", id, err)
+	}
+}
+
+func (p *Pool) finished(id string) {
+	ctx := context.Background()
+	t, ok := p.Store.Get(ctx, id)
+	if ok && t.Type == "batch" && !Finished(t.Status) {
+		// expanded; the batch finishes with its last item
+		p.updateBatch(ctx, t)
+		return
+	}
+	if ok {
+		metrics.JobsTotal.WithLabelValues(t.Type, t.Status).Inc()
+		if t.Status == "completed" {
+			metrics.BytesIn.WithLabelValues(t.Type).Add(float64(t.InputSize))
+			metrics.BytesOut.WithLabelValues(t.Type).Add(float64(t.OutputSize))
+			if t.CompressionRatio > 0 {
+				metrics.CompressionRatio.WithLabelValues(t.Type).Observe(t.CompressionRatio)
+			}
+		}
+	}
+	if p.OnFinish != nil {
+		p.OnFinish(id)
+	}
+	if !ok {
+		return
+	}
+	if t.Parent != "" {
+		if parent, ok := p.Store.Get(ctx, t.Parent); ok {
+			p.updateBatch(ctx, parent)
+		}
+	}
+	if t.Status == "cancelled" && len(t.Children) > 0 {
+		p.cancelItems(ctx, t)
+	}
+}
+
+// Cancel stops a queued or running task and marks it cancelled. Tasks running
+// on another instance are reached through Redis pub/sub.
+func (p *Pool) Cancel(ctx context.Context, id string) error {
+	t, ok := p.Store.Get(ctx, id)
+	if !ok {
+		return ErrNotFound
+	}
+	if Finished(t.Status) {
+		return ErrFinished
+	}
+	wasQueued, err := p.Queue.Remove(ctx, id)
+	if err != nil && p.Logger != nil {
+		p.Logger.Warnf("remove %s from queue: %v", id, err)
+	}
+	if err := p.Store.Set(ctx, &store.TaskStatus{ID: id, Status: "cancelled", Stage: t.Stage, Percent: t.Percent}, 30*time.Minute); err != nil {
+		return err
+	}
+	if wasQueued {
+		if p.Discard != nil {
+			p.Discard(id)
+		}
+		p.finished(id)
+		return nil
+	}
+	if p.cancelLocal(id) {
+		return nil
+	}
+	if t.Stage == "items" {
+		// an expanded batch has no process of its own
+		if p.settle(ctx, id) {
+			p.finished(id)
+		}
+		return nil
+	}
+	if p.Redis != nil {
+		return p.Redis.Publish(ctx, cancelChannel, id).Err()
+	}
+	return nil
+}
+
+func (p *Pool) cancelLocal(id string) bool {
+	p.mu.Lock()
+	cancel, ok := p.running[id]
+	p.mu.Unlock()
+	if ok {
+		cancel(nil)
+		if p.Logger != nil {
+			p.Logger.Infof("task %s cancelled", id)
+		}
+	}
+	return ok
+}
+
+func (p *Pool) listenCancels(ctx context.Context) {
+	sub := p.Redis.Subscribe(ctx, cancelChannel)
+	defer sub.Close()
+	ch := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			p.cancelLocal(msg.Payload)
+		}
+	}
+}
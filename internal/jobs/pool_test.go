@@ -0,0 +1,229 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	redis "github.com/redis/go-redis/v9"
+
+	"comp/internal/storage"
+	"comp/internal/store"
+)
+
+func TestSubmitQueuedRecordHasNoTTL(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer rdb.Close()
+	st := store.NewRedisStore(rdb)
+	p := &Pool{Queue: st, Store: st}
+	if err := p.Submit(ctx, Job{ID: "t1", Owner: "alice", Type: "video_compress", Filename: "a.mp4"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"task:t1", "task:t1:owner"} {
+		if ttl := mr.TTL(key); ttl != 0 {
+			t.Errorf("TTL of %s = %s, want none while queued", key, ttl)
+		}
+	}
+}
+
+func TestRecoverOrphansRequeuesClaims(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewRedisStore(nil)
+	p := &Pool{Queue: st, Store: st}
+	for _, id := range []string{"waiting", "started"} {
+		if err := p.Submit(ctx, Job{ID: id, Type: "video_compress", SrcPath: "/tmp/x.mp4"}); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := st.Dequeue(ctx, "gone-0", time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = st.Set(ctx, &store.TaskStatus{ID: "started", Status: "processing", Stage: "transcode"}, 0)
+
+	n, err := p.RecoverOrphans(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("RecoverOrphans marked %d tasks, want 1", n)
+	}
+	if claims, _ := st.Claims(ctx); len(claims) != 0 {
+		t.Errorf("claims left: %+v", claims)
+	}
+	// a job that never started goes back to the queue
+	if pos, _ := st.Position(ctx, "waiting"); pos != 1 {
+		t.Errorf("Position(waiting) = %d, want 1", pos)
+	}
+	// a started job without a re-fetchable source cannot start over
+	if tk, _ := st.Get(ctx, "started"); tk.Status != "interrupted" {
+		t.Errorf("started task is %q, want interrupted", tk.Status)
+	}
+}
+
+func TestRemoveOrphanDirsKeepsLeasedTasks(t *testing.T) {
+	ctx := context.Background()
+	t.Setenv("TMPDIR", t.TempDir())
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer rdb.Close()
+	for _, id := range []string{"dead", "live"} {
+		if err := os.MkdirAll(JobDir(id), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// another instance on this host is still running "live"
+	_ = rdb.Set(ctx, leaseKey("live"), "1", leaseTTL).Err()
+
+	p := &Pool{Redis: rdb}
+	n, err := p.RemoveOrphanDirs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("removed %d dirs, want 1", n)
+	}
+	if _, err := os.Stat(JobDir("dead")); !os.IsNotExist(err) {
+		t.Errorf("dir of the dead task still there: %v", err)
+	}
+	if _, err := os.Stat(JobDir("live")); err != nil {
+		t.Errorf("dir of the leased task removed: %v", err)
+	}
+}
+
+// cancelPool returns a pool that cleans up like the server's and reports
+// finished tasks on the returned channel.
+func cancelPool(t *testing.T) (*Pool, storage.Storage, chan string) {
+	t.Helper()
+	t.Setenv("TMPDIR", t.TempDir())
+	st := store.NewRedisStore(nil)
+	stor, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	proc := &Processor{Store: st, Storage: stor}
+	done := make(chan string, 4)
+	p := &Pool{Queue: st, Store: st, Handler: proc.Process, Discard: proc.Discard, OnFinish: func(id string) { done <- id }}
+	return p, stor, done
+}
+
+func TestCancelQueued(t *testing.T) {
+	ctx := context.Background()
+	p, stor, done := cancelPool(t)
+	src := IncomingKey("t1", "a.mp4")
+	if err := stor.Put(ctx, src, strings.NewReader("x"), 1, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Submit(ctx, Job{ID: "t1", Type: "video_compress", Filename: "a.mp4", SrcKey: src}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(JobDir("t1"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Cancel(ctx, "t1"); err != nil {
+		t.Fatalf("Cancel: %v", err)
+	}
+	if tk, _ := p.Store.Get(ctx, "t1"); tk.Status != "cancelled" {
+		t.Errorf("status = %q, want cancelled", tk.Status)
+	}
+	if pos, _ := p.Queue.Position(ctx, "t1"); pos != 0 {
+		t.Errorf("still queued at %d", pos)
+	}
+	if _, err := os.Stat(JobDir("t1")); !os.IsNotExist(err) {
+		t.Errorf("job dir kept: %v", err)
+	}
+	if objs, _ := stor.List(ctx, "incoming/t1/"); len(objs) != 0 {
+		t.Errorf("source kept: %v", objs)
+	}
+	if id := <-done; id != "t1" {
+		t.Errorf("OnFinish(%q), want t1", id)
+	}
+	if err := p.Cancel(ctx, "t1"); !errors.Is(err, ErrFinished) {
+		t.Errorf("second Cancel = %v, want ErrFinished", err)
+	}
+	if err := p.Cancel(ctx, "none"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Cancel of an unknown task = %v, want ErrNotFound", err)
+	}
+}
+
+func TestCancelRunning(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("needs sh")
+	}
+	// a downloader that starts a helper and hangs, like yt-dlp running ffmpeg
+	bin := filepath.Join(t.TempDir(), "yt-dlp")
+	pidFile := bin + ".pid"
+	script := `#!/bin/sh
+case "$*" in *--get-filename*) echo clip.mp4; exit 0;; esac
+sleep 60 &
+echo $! > "` + pidFile + `"
+wait
+`
+	if err := os.WriteFile(bin, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("YT_DLP", bin)
+	p, _, done := cancelPool(t)
+	ctx, stop := context.WithCancel(context.Background())
+	defer func() {
+		stop()
+		p.Wait()
+	}()
+	p.Start(ctx)
+	if err := p.Submit(ctx, Job{ID: "t2", Type: "video_compress", URL: "https://videos.example/v"}); err != nil {
+		t.Fatal(err)
+	}
+	var pid int
+	for deadline := time.Now().Add(5 * time.Second); pid == 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("downloader did not start")
+		}
+		b, _ := os.ReadFile(pidFile)
+		pid, _ = strconv.Atoi(strings.TrimSpace(string(b)))
+	}
+	if _, err := os.Stat(JobDir("t2")); err != nil {
+		t.Fatalf("job dir missing while running: %v", err)
+	}
+
+	if err := p.Cancel(context.Background(), "t2"); err != nil {
+		t.Fatalf("Cancel: %v", err)
+	}
+	select {
+	case id := <-done:
+		if id != "t2" {
+			t.Errorf("OnFinish(%q), want t2", id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("job did not stop")
+	}
+	if tk, _ := p.Store.Get(context.Background(), "t2"); tk.Status != "cancelled" {
+		t.Errorf("status = %q, want cancelled", tk.Status)
+	}
+	if _, err := os.Stat(JobDir("t2")); !os.IsNotExist(err) {
+		t.Errorf("job dir kept: %v", err)
+	}
+	// the helper is in the downloader's process group and dies with it
+	if alive(pid) {
+		t.Errorf("helper %d survived the cancel", pid)
+	}
+}
+
+// alive reports whether process pid exists and is not a zombie.
+func alive(pid int) bool {
+	b, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return false
+	}
+	// the state follows the parenthesised command name
+	_, rest, _ := strings.Cut(string(b), ") ")
+	return !strings.HasPrefix(rest, "Z")
+}
@@ -0,0 +1,328 @@
+package jobs
+
+import (
+	"context"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	cfgpkg "comp/internal/config"
+	"comp/internal/media/ffmpeg"
+	"comp/internal/media/yt"
+	"comp/internal/storage"
+	"comp/internal/store"
+)
+
+// UsageRecorder accounts processed bytes and media minutes to a client.
+type UsageRecorder interface {
+	AddUsage(ctx context.Context, client string, bytes int64, minutes float64)
+}
+
+// Processor downloads/transcodes a job and puts the result into Storage.
+type Processor struct {
+	Cfg     cfgpkg.Config
+	Store   store.Store
+	Storage storage.Storage
+	Logger  *zap.SugaredLogger
+	Usage   UsageRecorder
+	// Submit queues the items of a batch (Pool.Submit).
+	Submit func(ctx context.Context, j Job) error
+}
+
+// IncomingKey is where an uploaded source waits for a worker; it survives
+// restarts and is removed once the job finishes or is discarded.
+func IncomingKey(taskID, filename string) string {
+	return "incoming/" + taskID + "/" + filename
+}
+
+// outputKey returns the storage key of task taskID's output named outName:
+// outputs/<task>/<name>. Items of a batch live under batches/<parent>/,
+// which is kept until the whole batch can be downloaded. Outputs are only
+// served through signed links, so the key need not be secret.
+func outputKey(taskID, parent, outName string) string {
+	prefix := "outputs/"
+	if parent != "" {
+		prefix = "batches/" + parent + "/"
+	}
+	return prefix + taskID + "/" + path.Base(outName)
+}
+
+// JobDir is the scratch directory a task works in.
+func JobDir(taskID string) string {
+	return filepath.Join(os.TempDir(), "app", taskID)
+}
+
+// Discard removes the job's scratch dir and any source still waiting in incoming.
+func (p *Processor) Discard(taskID string) {
+	_ = os.RemoveAll(JobDir(taskID))
+	p.dropIncoming(taskID)
+}
+
+func (p *Processor) dropIncoming(taskID string) {
+	ctx := context.Background()
+	objs, err := p.Storage.List(ctx, "incoming/"+taskID+"/")
+	if err != nil && p.Logger != nil {
+		p.Logger.Warnf("[%s] list incoming: %v", taskID, err)
+	}
+	for _, o := range objs {
+		_ = p.Storage.Delete(ctx, o.Key)
+	}
+}
+
+// fetch copies a stored source into dir and returns the local path.
+func (p *Processor) fetch(ctx context.Context, key, dir string) (string, error) {
+	src, _, err := p.Storage.Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	dst := filepath.Join(dir, path.Base(key))
+	out, err := os.Create(dst)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(out, src); err != nil {
+		out.Close()
+		return "", err
+	}
+	return dst, out.Close()
+}
+
+// store uploads a finished output and returns its key.
+func (p *Processor) store(ctx context.Context, taskID, local, outName, parent string) (string, error) {
+	f, err := os.Open(local)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	key := outputKey(taskID, parent, outName)
+	ctype := mime.TypeByExtension(strings.ToLower(filepath.Ext(outName)))
+	if err := p.Storage.Put(ctx, key, f, fi.Size(), ctype); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+func (p *Processor) Process(ctx context.Context, j Job) {
+	taskID := j.ID
+	runner := ffmpeg.Runner{Store: p.Store, Logger: p.Logger}
+	jobDir := JobDir(taskID)
+	_ = os.MkdirAll(jobDir, 0o755)
+	defer func() {
+		// an interrupted job may be requeued and needs its source again
+		if !interrupted(ctx) {
+			p.dropIncoming(taskID)
+		}
+	}()
+	defer func() {
+		if ctx.Err() == nil {
+			return
+		}
+		if interrupted(ctx) {
+			// the pool requeues or marks the task
+			_ = os.RemoveAll(jobDir)
+			return
+		}
+		// late progress writes may have landed after Cancel; restore the final state
+		p.Discard(taskID)
+		_ = p.Store.Set(context.Background(), &store.TaskStatus{ID: taskID, Status: "cancelled"}, 30*time.Minute)
+	}()
+	if j.Type == "batch" {
+		p.expand(ctx, j)
+		return
+	}
+	_ = p.Store.Set(ctx, &store.TaskStatus{ID: taskID, Status: "processing", Stage: "init", Percent: 0}, 30*time.Minute)
+	curPath := j.SrcPath
+	curName := j.Filename
+	clip := j.Clip()
+	// Download if URL provided
+	if j.URL != "" {
+		section := ""
+		if !clip.IsZero() {
+			// only the clip is downloaded, already cut, so nothing is left to trim
+			section = clip.Section()
+			clip = ffmpeg.Clip{}
+		}
+		f, err := yt.DownloadWithProgress(ctx, p.Store, p.Logger, taskID, yt.Download{
+			URL:      j.URL,
+			Proxy:    p.Cfg.Proxy,
+			Dir:      jobDir,
+			Format:   j.Format,
+			Section:  section,
+			Metadata: j.Type == "video_to_audio" && j.Metadata,
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			_ = p.Store.Set(ctx, &store.TaskStatus{ID: taskID, Status: "failed", Error: "download failed: " + err.Error()}, 30*time.Minute)
+			return
+		}
+		curPath = f
+		curName = filepath.Base(f)
+	}
+	// Uploaded file: copy from storage into the tmpfs job dir
+	if j.URL == "" && j.SrcKey != "" {
+		f, err := p.fetch(ctx, j.SrcKey, jobDir)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			_ = p.Store.Set(ctx, &store.TaskStatus{ID: taskID, Status: "failed", Error: "source file is missing"}, 30*time.Minute)
+			return
+		}
+		curPath = f
+		curName = filepath.Base(f)
+	} else if j.URL == "" && curPath != "" {
+		dst := filepath.Join(jobDir, filepath.Base(curPath))
+		_ = moveFile(curPath, dst)
+		curPath = dst
+		curName = filepath.Base(dst)
+	}
+
+	// a clip left to cut is charged for its length only
+	p.recordUsage(ctx, j.Client, curPath, clip)
+	if fi, err := os.Stat(curPath); err == nil {
+		_ = p.Store.Set(ctx, &store.TaskStatus{ID: taskID, InputSize: fi.Size()}, 30*time.Minute)
+	}
+
+	ext := filepath.Ext(curName)
+	outName := "out_" + curName
+	outPath := filepath.Join(jobDir, outName)
+	var errProc error
+	switch j.Type {
+	case "video_compress":
+		opts := j.CompressOptions()
+		opts.Clip = clip
+		// jobs queued before codec selection existed carry no options
+		opts.Normalize(ext)
+		outName = "compressed_" + strings.TrimSuffix(curName, ext) + "." + opts.Container
+		outPath = filepath.Join(jobDir, outName)
+		_ = p.Store.Set(ctx, &store.TaskStatus{ID: taskID, Status: "processing", Stage: "transcode", Percent: 0}, 30*time.Minute)
+		errProc = runner.Compress(ctx, taskID, curPath, outPath, opts)
+	case "video_target_size":
+		outName = "compressed_" + strings.TrimSuffix(curName, ext) + ".mp4"
+		outPath = filepath.Join(jobDir, outName)
+		_ = p.Store.Set(ctx, &store.TaskStatus{ID: taskID, Status: "processing", Stage: "transcode", Percent: 0}, 30*time.Minute)
+		errProc = runner.TargetSize(ctx, taskID, curPath, outPath, j.TargetSizeMB, j.Width, j.FPS)
+	case "video_to_gif":
+		opts := j.GIFOptions()
+		opts.Clip = clip
+		// jobs queued before these options existed carry none
+		opts.Normalize()
+		outName = strings.TrimSuffix(curName, ext) + opts.Ext()
+		outPath = filepath.Join(jobDir, outName)
+		_ = p.Store.Set(ctx, &store.TaskStatus{ID: taskID, Status: "processing", Stage: "transcode", Percent: 0}, 30*time.Minute)
+		errProc = runner.GIF(ctx, taskID, curPath, outPath, opts)
+	case "video_to_audio":
+		opts := j.AudioOptions()
+		opts.Clip = clip
+		// jobs queued before these options existed carry none
+		opts.Normalize()
+		if j.URL != "" && j.Metadata {
+			if m, err := yt.ReadMetadata(curPath); err == nil {
+				opts.Meta = ffmpeg.AudioMeta{Title: m.Title, Artist: m.Artist, Album: m.Album, Date: m.Date, Cover: m.Thumbnail}
+			} else if p.Logger != nil {
+				p.Logger.Warnf("[%s] no metadata from yt-dlp: %v", taskID, err)
+			}
+		}
+		outName = strings.TrimSuffix(curName, ext) + opts.Ext()
+		if strings.EqualFold(ext, opts.Ext()) {
+			// audio-only downloads can already have the target extension
+			outName = strings.TrimSuffix(curName, ext) + "_audio" + opts.Ext()
+		}
+		outPath = filepath.Join(jobDir, outName)
+		_ = p.Store.Set(ctx, &store.TaskStatus{ID: taskID, Status: "processing", Stage: "transcode", Percent: 0}, 30*time.Minute)
+		errProc = runner.Audio(ctx, taskID, curPath, outPath, opts)
+	case "image_compress":
+		// choose target image format if provided
+		targetExt := ""
+		switch j.ImgFormat {
+		case "jpg", "jpeg":
+			targetExt = ".jpg"
+		case "png":
+			targetExt = ".png"
+		}
+		if targetExt == "" {
+			// keep original extension
+			outName = "compressed_" + curName
+		} else {
+			outName = "compressed_" + strings.TrimSuffix(curName, ext) + targetExt
+		}
+		outPath = filepath.Join(jobDir, outName)
+		_ = p.Store.Set(ctx, &store.TaskStatus{ID: taskID, Status: "processing", Stage: "transcode", Percent: 0}, 30*time.Minute)
+		errProc = runner.Image(ctx, taskID, curPath, outPath, j.Quality, j.Width)
+	}
+	if errProc != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		_ = p.Store.Set(ctx, &store.TaskStatus{ID: taskID, Status: "failed", Error: "processing failed: " + errProc.Error()}, 30*time.Minute)
+		return
+	}
+	var outSize int64
+	if fi, err := os.Stat(outPath); err == nil {
+		outSize = fi.Size()
+	}
+	// Store the result; it is only reachable via a signed link
+	stored, err := p.store(ctx, taskID, outPath, outName, j.Parent)
+	if err != nil {
+		if p.Logger != nil {
+			p.Logger.Errorf("[%s] store output: %v", taskID, err)
+		}
+		_ = p.Store.Set(ctx, &store.TaskStatus{ID: taskID, Status: "failed", Error: "failed to store output"}, 30*time.Minute)
+		return
+	}
+	_ = p.Store.Set(ctx, &store.TaskStatus{ID: taskID, Status: "completed", OutputFile: outName, StoredFile: stored, OutputSize: outSize, Stage: "finalize", Percent: 100}, 30*time.Minute)
+	_ = os.RemoveAll(jobDir)
+}
+
+func (p *Processor) recordUsage(ctx context.Context, client, path string, clip ffmpeg.Clip) {
+	if p.Usage == nil || client == "" {
+		return
+	}
+	var size int64
+	if fi, err := os.Stat(path); err == nil {
+		size = fi.Size()
+	}
+	var minutes float64
+	if info, err := ffmpeg.Probe(ctx, path); err == nil {
+		minutes = clip.Length(info.Duration) / 60
+	}
+	p.Usage.AddUsage(ctx, client, size, minutes)
+}
+
+// moveFile renames src to dst, falling back to copy+remove when they live on
+// different filesystems (uploads volume vs tmpfs job dir).
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
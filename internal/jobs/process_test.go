@@ -0,0 +1,73 @@
+package jobs
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"comp/internal/media/ffmpeg"
+)
+
+func TestOutputKey(t *testing.T) {
+	tests := []struct {
+		id, parent, name, want string
+	}{
+		{"t1", "", "clip.mp4", "outputs/t1/clip.mp4"},
+		{"t2", "b1", "song.mp3", "batches/b1/t2/song.mp3"},
+		// a name never adds directories
+		{"t3", "", "../x/clip.mp4", "outputs/t3/clip.mp4"},
+	}
+	for _, tt := range tests {
+		if got := outputKey(tt.id, tt.parent, tt.name); got != tt.want {
+			t.Errorf("outputKey(%q, %q, %q) = %q, want %q", tt.id, tt.parent, tt.name, got, tt.want)
+		}
+	}
+}
+
+type usage struct {
+	bytes   int64
+	minutes float64
+}
+
+func (u *usage) AddUsage(_ context.Context, _ string, bytes int64, minutes float64) {
+	u.bytes += bytes
+	u.minutes += minutes
+}
+
+func TestRecordUsageChargesClip(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("needs sh")
+	}
+	// every input lasts ten minutes
+	bin := t.TempDir()
+	script := "#!/bin/sh\necho '{\"format\":{\"duration\":\"600\"},\"streams\":[]}'\n"
+	if err := os.WriteFile(filepath.Join(bin, "ffprobe"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	src := filepath.Join(t.TempDir(), "a.mp4")
+	if err := os.WriteFile(src, []byte("0123456789"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		clip    ffmpeg.Clip
+		minutes float64
+	}{
+		{"whole input", ffmpeg.Clip{}, 10},
+		{"start and end", ffmpeg.Clip{Start: 60, End: 180}, 2},
+		{"start only", ffmpeg.Clip{Start: 540}, 1},
+		{"end past the input", ffmpeg.Clip{End: 900}, 10},
+	}
+	for _, tt := range tests {
+		u := &usage{}
+		p := &Processor{Usage: u}
+		p.recordUsage(context.Background(), "alice", src, tt.clip)
+		if u.minutes != tt.minutes || u.bytes != 10 {
+			t.Errorf("%s: charged %v min, %d bytes; want %v min, 10 bytes", tt.name, u.minutes, u.bytes, tt.minutes)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"comp/internal/store"
+)
+
+// ErrInterrupted is the cancel cause of jobs stopped by Shutdown.
+var ErrInterrupted = errors.New("interrupted by server shutdown")
+
+// A running job holds a lease in Redis; a processing task without one was
+// left behind by an instance that died. Workers hold one too, for the jobs
+// they have claimed from the queue.
+const (
+	leaseTTL     = 30 * time.Second
+	leaseRefresh = 10 * time.Second
+)
+
+func leaseKey(id string) string { return "task:" + id + ":lease" }
+
+func workerKey(name string) string { return "worker:" + name + ":lease" }
+
+func interrupted(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), ErrInterrupted)
+}
+
+// Shutdown stops taking jobs from the queue and rejects new submissions,
+// then waits for running jobs until ctx is done. Jobs still running at that
+// point are interrupted: requeued when Requeue is set and possible,
+// otherwise marked interrupted. It returns ctx.Err() if it had to interrupt.
+func (p *Pool) Shutdown(ctx context.Context) error {
+	p.draining.Store(true)
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+	p.mu.Lock()
+	for id, cancel := range p.running {
+		if p.Logger != nil {
+			p.Logger.Warnf("task %s interrupted by shutdown", id)
+		}
+		cancel(ErrInterrupted)
+	}
+	p.mu.Unlock()
+	// the processes are killed, so the workers return promptly
+	<-done
+	return ctx.Err()
+}
+
+// requeueable reports whether j can start over on another worker.
+func requeueable(j Job) bool {
+	return j.URL != "" || j.SrcKey != ""
+}
+
+// interrupt settles a job stopped by Shutdown.
+func (p *Pool) interrupt(worker string, j Job) {
+	ctx := context.Background()
+	if p.Requeue && requeueable(j) {
+		j.QueuedAt = time.Now()
+		b, err := json.Marshal(j)
+		if err == nil {
+			_ = p.Store.Set(ctx, &store.TaskStatus{ID: j.ID, Status: "queued", Stage: "queued"}, 0)
+			if err = p.Queue.Requeue(ctx, worker, j.ID, b); err == nil {
+				if p.Logger != nil {
+					p.Logger.Infof("task %s requeued", j.ID)
+				}
+				return
+			}
+		}
+		if p.Logger != nil {
+			p.Logger.Warnf("requeue %s failed: %v", j.ID, err)
+		}
+	}
+	p.markInterrupted(ctx, j.ID, ErrInterrupted.Error())
+	p.ack(worker, j.ID)
+}
+
+func (p *Pool) markInterrupted(ctx context.Context, id, reason string) {
+	_ = p.Store.Set(ctx, &store.TaskStatus{ID: id, Status: "interrupted", Error: reason}, 30*time.Minute)
+	if p.Discard != nil {
+		p.Discard(id)
+	}
+	p.finished(id)
+}
+
+// lease holds key (a task or worker lease) for this instance until the
+// returned func is called. Without Redis there is only one instance and no
+// lease.
+func (p *Pool) lease(ctx context.Context, key string) func() {
+	if p.Redis == nil {
+		return func() {}
+	}
+	bg := context.WithoutCancel(ctx)
+	_ = p.Redis.Set(bg, key, "1", leaseTTL).Err()
+	stop := make(chan struct{})
+	go func() {
+		t := time.NewTicker(leaseRefresh)
+		defer t.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-t.C:
+				_ = p.Redis.Expire(bg, key, leaseTTL).Err()
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		_ = p.Redis.Del(bg, key).Err()
+	}
+}
+
+// RecoverOrphans settles jobs that dead workers had claimed from the queue
+// and marks processing tasks that no instance is running any more (left
+// behind by a crash or kill) as interrupted. Claimed jobs that had not
+// started yet go back to the queue, and so do started ones when Requeue is
+// set and possible. Call it before Start. It returns how many tasks it
+// marked.
+func (p *Pool) RecoverOrphans(ctx context.Context) (int, error) {
+	if err := p.recoverClaims(ctx); err != nil {
+		return 0, err
+	}
+	tasks, _, err := p.Store.List(ctx, store.TaskQuery{Status: "processing"})
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, t := range tasks {
+		// a batch waiting for its items runs nowhere; they settle it
+		if t.Type == "batch" && t.Stage == "items" {
+			continue
+		}
+		if p.Redis != nil {
+			alive, err := p.Redis.Exists(ctx, leaseKey(t.ID)).Result()
+			if err != nil {
+				return n, err
+			}
+			if alive > 0 {
+				continue
+			}
+		}
+		if p.Logger != nil {
+			p.Logger.Warnf("task %s was left processing; marking interrupted", t.ID)
+		}
+		p.markInterrupted(ctx, t.ID, "interrupted: the server stopped while processing")
+		n++
+	}
+	return n, nil
+}
+
+func (p *Pool) recoverClaims(ctx context.Context) error {
+	claims, err := p.Queue.Claims(ctx)
+	if err != nil {
+		return err
+	}
+	for _, c := range claims {
+		if p.Redis != nil {
+			alive, err := p.Redis.Exists(ctx, workerKey(c.Worker)).Result()
+			if err != nil {
+				return err
+			}
+			if alive > 0 {
+				continue
+			}
+		}
+		var j Job
+		t, ok := p.Store.Get(ctx, c.ID)
+		if ok && json.Unmarshal(c.Payload, &j) == nil &&
+			(t.Status == "queued" || (t.Status == "processing" && p.Requeue && requeueable(j))) {
+			_ = p.Store.Set(ctx, &store.TaskStatus{ID: c.ID, Status: "queued", Stage: "queued"}, 0)
+			if err := p.Queue.Requeue(ctx, c.Worker, c.ID, c.Payload); err != nil {
+				return err
+			}
+			if p.Logger != nil {
+				p.Logger.Warnf("task %s was claimed by dead worker %s; requeued", c.ID, c.Worker)
+			}
+			continue
+		}
+		// finished, or left for the processing scan to mark interrupted
+		if err := p.Queue.Ack(ctx, c.Worker, c.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemoveOrphanDirs deletes job scratch directories left by a previous run.
+// Instances on one host share the scratch root, so dirs of tasks that still
+// hold a lease belong to a live job elsewhere and are kept. Call it before
+// the pool starts, while no job of this instance can own one.
+func (p *Pool) RemoveOrphanDirs(ctx context.Context) (int, error) {
+	root := filepath.Dir(JobDir("x"))
+	entries, err := os.ReadDir(root)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, e := range entries {
+		if p.Redis != nil {
+			live, err := p.Redis.Exists(ctx, leaseKey(e.Name())).Result()
+			if err != nil {
+				return n, err
+			}
+			if live > 0 {
+				continue
+			}
+		}
+		if err := os.RemoveAll(filepath.Join(root, e.Name())); err == nil {
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,90 @@
+package limits
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+// counters are expiring float counters keyed by string.
+type counters interface {
+	incr(ctx context.Context, key string, by float64, ttl time.Duration) (float64, error)
+	get(ctx context.Context, key string) (float64, error)
+}
+
+type redisCounters struct {
+	rdb *redis.Client
+}
+
+func (r *redisCounters) incr(ctx context.Context, key string, by float64, ttl time.Duration) (float64, error) {
+	var v *redis.FloatCmd
+	_, err := r.rdb.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		v = p.IncrByFloat(ctx, key, by)
+		p.ExpireNX(ctx, key, ttl)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return v.Val(), nil
+}
+
+func (r *redisCounters) get(ctx context.Context, key string) (float64, error) {
+	v, err := r.rdb.Get(ctx, key).Float64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return v, err
+}
+
+type memCounter struct {
+	v       float64
+	expires time.Time
+}
+
+type memCounters struct {
+	mu     sync.Mutex
+	m      map[string]memCounter
+	pruned time.Time
+}
+
+func newMemCounters() *memCounters { return &memCounters{m: make(map[string]memCounter)} }
+
+func (c *memCounters) incr(_ context.Context, key string, by float64, ttl time.Duration) (float64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	c.prune(now)
+	e, ok := c.m[key]
+	if !ok || now.After(e.expires) {
+		e = memCounter{expires: now.Add(ttl)}
+	}
+	e.v += by
+	c.m[key] = e
+	return e.v, nil
+}
+
+func (c *memCounters) get(_ context.Context, key string) (float64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.m[key]
+	if !ok || time.Now().After(e.expires) {
+		return 0, nil
+	}
+	return e.v, nil
+}
+
+// prune drops expired entries at most once a minute.
+func (c *memCounters) prune(now time.Time) {
+	if now.Sub(c.pruned) < time.Minute {
+		return
+	}
+	c.pruned = now
+	for k, e := range c.m {
+		if now.After(e.expires) {
+			delete(c.m, k)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+package limits
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	redis "github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+
+	"comp/internal/auth"
+	"comp/internal/store"
+)
+
+// LimitError is returned when a client is over a limit; RetryAfter says when
+// trying again makes sense.
+type LimitError struct {
+	Reason     string
+	RetryAfter time.Duration
+}
+
+func (e *LimitError) Error() string { return e.Reason }
+
+// RetryAfterSeconds is RetryAfter rounded up for a Retry-After header.
+func (e *LimitError) RetryAfterSeconds() int {
+	secs := int(math.Ceil(e.RetryAfter.Seconds()))
+	if secs < 1 {
+		secs = 1
+	}
+	return secs
+}
+
+// Limiter enforces per-client request rates, concurrent job caps and daily
+// usage quotas. Counters live in Redis when it is available, in memory otherwise.
+// Zero values disable the corresponding limit.
+type Limiter struct {
+	PerMinute    int
+	MaxActive    int
+	DailyBytes   int64
+	DailyMinutes float64
+	Store        store.Store
+	Logger       *zap.SugaredLogger
+	counters     counters
+	now          func() time.Time
+	activeMu     sync.Mutex
+	memActive    map[string]map[string]time.Time
+	rdb          *redis.Client
+}
+
+func New(perMinute, maxActive int, dailyBytes int64, dailyMinutes float64, st store.Store, rdb *redis.Client, logger *zap.SugaredLogger) *Limiter {
+	l := &Limiter{
+		PerMinute:    perMinute,
+		MaxActive:    maxActive,
+		DailyBytes:   dailyBytes,
+		DailyMinutes: dailyMinutes,
+		Store:        st,
+		Logger:       logger,
+		now:          time.Now,
+		memActive:    make(map[string]map[string]time.Time),
+		rdb:          rdb,
+	}
+	if rdb != nil {
+		l.counters = &redisCounters{rdb: rdb}
+	} else {
+		l.counters = newMemCounters()
+	}
+	return l
+}
+
+// Client identifies the caller: the API key owner if authenticated, the IP otherwise.
+func Client(c *gin.Context) string {
+	if owner := auth.Owner(c); owner != "" {
+		return "key:" + owner
+	}
+	return "ip:" + c.ClientIP()
+}
+
+// Abort writes a 429 for a LimitError, or a 500 for anything else.
+func Abort(c *gin.Context, err error) {
+	var le *LimitError
+	if errors.As(err, &le) {
+		c.Header("Retry-After", strconv.Itoa(le.RetryAfterSeconds()))
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": le.Reason})
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "limit check failed"})
+}
+
+// Middleware applies the per-minute request rate to the routes it wraps.
+func (l *Limiter) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := l.Allow(c.Request.Context(), Client(c)); err != nil {
+			Abort(c, err)
+			return
+		}
+		c.Next()
+	}
+}
+
+// Allow counts one request in the current fixed one-minute window.
+func (l *Limiter) Allow(ctx context.Context, client string) error {
+	if l.PerMinute <= 0 {
+		return nil
+	}
+	now := l.now()
+	window := now.Truncate(time.Minute)
+	key := fmt.Sprintf("limits:rate:%s:%d", client, window.Unix())
+	n, err := l.counters.incr(ctx, key, 1, 2*time.Minute)
+	if err != nil {
+		// fail open: a broken counter backend should not take the API down
+		l.warn("rate counter: %v", err)
+		return nil
+	}
+	if int(n) > l.PerMinute {
+		return &LimitError{Reason: "rate limit exceeded", RetryAfter: window.Add(time.Minute).Sub(now)}
+	}
+	return nil
+}
+
+// AdmitJob checks daily quotas and the concurrent job cap, and registers
+// taskID as active for client.
+func (l *Limiter) AdmitJob(ctx context.Context, client, taskID string) error {
+	if err := l.checkQuota(ctx, client); err != nil {
+		return err
+	}
+	if l.MaxActive <= 0 {
+		return nil
+	}
+	ok, err := l.takeSlot(ctx, client, taskID)
+	if err != nil {
+		l.warn("active jobs: %v", err)
+		return nil
+	}
+	if !ok {
+		return &LimitError{Reason: fmt.Sprintf("too many active jobs (max %d)", l.MaxActive), RetryAfter: 30 * time.Second}
+	}
+	return nil
+}
+
+// Release frees taskID's slot right away, e.g. when the request fails after AdmitJob.
+func (l *Limiter) Release(ctx context.Context, client, taskID string) {
+	l.removeActive(ctx, client, taskID)
+}
+
+func (l *Limiter) day() (string, time.Duration) {
+	now := l.now().UTC()
+	next := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+	return now.Format("20060102"), next.Sub(now)
+}
+
+func (l *Limiter) checkQuota(ctx context.Context, client string) error {
+	day, untilMidnight := l.day()
+	if l.DailyBytes > 0 {
+		used, err := l.counters.get(ctx, "limits:bytes:"+client+":"+day)
+		if err == nil && int64(used) >= l.DailyBytes {
+			return &LimitError{Reason: "daily bytes quota exceeded", RetryAfter: untilMidnight}
+		}
+	}
+	if l.DailyMinutes > 0 {
+		used, err := l.counters.get(ctx, "limits:minutes:"+client+":"+day)
+		if err == nil && used >= l.DailyMinutes {
+			return &LimitError{Reason: "daily transcode minutes quota exceeded", RetryAfter: untilMidnight}
+		}
+	}
+	return nil
+}
+
+// AddUsage records processed input bytes and transcoded media minutes.
+func (l *Limiter) AddUsage(ctx context.Context, client string, bytes int64, minutes float64) {
+	if client == "" {
+		return
+	}
+	day, _ := l.day()
+	if bytes > 0 {
+		if _, err := l.counters.incr(ctx, "limits:bytes:"+client+":"+day, float64(bytes), 48*time.Hour); err != nil {
+			l.warn("usage bytes: %v", err)
+		}
+	}
+	if minutes > 0 {
+		if _, err := l.counters.incr(ctx, "limits:minutes:"+client+":"+day, minutes, 48*time.Hour); err != nil {
+			l.warn("usage minutes: %v", err)
+		}
+	}
+}
+
+func (l *Limiter) warn(format string, args ...any) {
+	if l.Logger != nil {
+		l.Logger.Warnf(format, args...)
+	}
+}
+
+// activeKey is a sorted set of the client's active task IDs scored by
+// admission time (unix seconds).
+func activeKey(client string) string { return "limits:active:" + client }
+
+// admitGrace is how long an admitted task may go without a task record
+// (its upload is still being staged) before it no longer holds a slot.
+const admitGrace = time.Hour
+
+// takeSlot registers taskID as active for client if client has fewer than
+// MaxActive active tasks. Counting and adding are one atomic step, so
+// concurrent submits cannot all slip in under the cap.
+func (l *Limiter) takeSlot(ctx context.Context, client, taskID string) (bool, error) {
+	now := l.now()
+	if l.rdb != nil {
+		// tasks that finished (or expired) since the last call free their
+		// slot; pruning may race, it only ever frees slots
+		slots, err := l.rdb.ZRangeWithScores(ctx, activeKey(client), 0, -1).Result()
+		if err != nil {
+			return false, err
+		}
+		for _, z := range slots {
+			id, _ := z.Member.(string)
+			if !l.holdsSlot(ctx, id, time.Unix(int64(z.Score), 0), now) {
+				l.removeActive(ctx, client, id)
+			}
+		}
+		n, err := takeSlotScript.Run(ctx, l.rdb, []string{activeKey(client)},
+			l.MaxActive, taskID, now.Unix(), int((24 * time.Hour).Seconds())).Int()
+		return n == 1, err
+	}
+	l.activeMu.Lock()
+	defer l.activeMu.Unlock()
+	slots := l.memActive[client]
+	if slots == nil {
+		slots = make(map[string]time.Time)
+		l.memActive[client] = slots
+	}
+	for id, at := range slots {
+		if !l.holdsSlot(ctx, id, at, now) {
+			delete(slots, id)
+		}
+	}
+	if len(slots) >= l.MaxActive {
+		return false, nil
+	}
+	slots[taskID] = now
+	return true, nil
+}
+
+// takeSlotScript adds ARGV[2] with score ARGV[3] to the sorted set KEYS[1]
+// unless it already holds ARGV[1] members, and returns 1 if it did.
+var takeSlotScript = redis.NewScript(`
+if redis.call('ZCARD', KEYS[1]) >= tonumber(ARGV[1]) then
+	return 0
+end
+redis.call('ZADD', KEYS[1], ARGV[3], ARGV[2])
+redis.call('EXPIRE', KEYS[1], ARGV[4])
+return 1
+`)
+
+// holdsSlot reports whether task id, admitted at admitted, still counts
+// against its client's cap.
+func (l *Limiter) holdsSlot(ctx context.Context, id string, admitted, now time.Time) bool {
+	t, ok := l.Store.Get(ctx, id)
+	if !ok {
+		return now.Sub(admitted) < admitGrace
+	}
+	return !store.Finished(t.Status)
+}
+
+func (l *Limiter) removeActive(ctx context.Context, client, id string) {
+	if l.rdb != nil {
+		_ = l.rdb.ZRem(ctx, activeKey(client), id).Err()
+		return
+	}
+	l.activeMu.Lock()
+	delete(l.memActive[client], id)
+	if len(l.memActive[client]) == 0 {
+		delete(l.memActive, client)
+	}
+	l.activeMu.Unlock()
+}
@@ -0,0 +1,121 @@
+package limits
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	redis "github.com/redis/go-redis/v9"
+
+	"comp/internal/store"
+)
+
+// limiters returns a memory-backed and a Redis-backed limiter with the
+// given active job cap.
+func limiters(t *testing.T, maxActive int) map[string]*Limiter {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	return map[string]*Limiter{
+		"memory": New(0, maxActive, 0, 0, store.NewRedisStore(nil), nil, nil),
+		"redis":  New(0, maxActive, 0, 0, store.NewRedisStore(rdb), rdb, nil),
+	}
+}
+
+func TestAdmitJobConcurrentCap(t *testing.T) {
+	ctx := context.Background()
+	for name, l := range limiters(t, 3) {
+		t.Run(name, func(t *testing.T) {
+			var admitted atomic.Int32
+			var wg sync.WaitGroup
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					err := l.AdmitJob(ctx, "ip:1", fmt.Sprintf("t%d", i))
+					var le *LimitError
+					switch {
+					case err == nil:
+						admitted.Add(1)
+					case !errors.As(err, &le):
+						t.Errorf("AdmitJob: %v", err)
+					}
+				}()
+			}
+			wg.Wait()
+			if n := admitted.Load(); n != 3 {
+				t.Fatalf("admitted %d of 20 concurrent jobs, want 3", n)
+			}
+		})
+	}
+}
+
+func TestAdmitJobFreesFinishedSlots(t *testing.T) {
+	ctx := context.Background()
+	for name, l := range limiters(t, 1) {
+		t.Run(name, func(t *testing.T) {
+			if err := l.AdmitJob(ctx, "ip:1", "a"); err != nil {
+				t.Fatal(err)
+			}
+			_ = l.Store.Set(ctx, &store.TaskStatus{ID: "a", Status: "processing"}, 0)
+			if err := l.AdmitJob(ctx, "ip:1", "b"); err == nil {
+				t.Fatal("second job admitted while the first is processing")
+			}
+			if err := l.AdmitJob(ctx, "ip:2", "c"); err != nil {
+				t.Fatalf("other client: %v", err)
+			}
+			_ = l.Store.Set(ctx, &store.TaskStatus{ID: "a", Status: "completed"}, 0)
+			if err := l.AdmitJob(ctx, "ip:1", "b"); err != nil {
+				t.Fatalf("after the first finished: %v", err)
+			}
+		})
+	}
+}
+
+func TestAdmitJobUnsubmittedSlotExpires(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	l := New(0, 1, 0, 0, store.NewRedisStore(nil), nil, nil)
+	l.now = func() time.Time { return now }
+	if err := l.AdmitJob(ctx, "ip:1", "a"); err != nil {
+		t.Fatal(err)
+	}
+	// admitted but not submitted yet: still holds its slot
+	if err := l.AdmitJob(ctx, "ip:1", "b"); err == nil {
+		t.Fatal("admitted past a job still being staged")
+	}
+	now = now.Add(admitGrace)
+	if err := l.AdmitJob(ctx, "ip:1", "b"); err != nil {
+		t.Fatalf("after the grace period: %v", err)
+	}
+}
+
+func TestAllowPerMinute(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 12, 0, 30, 0, time.UTC)
+	l := New(2, 0, 0, 0, store.NewRedisStore(nil), nil, nil)
+	l.now = func() time.Time { return now }
+	for i := 0; i < 2; i++ {
+		if err := l.Allow(ctx, "ip:1"); err != nil {
+			t.Fatalf("request %d: %v", i+1, err)
+		}
+	}
+	err := l.Allow(ctx, "ip:1")
+	var le *LimitError
+	if !errors.As(err, &le) {
+		t.Fatalf("third request: %v, want a LimitError", err)
+	}
+	if le.RetryAfterSeconds() != 30 {
+		t.Errorf("RetryAfterSeconds = %d, want 30", le.RetryAfterSeconds())
+	}
+	now = now.Add(30 * time.Second)
+	if err := l.Allow(ctx, "ip:1"); err != nil {
+		t.Fatalf("next window: %v", err)
+	}
+}
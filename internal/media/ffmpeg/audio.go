@@ -0,0 +1,409 @@
+package ffmpeg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"comp/internal/execx"
+)
+
+// Choices for video_to_audio, in UI order.
+var (
+	AudioFormats = []string{"mp3", "m4a", "opus", "flac", "wav"}
+	SampleRates  = []int{8000, 16000, 22050, 24000, 32000, 44100, 48000, 96000}
+)
+
+// opusRates are the sample rates libopus accepts.
+var opusRates = []int{8000, 12000, 16000, 24000, 48000}
+
+// audioFormats maps each output format to its encoder and extension.
+var audioFormats = map[string]struct {
+	codec, ext string
+	lossless   bool
+}{
+	"mp3":  {"libmp3lame", ".mp3", false},
+	"m4a":  {"aac", ".m4a", false},
+	"opus": {"libopus", ".opus", false},
+	"flac": {"flac", ".flac", true},
+	"wav":  {"pcm_s16le", ".wav", true},
+}
+
+// lameVBR holds the average kbps of LAME's -V0 to -V9; VBR mp3 uses the
+// level closest to the requested bitrate.
+var lameVBR = []int{245, 225, 190, 175, 165, 130, 115, 100, 85, 65}
+
+// Loudness targets besides the integrated level, which is configurable.
+const (
+	loudnormTP  = -1.5
+	loudnormLRA = 11
+	// silence is anything below silenceDB for at least silenceSeconds
+	silenceDB      = -50
+	silenceSeconds = 0.5
+)
+
+// AudioMeta is written into the output's ID3/MP4/Vorbis tags; Cover is a
+// local JPEG or PNG. Empty fields are left alone.
+type AudioMeta struct {
+	Title, Artist, Album, Date string
+	Cover                      string
+}
+
+// AudioOptions configures Runner.Audio. Empty fields get defaults from
+// Normalize; Bitrate and BitrateMode do not apply to flac and wav.
+type AudioOptions struct {
+	Format string
+	// Bitrate like "128k"; with BitrateMode "vbr" it is the average aimed for.
+	Bitrate     string
+	BitrateMode string
+	// SampleRate and Channels of 0 keep the source's.
+	SampleRate int
+	Channels   int
+	// Loudnorm normalizes to LUFS integrated loudness (EBU R128), measured
+	// in a first pass.
+	Loudnorm bool
+	LUFS     float64
+	// TrimSilence cuts silence at the start and end.
+	TrimSilence bool
+	Meta        AudioMeta
+	Clip        Clip
+}
+
+// Normalize fills defaults.
+func (o *AudioOptions) Normalize() {
+	o.Format = strings.ToLower(strings.TrimSpace(o.Format))
+	o.Bitrate = strings.ToLower(strings.TrimSpace(o.Bitrate))
+	o.BitrateMode = strings.ToLower(strings.TrimSpace(o.BitrateMode))
+	if o.Format == "" {
+		o.Format = "mp3"
+	}
+	if f, ok := audioFormats[o.Format]; ok && f.lossless {
+		o.Bitrate, o.BitrateMode = "", ""
+	} else {
+		if o.Bitrate == "" {
+			o.Bitrate = "128k"
+			if o.Format == "opus" {
+				o.Bitrate = "96k"
+			}
+		}
+		if o.BitrateMode == "" {
+			o.BitrateMode = "cbr"
+		}
+	}
+	if o.Loudnorm && o.LUFS == 0 {
+		o.LUFS = -16
+	}
+}
+
+// Validate checks the options and that the local ffmpeg has the encoder.
+func (o *AudioOptions) Validate(ctx context.Context) error {
+	f, ok := audioFormats[o.Format]
+	if !ok {
+		return fmt.Errorf("unsupported audio format %q", o.Format)
+	}
+	if !f.lossless {
+		m := bitrateRe.FindStringSubmatch(o.Bitrate)
+		if m == nil {
+			return fmt.Errorf("invalid audio bitrate %q", o.Bitrate)
+		}
+		max := 512
+		if o.Format == "mp3" {
+			max = 320
+		}
+		if kbps, _ := strconv.Atoi(m[1]); kbps < 16 || kbps > max {
+			return fmt.Errorf("audio bitrate %q out of range", o.Bitrate)
+		}
+		if o.BitrateMode != "cbr" && o.BitrateMode != "vbr" {
+			return fmt.Errorf("bitrate_mode must be cbr or vbr")
+		}
+	}
+	if o.SampleRate != 0 && !slices.Contains(SampleRates, o.SampleRate) {
+		return fmt.Errorf("unsupported sample rate %d", o.SampleRate)
+	}
+	if o.Format == "mp3" && o.SampleRate > 48000 {
+		return fmt.Errorf("mp3 supports up to 48000 Hz")
+	}
+	if o.Format == "opus" && o.SampleRate != 0 && !slices.Contains(opusRates, o.SampleRate) {
+		return fmt.Errorf("opus supports 8000, 16000, 24000 or 48000 Hz")
+	}
+	if o.Channels < 0 || o.Channels > 2 {
+		return fmt.Errorf("channels must be 1 or 2")
+	}
+	if o.Loudnorm && (o.LUFS < -70 || o.LUFS > -5) {
+		return fmt.Errorf("lufs must be between -70 and -5")
+	}
+	enc, err := Encoders(ctx)
+	if err != nil {
+		return err
+	}
+	if !enc[f.codec] {
+		return fmt.Errorf("encoder %s is not available in this ffmpeg build", f.codec)
+	}
+	return nil
+}
+
+// Ext returns the output file extension.
+func (o *AudioOptions) Ext() string {
+	return audioFormats[o.Format].ext
+}
+
+// codecArgs returns the encoder flags.
+func (o *AudioOptions) codecArgs() []string {
+	f := audioFormats[o.Format]
+	args := []string{"-c:a", f.codec}
+	if f.lossless {
+		return args
+	}
+	kbps, _ := strconv.Atoi(strings.TrimSuffix(o.Bitrate, "k"))
+	switch {
+	case o.Format == "mp3" && o.BitrateMode == "vbr":
+		level := 0
+		for i, avg := range lameVBR {
+			if abs(avg-kbps) < abs(lameVBR[level]-kbps) {
+				level = i
+			}
+		}
+		return append(args, "-q:a", strconv.Itoa(level))
+	case o.Format == "opus":
+		vbr := "off"
+		if o.BitrateMode == "vbr" {
+			vbr = "on"
+		}
+		return append(args, "-b:a", o.Bitrate, "-vbr", vbr)
+	}
+	// the native AAC encoder has no real VBR; it is treated as ABR
+	return append(args, "-b:a", o.Bitrate)
+}
+
+// metaArgs maps input 1, if any, as cover art and sets the tags.
+func (o *AudioOptions) metaArgs(hasCover bool) []string {
+	var args []string
+	if hasCover {
+		args = append(args, "-map", "1:v:0", "-c:v", "copy", "-disposition:v", "attached_pic")
+		if o.Format == "mp3" {
+			args = append(args, "-metadata:s:v", "title=Album cover", "-metadata:s:v", "comment=Cover (front)")
+		}
+	}
+	if o.Format == "mp3" {
+		// v2.3 is what most players read
+		args = append(args, "-id3v2_version", "3")
+	}
+	for _, kv := range [][2]string{{"title", o.Meta.Title}, {"artist", o.Meta.Artist}, {"album", o.Meta.Album}, {"date", o.Meta.Date}} {
+		if kv[1] != "" {
+			args = append(args, "-metadata", kv[0]+"="+kv[1])
+		}
+	}
+	return args
+}
+
+// Audio extracts the audio of input; o must be normalized. Loudness
+// normalization and silence trimming first analyze the audio in a separate
+// pass and then apply what was measured.
+func (r *Runner) Audio(ctx context.Context, taskID, input, output string, o AudioOptions) error {
+	var filters []string
+	from := 0
+	if o.Loudnorm || o.TrimSilence {
+		a, err := r.analyzeAudio(ctx, taskID, input, o)
+		if err != nil {
+			return err
+		}
+		from = 40
+		if a.trimStart > 0 || a.trimEnd > 0 {
+			trim := fmt.Sprintf("atrim=start=%s", formatSeconds(a.trimStart))
+			if a.trimEnd > 0 {
+				trim += ":end=" + formatSeconds(a.trimEnd)
+			}
+			filters = append(filters, trim, "asetpts=PTS-STARTPTS")
+		}
+		if a.loudness != nil {
+			l := a.loudness
+			filters = append(filters, fmt.Sprintf("loudnorm=I=%s:TP=%s:LRA=%s:measured_I=%s:measured_TP=%s:measured_LRA=%s:measured_thresh=%s:offset=%s:linear=true",
+				formatSeconds(o.LUFS), formatSeconds(loudnormTP), formatSeconds(loudnormLRA), l.InputI, l.InputTP, l.InputLRA, l.InputThresh, l.TargetOffset))
+		}
+	}
+	rate := o.SampleRate
+	if rate == 0 && slices.ContainsFunc(filters, func(f string) bool { return strings.HasPrefix(f, "loudnorm") }) {
+		// loudnorm works at 192 kHz and would leave the output there; opus
+		// only takes 48 kHz and a few lower rates, not the usual 44.1 kHz
+		rate = 48000
+		if o.Format != "opus" {
+			if info, err := Probe(ctx, input); err == nil && info.Audio() != nil && info.Audio().SampleRate > 0 {
+				rate = info.Audio().SampleRate
+			}
+		}
+	}
+
+	args := append(o.Clip.inputArgs(), "-i", input)
+	hasCover := false
+	if o.Meta.Cover != "" && o.Format != "opus" && o.Format != "wav" {
+		if _, err := os.Stat(o.Meta.Cover); err == nil {
+			args = append(args, "-i", o.Meta.Cover)
+			hasCover = true
+		}
+	}
+	args = append(args, o.Clip.outputArgs()...)
+	args = append(args, "-map", "0:a:0")
+	if len(filters) > 0 {
+		args = append(args, "-af", strings.Join(filters, ","))
+	}
+	args = append(args, o.codecArgs()...)
+	if rate > 0 {
+		args = append(args, "-ar", strconv.Itoa(rate))
+	}
+	if o.Channels > 0 {
+		args = append(args, "-ac", strconv.Itoa(o.Channels))
+	}
+	args = append(args, o.metaArgs(hasCover)...)
+	args = append(args, output)
+	return r.runSpan(ctx, taskID, args, input, span{Op: "audio", Stage: "transcode", From: from, To: 100, Clip: o.Clip})
+}
+
+// loudness is what the first loudnorm pass prints; values stay strings so
+// they go back to ffmpeg exactly as measured.
+type loudness struct {
+	InputI       string `json:"input_i"`
+	InputTP      string `json:"input_tp"`
+	InputLRA     string `json:"input_lra"`
+	InputThresh  string `json:"input_thresh"`
+	TargetOffset string `json:"target_offset"`
+}
+
+type audioAnalysis struct {
+	loudness           *loudness
+	trimStart, trimEnd float64
+}
+
+var (
+	silenceStartRe = regexp.MustCompile(`silence_start: (-?[\d.]+)`)
+	silenceEndRe   = regexp.MustCompile(`silence_end: (-?[\d.]+)`)
+)
+
+// analyzeAudio runs silencedetect and the measuring loudnorm pass as
+// needed. A silent input has no loudness to normalize to.
+func (r *Runner) analyzeAudio(ctx context.Context, taskID, input string, o AudioOptions) (*audioAnalysis, error) {
+	var filters []string
+	if o.TrimSilence {
+		filters = append(filters, fmt.Sprintf("silencedetect=n=%ddB:d=%s", silenceDB, formatSeconds(silenceSeconds)))
+	}
+	if o.Loudnorm {
+		filters = append(filters, fmt.Sprintf("loudnorm=I=%s:TP=%s:LRA=%s:print_format=json",
+			formatSeconds(o.LUFS), formatSeconds(loudnormTP), formatSeconds(loudnormLRA)))
+	}
+	args := append(o.Clip.inputArgs(), "-i", input)
+	args = append(args, o.Clip.outputArgs()...)
+	args = append(args, "-map", "0:a:0", "-af", strings.Join(filters, ","), "-f", "null", os.DevNull)
+	// the loudnorm summary comes last, but silence may be logged anywhere
+	out, silence := &execx.Tail{}, &silenceLog{}
+	if err := r.runSpan(ctx, taskID, args, input, span{Op: "audio", Stage: "analyze", From: 0, To: 40, Clip: o.Clip, Output: io.MultiWriter(out, silence)}); err != nil {
+		return nil, err
+	}
+	text := out.String()
+	a := &audioAnalysis{}
+	if o.Loudnorm {
+		if i := strings.LastIndex(text, "{"); i >= 0 {
+			if j := strings.Index(text[i:], "}"); j >= 0 {
+				var l loudness
+				if json.Unmarshal([]byte(text[i:i+j+1]), &l) == nil && l.InputI != "" && !strings.Contains(l.InputI, "inf") {
+					a.loudness = &l
+				}
+			}
+		}
+		if a.loudness == nil && r.Logger != nil {
+			r.Logger.Warnf("[%s] no loudness measured, skipping normalization", taskID)
+		}
+	}
+	if o.TrimSilence {
+		dur, _ := r.ffprobeDurationSeconds(ctx, input)
+		a.trimStart, a.trimEnd = silence.bounds(o.Clip.Length(dur))
+	}
+	return a, nil
+}
+
+// maxLogLine bounds the unfinished line silenceLog holds on to; longer
+// lines are not silencedetect's and only their end is kept.
+const maxLogLine = 4096
+
+// silenceLog picks the silencedetect periods out of ffmpeg's stderr as it
+// is written. Trimming needs only the first and the last period, so it
+// keeps just those however long the log grows.
+type silenceLog struct {
+	mu          sync.Mutex
+	line        []byte
+	n           int
+	first, last silencePeriod
+}
+
+// silencePeriod runs from from to to seconds; to is -1 while it lasts.
+type silencePeriod struct{ from, to float64 }
+
+func (s *silenceLog) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.line = append(s.line, p...)
+	for {
+		i := bytes.IndexAny(s.line, "\r\n")
+		if i < 0 {
+			break
+		}
+		s.parse(string(s.line[:i]))
+		s.line = s.line[i+1:]
+	}
+	if len(s.line) > maxLogLine {
+		s.line = append([]byte(nil), s.line[len(s.line)-maxLogLine/2:]...)
+	}
+	return len(p), nil
+}
+
+func (s *silenceLog) parse(line string) {
+	if m := silenceStartRe.FindStringSubmatch(line); m != nil {
+		v, _ := strconv.ParseFloat(m[1], 64)
+		s.last = silencePeriod{from: math.Max(v, 0), to: -1}
+		if s.n++; s.n == 1 {
+			s.first = s.last
+		}
+	} else if m := silenceEndRe.FindStringSubmatch(line); m != nil && s.n > 0 {
+		s.last.to, _ = strconv.ParseFloat(m[1], 64)
+		if s.n == 1 {
+			s.first.to = s.last.to
+		}
+	}
+}
+
+// bounds returns where the sound starts and, if it is followed by silence
+// up to the end, where it stops (0 otherwise). total is the length of the
+// analyzed audio, 0 if unknown.
+func (s *silenceLog) bounds(total float64) (start, end float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.line) > 0 {
+		s.parse(string(s.line))
+		s.line = nil
+	}
+	if s.n == 0 {
+		return 0, 0
+	}
+	if s.first.from < 0.05 && s.first.to > 0 {
+		start = s.first.to
+	}
+	// trailing silence either never ends or, in newer ffmpeg, ends at EOF
+	if s.last.from > start && (s.last.to < 0 || (total > 0 && s.last.to >= total-0.05)) {
+		end = s.last.from
+	}
+	return start, end
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,129 @@
+package ffmpeg
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"testing"
+)
+
+// fakeEncoders makes Encoders report enc instead of asking ffmpeg.
+func fakeEncoders(t *testing.T, enc ...string) {
+	t.Helper()
+	encMu.Lock()
+	prev := encCache
+	encCache = make(map[string]bool)
+	for _, e := range enc {
+		encCache[e] = true
+	}
+	encMu.Unlock()
+	t.Cleanup(func() {
+		encMu.Lock()
+		encCache = prev
+		encMu.Unlock()
+	})
+}
+
+func TestAudioValidate(t *testing.T) {
+	fakeEncoders(t, "libmp3lame", "aac", "libopus", "flac", "pcm_s16le")
+	tests := []struct {
+		name string
+		o    AudioOptions
+		ok   bool
+	}{
+		{"defaults", AudioOptions{}, true},
+		{"opus 48k", AudioOptions{Format: "opus", SampleRate: 48000}, true},
+		{"opus 16k", AudioOptions{Format: "opus", SampleRate: 16000}, true},
+		{"opus 44.1k", AudioOptions{Format: "opus", SampleRate: 44100}, false},
+		{"opus 96k", AudioOptions{Format: "opus", SampleRate: 96000}, false},
+		{"mp3 96k", AudioOptions{Format: "mp3", SampleRate: 96000}, false},
+		{"flac 96k", AudioOptions{Format: "flac", SampleRate: 96000}, true},
+		{"odd rate", AudioOptions{SampleRate: 11025}, false},
+		{"mp3 over 320k", AudioOptions{Format: "mp3", Bitrate: "384k"}, false},
+		{"m4a 384k", AudioOptions{Format: "m4a", Bitrate: "384k"}, true},
+		{"bad mode", AudioOptions{BitrateMode: "abr"}, false},
+		{"three channels", AudioOptions{Channels: 3}, false},
+		{"lufs too loud", AudioOptions{Loudnorm: true, LUFS: -2}, false},
+		{"unknown format", AudioOptions{Format: "wma"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := tt.o
+			o.Normalize()
+			err := o.Validate(context.Background())
+			if (err == nil) != tt.ok {
+				t.Errorf("Validate(%+v) = %v, want ok=%v", o, err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestAudioCodecArgs(t *testing.T) {
+	tests := []struct {
+		o    AudioOptions
+		want []string
+	}{
+		{AudioOptions{Format: "mp3", Bitrate: "192k"}, []string{"-c:a", "libmp3lame", "-b:a", "192k"}},
+		{AudioOptions{Format: "mp3", Bitrate: "192k", BitrateMode: "vbr"}, []string{"-c:a", "libmp3lame", "-q:a", "2"}},
+		{AudioOptions{Format: "mp3", Bitrate: "320k", BitrateMode: "vbr"}, []string{"-c:a", "libmp3lame", "-q:a", "0"}},
+		{AudioOptions{Format: "opus", BitrateMode: "vbr"}, []string{"-c:a", "libopus", "-b:a", "96k", "-vbr", "on"}},
+		{AudioOptions{Format: "opus"}, []string{"-c:a", "libopus", "-b:a", "96k", "-vbr", "off"}},
+		{AudioOptions{Format: "m4a", BitrateMode: "vbr"}, []string{"-c:a", "aac", "-b:a", "128k"}},
+		{AudioOptions{Format: "flac", Bitrate: "320k"}, []string{"-c:a", "flac"}},
+	}
+	for _, tt := range tests {
+		o := tt.o
+		o.Normalize()
+		if got := o.codecArgs(); !slices.Equal(got, tt.want) {
+			t.Errorf("codecArgs(%+v) = %q, want %q", tt.o, got, tt.want)
+		}
+	}
+}
+
+func TestSilenceLog(t *testing.T) {
+	tests := []struct {
+		name       string
+		text       string
+		total      float64
+		start, end float64
+	}{
+		{"no silence", "size=N/A time=00:00:10.00\n", 10, 0, 0},
+		{"leading", "[silencedetect @ 0x1] silence_start: 0\n[silencedetect @ 0x1] silence_end: 1.5 | silence_duration: 1.5\n", 10, 1.5, 0},
+		{"negative start", "silence_start: -0.01\nsilence_end: 0.8 | silence_duration: 0.81\n", 10, 0.8, 0},
+		{"trailing never ends", "silence_start: 8.25\n", 10, 0, 8.25},
+		{"trailing ends at eof", "silence_start: 8.25\nsilence_end: 10 | silence_duration: 1.75\n", 10, 0, 8.25},
+		{"both", "silence_start: 0\nsilence_end: 2\nsilence_start: 4\nsilence_end: 5\nsilence_start: 9\n", 10, 2, 9},
+		{"gap in the middle only", "silence_start: 4\nsilence_end: 5\n", 10, 0, 0},
+		{"all silent", "silence_start: 0\n", 10, 0, 0},
+		{"last line unterminated", "silence_start: 0\r\nsilence_end: 2\r\nsilence_start: 9", 10, 2, 9},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// ffmpeg's writes do not follow line ends
+			var s silenceLog
+			for b := []byte(tt.text); len(b) > 0; b = b[min(3, len(b)):] {
+				_, _ = s.Write(b[:min(3, len(b))])
+			}
+			start, end := s.bounds(tt.total)
+			if start != tt.start || end != tt.end {
+				t.Errorf("bounds = %v, %v; want %v, %v", start, end, tt.start, tt.end)
+			}
+		})
+	}
+}
+
+func TestSilenceLogKeepsEarlyLines(t *testing.T) {
+	var s silenceLog
+	_, _ = io.WriteString(&s, "silence_start: 0\nsilence_end: 1.25 | silence_duration: 1.25\n")
+	// far more output than a stderr tail holds, including pauses and a long line
+	for i := 0; i < 20000; i++ {
+		fmt.Fprintf(&s, "[silencedetect @ 0x1] silence_start: %d\n[silencedetect @ 0x1] silence_end: %d.5\n", 2+i, 2+i)
+	}
+	_, _ = s.Write(bytes.Repeat([]byte("x"), 3*maxLogLine))
+	_, _ = io.WriteString(&s, "\nsilence_start: 30000\n")
+	if start, end := s.bounds(30001); start != 1.25 || end != 30000 {
+		t.Errorf("bounds = %v, %v; want 1.25, 30000", start, end)
+	}
+}
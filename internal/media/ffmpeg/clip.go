@@ -0,0 +1,86 @@
+package ffmpeg
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Clip selects a time range of the input, in seconds. End 0 means up to
+// the end of the input.
+type Clip struct {
+	Start, End float64
+}
+
+// IsZero reports whether c selects the whole input.
+func (c Clip) IsZero() bool { return c.Start <= 0 && c.End <= 0 }
+
+// inputArgs go before -i. Input seeking is frame-accurate here because the
+// output is always re-encoded, and much faster than decoding up to Start.
+func (c Clip) inputArgs() []string {
+	if c.Start <= 0 {
+		return nil
+	}
+	return []string{"-ss", formatSeconds(c.Start)}
+}
+
+// outputArgs go after -i; with input seeking -t counts from Start.
+func (c Clip) outputArgs() []string {
+	if c.End <= 0 {
+		return nil
+	}
+	return []string{"-t", formatSeconds(c.End - c.Start)}
+}
+
+// Length returns how much of an input of full seconds the clip covers;
+// 0 if full is unknown.
+func (c Clip) Length(full float64) float64 {
+	if full <= 0 {
+		return 0
+	}
+	end := full
+	if c.End > 0 && c.End < full {
+		end = c.End
+	}
+	if l := end - c.Start; l > 0 {
+		return l
+	}
+	return 0
+}
+
+// Section returns the range in yt-dlp --download-sections syntax.
+func (c Clip) Section() string {
+	end := "inf"
+	if c.End > 0 {
+		end = formatSeconds(c.End)
+	}
+	return "*" + formatSeconds(c.Start) + "-" + end
+}
+
+func formatSeconds(s float64) string {
+	return strconv.FormatFloat(s, 'f', -1, 64)
+}
+
+// ParseTime reads a position given as seconds ("90", "12.5") or as
+// [hh:]mm:ss[.frac] ("1:30", "00:01:30.5").
+func ParseTime(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	parts := strings.Split(s, ":")
+	if s == "" || len(parts) > 3 {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	var total float64
+	for i, p := range parts {
+		v, err := strconv.ParseFloat(p, 64)
+		// ParseFloat also takes exponents, signs, "inf" and "nan"
+		if err != nil || !(v >= 0) || math.IsInf(v, 0) || strings.ContainsAny(p, "eE+-") || (i > 0 && v >= 60) {
+			return 0, fmt.Errorf("invalid time %q", s)
+		}
+		if i < len(parts)-1 && v != float64(int(v)) {
+			return 0, fmt.Errorf("invalid time %q", s)
+		}
+		total = total*60 + v
+	}
+	return total, nil
+}
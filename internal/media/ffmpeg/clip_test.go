@@ -0,0 +1,86 @@
+package ffmpeg
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseTime(t *testing.T) {
+	tests := []struct {
+		in   string
+		want float64
+		ok   bool
+	}{
+		{"90", 90, true},
+		{" 12.5 ", 12.5, true},
+		{"1:30", 90, true},
+		{"00:01:30.5", 90.5, true},
+		{"2:00:00", 7200, true},
+		{"0", 0, true},
+		{"", 0, false},
+		{"1:60", 0, false},
+		{"1.5:30", 0, false},
+		{"-5", 0, false},
+		{"1e3", 0, false},
+		{"1:2:3:4", 0, false},
+		{"1:", 0, false},
+		{"inf", 0, false},
+		{"NaN", 0, false},
+		{"abc", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseTime(tt.in)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("ParseTime(%q) = %v, %v; want %v, ok=%v", tt.in, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestClipSection(t *testing.T) {
+	tests := []struct {
+		c    Clip
+		want string
+	}{
+		{Clip{Start: 10, End: 20.5}, "*10-20.5"},
+		{Clip{Start: 90}, "*90-inf"},
+		{Clip{End: 30}, "*0-30"},
+	}
+	for _, tt := range tests {
+		if got := tt.c.Section(); got != tt.want {
+			t.Errorf("%+v.Section() = %q, want %q", tt.c, got, tt.want)
+		}
+	}
+}
+
+func TestClipArgs(t *testing.T) {
+	c := Clip{Start: 1.5, End: 4}
+	if got := c.inputArgs(); !slices.Equal(got, []string{"-ss", "1.5"}) {
+		t.Errorf("inputArgs = %q", got)
+	}
+	// -t counts from Start because the seek happens on the input
+	if got := c.outputArgs(); !slices.Equal(got, []string{"-t", "2.5"}) {
+		t.Errorf("outputArgs = %q", got)
+	}
+	if (Clip{}).inputArgs() != nil || (Clip{}).outputArgs() != nil || !(Clip{}).IsZero() {
+		t.Error("zero clip adds arguments")
+	}
+}
+
+func TestClipLength(t *testing.T) {
+	tests := []struct {
+		c    Clip
+		full float64
+		want float64
+	}{
+		{Clip{Start: 10, End: 20}, 60, 10},
+		{Clip{Start: 10}, 60, 50},
+		{Clip{Start: 10, End: 90}, 60, 50},
+		{Clip{Start: 70}, 60, 0},
+		{Clip{Start: 10}, 0, 0},
+	}
+	for _, tt := range tests {
+		if got := tt.c.Length(tt.full); got != tt.want {
+			t.Errorf("%+v.Length(%v) = %v, want %v", tt.c, tt.full, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package ffmpeg
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"comp/internal/execx"
+)
+
+// Video and audio encoders selectable for video_compress, in UI order.
+var (
+	VideoCodecs = []string{"libx264", "libx265", "libvpx-vp9", "libaom-av1", "libsvtav1"}
+	AudioCodecs = []string{"aac", "libopus", "libmp3lame", "libvorbis"}
+	Containers  = []string{"mp4", "mkv", "webm", "mov"}
+)
+
+// containerCodecs lists what each container can carry; mkv takes anything.
+var containerCodecs = map[string]struct{ video, audio []string }{
+	"mp4":  {video: []string{"libx264", "libx265", "libvpx-vp9", "libaom-av1", "libsvtav1"}, audio: []string{"aac", "libopus", "libmp3lame"}},
+	"mov":  {video: []string{"libx264", "libx265"}, audio: []string{"aac", "libmp3lame"}},
+	"webm": {video: []string{"libvpx-vp9", "libaom-av1", "libsvtav1"}, audio: []string{"libopus", "libvorbis"}},
+	"mkv":  {video: VideoCodecs, audio: AudioCodecs},
+}
+
+var x26xPresets = []string{"ultrafast", "superfast", "veryfast", "faster", "fast", "medium", "slow", "slower", "veryslow"}
+
+var bitrateRe = regexp.MustCompile(`^(\d{2,3})k$`)
+
+// CompressOptions selects encoders for Runner.Compress. Empty fields get
+// defaults from Normalize.
+type CompressOptions struct {
+	// CRF is the constant rate factor; 0 takes the codec's default.
+	CRF          int
+	MaxWidth     int
+	FPS          int
+	VideoCodec   string
+	Preset       string
+	Container    string
+	AudioCodec   string
+	AudioBitrate string
+	// Clip limits the output to a time range of the input.
+	Clip Clip
+}
+
+// Normalize fills defaults. The container follows the input extension when
+// it is one we can write, mp4 otherwise. The video codec defaults to H.264,
+// which plays everywhere, like the web form; HEVC must be asked for.
+func (o *CompressOptions) Normalize(inputExt string) {
+	o.VideoCodec = strings.ToLower(strings.TrimSpace(o.VideoCodec))
+	o.Preset = strings.ToLower(strings.TrimSpace(o.Preset))
+	o.Container = strings.ToLower(strings.TrimSpace(o.Container))
+	o.AudioCodec = strings.ToLower(strings.TrimSpace(o.AudioCodec))
+	o.AudioBitrate = strings.ToLower(strings.TrimSpace(o.AudioBitrate))
+	if o.Container == "" {
+		o.Container = "mp4"
+		if ext := strings.ToLower(strings.TrimPrefix(inputExt, ".")); slices.Contains(Containers, ext) {
+			o.Container = ext
+		}
+	}
+	if o.VideoCodec == "" {
+		o.VideoCodec = "libx264"
+		if o.Container == "webm" {
+			o.VideoCodec = "libvpx-vp9"
+		}
+	}
+	if o.AudioCodec == "" {
+		o.AudioCodec = "aac"
+		if o.Container == "webm" {
+			o.AudioCodec = "libopus"
+		}
+	}
+	if o.AudioBitrate == "" {
+		o.AudioBitrate = "96k"
+	}
+	if o.Preset == "" {
+		o.Preset = defaultPreset(o.VideoCodec)
+	}
+	if o.CRF == 0 {
+		o.CRF = defaultCRF(o.VideoCodec)
+	}
+}
+
+// defaultCRF is about the quality of x265's default for each encoder; an
+// unset CRF must not reach ffmpeg as 0, which is lossless for x26x.
+func defaultCRF(codec string) int {
+	switch codec {
+	case "libx264":
+		return 23
+	case "libvpx-vp9":
+		return 32
+	case "libaom-av1":
+		return 30
+	case "libsvtav1":
+		return 35
+	}
+	return 28
+}
+
+// maxCRF is the top of the encoder's CRF scale.
+func maxCRF(codec string) int {
+	if codec == "libx264" || codec == "libx265" {
+		return 51
+	}
+	return 63
+}
+
+func defaultPreset(codec string) string {
+	switch codec {
+	case "libvpx-vp9":
+		return "good"
+	case "libaom-av1":
+		return "6"
+	case "libsvtav1":
+		return "8"
+	}
+	return "slow"
+}
+
+// Validate checks the combination against the container rules and against
+// the encoders the local ffmpeg was built with.
+func (o *CompressOptions) Validate(ctx context.Context) error {
+	if !slices.Contains(VideoCodecs, o.VideoCodec) {
+		return fmt.Errorf("unsupported video codec %q", o.VideoCodec)
+	}
+	if !slices.Contains(AudioCodecs, o.AudioCodec) {
+		return fmt.Errorf("unsupported audio codec %q", o.AudioCodec)
+	}
+	cc, ok := containerCodecs[o.Container]
+	if !ok {
+		return fmt.Errorf("unsupported container %q", o.Container)
+	}
+	if !slices.Contains(cc.video, o.VideoCodec) {
+		return fmt.Errorf("%s cannot be stored in %s", o.VideoCodec, o.Container)
+	}
+	if !slices.Contains(cc.audio, o.AudioCodec) {
+		return fmt.Errorf("%s cannot be stored in %s", o.AudioCodec, o.Container)
+	}
+	if err := validatePreset(o.VideoCodec, o.Preset); err != nil {
+		return err
+	}
+	if max := maxCRF(o.VideoCodec); o.CRF < 1 || o.CRF > max {
+		return fmt.Errorf("crf must be between 1 and %d for %s", max, o.VideoCodec)
+	}
+	m := bitrateRe.FindStringSubmatch(o.AudioBitrate)
+	if m == nil {
+		return fmt.Errorf("invalid audio bitrate %q", o.AudioBitrate)
+	}
+	if kbps, _ := strconv.Atoi(m[1]); kbps < 16 || kbps > 512 {
+		return fmt.Errorf("audio bitrate %q out of range", o.AudioBitrate)
+	}
+	enc, err := Encoders(ctx)
+	if err != nil {
+		return err
+	}
+	for _, name := range []string{o.VideoCodec, o.AudioCodec} {
+		if !enc[name] {
+			return fmt.Errorf("encoder %s is not available in this ffmpeg build", name)
+		}
+	}
+	return nil
+}
+
+func validatePreset(codec, preset string) error {
+	switch codec {
+	case "libx264", "libx265":
+		if slices.Contains(x26xPresets, preset) {
+			return nil
+		}
+	case "libvpx-vp9":
+		if preset == "realtime" || preset == "good" || preset == "best" {
+			return nil
+		}
+	case "libaom-av1", "libsvtav1":
+		max := 8
+		if codec == "libsvtav1" {
+			max = 13
+		}
+		if n, err := strconv.Atoi(preset); err == nil && n >= 0 && n <= max {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid preset %q for %s", preset, codec)
+}
+
+// codecArgs returns the encoder flags for the video stream.
+func (o *CompressOptions) codecArgs() []string {
+	args := []string{"-c:v", o.VideoCodec}
+	crf := strconv.Itoa(o.CRF)
+	switch o.VideoCodec {
+	case "libx264", "libx265":
+		args = append(args, "-preset", o.Preset, "-crf", crf)
+		if o.VideoCodec == "libx265" && (o.Container == "mp4" || o.Container == "mov") {
+			// hvc1 tag is required for playback on Apple devices
+			args = append(args, "-tag:v", "hvc1")
+		}
+	case "libvpx-vp9":
+		args = append(args, "-deadline", o.Preset, "-cpu-used", "2", "-row-mt", "1", "-crf", crf, "-b:v", "0")
+	case "libaom-av1":
+		args = append(args, "-cpu-used", o.Preset, "-row-mt", "1", "-crf", crf, "-b:v", "0")
+	case "libsvtav1":
+		args = append(args, "-preset", o.Preset, "-crf", crf)
+	}
+	return append(args, "-pix_fmt", "yuv420p")
+}
+
+var (
+	encMu    sync.Mutex
+	encCache map[string]bool
+)
+
+// Encoders returns the set of encoder names reported by `ffmpeg -encoders`.
+// The result is cached after the first successful call.
+func Encoders(ctx context.Context) (map[string]bool, error) {
+	encMu.Lock()
+	defer encMu.Unlock()
+	if encCache != nil {
+		return encCache, nil
+	}
+	out, errStr, err := execx.RunContext(ctx, "ffmpeg", "-hide_banner", "-encoders")
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg -encoders: %v: %s", err, strings.TrimSpace(errStr))
+	}
+	encCache = parseEncoders(out)
+	return encCache, nil
+}
+
+func parseEncoders(out string) map[string]bool {
+	enc := make(map[string]bool)
+	body := false
+	for _, ln := range strings.Split(out, "\n") {
+		f := strings.Fields(ln)
+		// the list starts after the " ------" separator line
+		if !body {
+			body = len(f) == 1 && strings.HasPrefix(f[0], "---")
+			continue
+		}
+		if len(f) >= 2 {
+			enc[f[1]] = true
+		}
+	}
+	return enc
+}
@@ -0,0 +1,112 @@
+package ffmpeg
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestCompressNormalize(t *testing.T) {
+	tests := []struct {
+		name string
+		o    CompressOptions
+		ext  string
+		want CompressOptions
+	}{
+		{"defaults", CompressOptions{}, ".avi",
+			CompressOptions{CRF: 23, VideoCodec: "libx264", Preset: "slow", Container: "mp4", AudioCodec: "aac", AudioBitrate: "96k"}},
+		{"x265 is opt-in", CompressOptions{VideoCodec: "libx265"}, ".mkv",
+			CompressOptions{CRF: 28, VideoCodec: "libx265", Preset: "slow", Container: "mkv", AudioCodec: "aac", AudioBitrate: "96k"}},
+		{"webm input", CompressOptions{}, ".WEBM",
+			CompressOptions{CRF: 32, VideoCodec: "libvpx-vp9", Preset: "good", Container: "webm", AudioCodec: "libopus", AudioBitrate: "96k"}},
+		{"x264", CompressOptions{VideoCodec: " LIBX264 "}, ".mp4",
+			CompressOptions{CRF: 23, VideoCodec: "libx264", Preset: "slow", Container: "mp4", AudioCodec: "aac", AudioBitrate: "96k"}},
+		{"svt-av1 keeps crf", CompressOptions{CRF: 40, VideoCodec: "libsvtav1", Container: "mkv"}, ".mp4",
+			CompressOptions{CRF: 40, VideoCodec: "libsvtav1", Preset: "8", Container: "mkv", AudioCodec: "aac", AudioBitrate: "96k"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := tt.o
+			o.Normalize(tt.ext)
+			if o != tt.want {
+				t.Errorf("Normalize = %+v, want %+v", o, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompressValidate(t *testing.T) {
+	fakeEncoders(t, "libx264", "libx265", "libvpx-vp9", "libsvtav1", "aac", "libopus")
+	tests := []struct {
+		name string
+		o    CompressOptions
+		ok   bool
+	}{
+		{"defaults", CompressOptions{}, true},
+		{"x264 crf 51", CompressOptions{CRF: 51}, true},
+		{"x264 crf 52", CompressOptions{CRF: 52}, false},
+		{"x265 crf 51", CompressOptions{CRF: 51, VideoCodec: "libx265"}, true},
+		{"vp9 crf 63", CompressOptions{CRF: 63, VideoCodec: "libvpx-vp9", Container: "webm"}, true},
+		{"vp9 crf 64", CompressOptions{CRF: 64, VideoCodec: "libvpx-vp9", Container: "webm"}, false},
+		{"negative crf", CompressOptions{CRF: -1}, false},
+		{"h264 in webm", CompressOptions{VideoCodec: "libx264", Container: "webm"}, false},
+		{"vorbis in mp4", CompressOptions{AudioCodec: "libvorbis"}, false},
+		{"bad x26x preset", CompressOptions{Preset: "good"}, false},
+		{"svt-av1 preset 13", CompressOptions{VideoCodec: "libsvtav1", Preset: "13"}, true},
+		{"svt-av1 preset 14", CompressOptions{VideoCodec: "libsvtav1", Preset: "14"}, false},
+		{"audio bitrate", CompressOptions{AudioBitrate: "600k"}, false},
+		{"encoder missing", CompressOptions{VideoCodec: "libaom-av1"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := tt.o
+			o.Normalize(".mp4")
+			err := o.Validate(context.Background())
+			if (err == nil) != tt.ok {
+				t.Errorf("Validate(%+v) = %v, want ok=%v", o, err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestCompressCodecArgs(t *testing.T) {
+	o := CompressOptions{}
+	o.Normalize(".mov")
+	want := []string{"-c:v", "libx264", "-preset", "slow", "-crf", "23", "-pix_fmt", "yuv420p"}
+	if got := o.codecArgs(); !slices.Equal(got, want) {
+		t.Errorf("codecArgs = %q, want %q", got, want)
+	}
+	o = CompressOptions{VideoCodec: "libx265"}
+	o.Normalize(".mov")
+	want = []string{"-c:v", "libx265", "-preset", "slow", "-crf", "28", "-tag:v", "hvc1", "-pix_fmt", "yuv420p"}
+	if got := o.codecArgs(); !slices.Equal(got, want) {
+		t.Errorf("codecArgs = %q, want %q", got, want)
+	}
+	o = CompressOptions{VideoCodec: "libvpx-vp9"}
+	o.Normalize(".webm")
+	want = []string{"-c:v", "libvpx-vp9", "-deadline", "good", "-cpu-used", "2", "-row-mt", "1", "-crf", "32", "-b:v", "0", "-pix_fmt", "yuv420p"}
+	if got := o.codecArgs(); !slices.Equal(got, want) {
+		t.Errorf("codecArgs = %q, want %q", got, want)
+	}
+}
+
+func TestParseEncoders(t *testing.T) {
+	out := `Encoders:
+ V..... = Video
+ A..... = Audio
+ ------
+ V....D libx264              libx264 H.264 / AVC / MPEG-4 AVC / MPEG-4 part 10 (codec h264)
+ V....D libsvtav1            SVT-AV1(Scalable Video Technology for AV1) encoder (codec av1)
+ A....D aac                  AAC (Advanced Audio Coding)
+`
+	enc := parseEncoders(out)
+	for _, name := range []string{"libx264", "libsvtav1", "aac"} {
+		if !enc[name] {
+			t.Errorf("%s missing from %v", name, enc)
+		}
+	}
+	// the legend above the separator is not a list of encoders
+	if enc["="] || len(enc) != 3 {
+		t.Errorf("parseEncoders = %v, want exactly 3 encoders", enc)
+	}
+}
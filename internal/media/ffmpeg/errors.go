@@ -0,0 +1,38 @@
+package ffmpeg
+
+import (
+	"regexp"
+	"strings"
+
+	"comp/internal/execx"
+)
+
+// errorRules turn ffmpeg's stderr into the reason shown on a failed task.
+var errorRules = []execx.Rule{
+	execx.NewRule(`Invalid data found when processing input`, "invalid or corrupt input data"),
+	execx.NewRule(`moov atom not found`, "incomplete or corrupt MP4 file (moov atom not found)"),
+	execx.NewRule(`Unknown encoder '([^']+)'`, "encoder $1 is not available"),
+	execx.NewRule(`Encoder not found|Unknown encoder`, "encoder is not available"),
+	execx.NewRule(`Decoder \(codec ([^)]+)\) not found`, "unsupported input codec $1"),
+	execx.NewRule(`[Cc]odec not currently supported in container|Could not find tag for codec ([^ ]+)`, "codec is not supported by the output container"),
+	execx.NewRule(`(?i)unsupported codec`, "unsupported codec"),
+	execx.NewRule(`does not contain any stream|Stream map '[^']*' matches no streams|Output file is empty`, "input has no usable audio or video stream"),
+	execx.NewRule(`No space left on device`, "no space left on device"),
+	execx.NewRule(`Cannot allocate memory`, "out of memory"),
+	execx.NewRule(`No such file or directory`, "input file not found"),
+	execx.NewRule(`Permission denied`, "permission denied"),
+}
+
+// ctxPrefix matches ffmpeg's "[mov,mp4 @ 0x55d0c8]" log prefixes.
+var ctxPrefix = regexp.MustCompile(`^\[[^\]]+ @ 0x[0-9a-f]+\] *`)
+
+// failure wraps err with a reason read from ffmpeg's stderr.
+func failure(stderr string, err error) error {
+	reason := execx.Reason(stderr, errorRules)
+	if reason == "" {
+		reason = ctxPrefix.ReplaceAllString(execx.LastLine(stderr, func(l string) bool {
+			return !strings.HasPrefix(l, "Conversion failed")
+		}), "")
+	}
+	return &execx.Error{Reason: reason, Err: err}
+}
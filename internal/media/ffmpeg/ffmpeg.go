@@ -5,7 +5,6 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -14,6 +13,7 @@ import (
 	"go.uber.org/zap"
 
 	"comp/internal/execx"
+	"comp/internal/metrics"
 	"comp/internal/store"
 )
 
@@ -22,132 +22,102 @@ type Runner struct {
 	Logger *zap.SugaredLogger
 }
 
-func (r *Runner) ffprobeDurationSeconds(input string) (float64, error) {
-	out, errStr, err := execx.Run("ffprobe", "-v", "error", "-show_entries", "format=duration", "-of", "default=noprint_wrappers=1:nokey=1", input)
-	if err == nil {
-		if v, perr := strconv.ParseFloat(strings.TrimSpace(out), 64); perr == nil && v > 0 {
-			if r.Logger != nil {
-				r.Logger.Debugf("ffprobe(format) duration=%.3fs for %s", v, input)
-			}
-			return v, nil
+func (r *Runner) ffprobeDurationSeconds(ctx context.Context, input string) (float64, error) {
+	info, err := Probe(ctx, input)
+	if err != nil {
+		if r.Logger != nil {
+			r.Logger.Debugf("ffprobe failed: %v", err)
 		}
-	} else if r.Logger != nil {
-		r.Logger.Debugf("ffprobe(format) failed: %v: %s", err, strings.TrimSpace(errStr))
+		return 0, err
 	}
-	out2, errStr2, err2 := execx.Run("ffprobe", "-v", "error", "-show_entries", "stream=duration", "-of", "csv=p=0", input)
-	if err2 == nil {
-		lines := strings.Split(strings.TrimSpace(out2), "\n")
-		var maxDur float64
-		for _, ln := range lines {
-			if ln == "N/A" || ln == "" {
-				continue
-			}
-			if v, perr := strconv.ParseFloat(strings.TrimSpace(ln), 64); perr == nil && v > maxDur {
-				maxDur = v
-			}
-		}
-		if maxDur > 0 {
-			if r.Logger != nil {
-				r.Logger.Debugf("ffprobe(stream) duration=%.3fs for %s", maxDur, input)
-			}
-			return maxDur, nil
-		}
-	} else if r.Logger != nil {
-		r.Logger.Debugf("ffprobe(stream) failed: %v: %s", err2, strings.TrimSpace(errStr2))
+	if info.Duration <= 0 {
+		return 0, fmt.Errorf("ffprobe: cannot determine duration")
 	}
-	out3, errStr3, err3 := execx.Run("ffprobe", "-v", "error", "-select_streams", "v:0", "-show_entries", "stream=duration", "-of", "default=nw=1:nk=1", input)
-	if err3 == nil {
-		if v, perr := strconv.ParseFloat(strings.TrimSpace(out3), 64); perr == nil && v > 0 {
-			if r.Logger != nil {
-				r.Logger.Debugf("ffprobe(v:0) duration=%.3fs for %s", v, input)
-			}
-			return v, nil
-		}
-	} else if r.Logger != nil {
-		r.Logger.Debugf("ffprobe(v:0) failed: %v: %s", err3, strings.TrimSpace(errStr3))
+	if r.Logger != nil {
+		r.Logger.Debugf("ffprobe duration=%.3fs for %s", info.Duration, input)
 	}
-	return 0, fmt.Errorf("ffprobe: cannot determine duration")
+	return info.Duration, nil
 }
 
 // VideoProps returns width, height, fps (fps may be 0).
-func (r *Runner) VideoProps(input string) (int, int, float64, error) {
-	out, errStr, err := execx.Run("ffprobe", "-v", "error", "-select_streams", "v:0", "-show_entries", "stream=width,height,avg_frame_rate,r_frame_rate", "-of", "default=nw=1:nk=1", input)
+func (r *Runner) VideoProps(ctx context.Context, input string) (int, int, float64, error) {
+	info, err := Probe(ctx, input)
 	if err != nil {
 		if r.Logger != nil {
-			r.Logger.Debugf("ffprobe(props) failed: %v: %s", err, strings.TrimSpace(errStr))
+			r.Logger.Debugf("ffprobe(props) failed: %v", err)
 		}
 		return 0, 0, 0, err
 	}
-	lines := strings.Split(strings.TrimSpace(out), "\n")
-	var w, h int
-	var fps float64
-	parseRatio := func(s string) float64 {
-		s = strings.TrimSpace(s)
-		if s == "" || s == "N/A" {
-			return 0
-		}
-		if strings.Contains(s, "/") {
-			parts := strings.SplitN(s, "/", 2)
-			num, _ := strconv.ParseFloat(parts[0], 64)
-			den, _ := strconv.ParseFloat(parts[1], 64)
-			if den != 0 {
-				return num / den
-			}
-			if den == 0 && num > 0 {
-				return num
-			}
-			return 0
-		}
-		v, _ := strconv.ParseFloat(s, 64)
-		return v
-	}
-	if len(lines) >= 1 {
-		w, _ = strconv.Atoi(strings.TrimSpace(lines[0]))
-	}
-	if len(lines) >= 2 {
-		h, _ = strconv.Atoi(strings.TrimSpace(lines[1]))
-	}
-	if len(lines) >= 3 {
-		fps = parseRatio(lines[2])
-	}
-	if fps == 0 && len(lines) >= 4 {
-		fps = parseRatio(lines[3])
+	v := info.Video()
+	if v == nil || v.Width <= 0 || v.Height <= 0 {
+		return 0, 0, 0, fmt.Errorf("ffprobe: no dimensions")
 	}
 	if r.Logger != nil {
-		r.Logger.Debugf("ffprobe props for %s -> %dx%d @ %.3ffps", input, w, h, fps)
+		r.Logger.Debugf("ffprobe props for %s -> %dx%d @ %.3ffps", input, v.Width, v.Height, v.FPS)
 	}
-	if w <= 0 || h <= 0 {
-		return 0, 0, fps, fmt.Errorf("ffprobe: no dimensions")
-	}
-	return w, h, fps, nil
+	return v.Width, v.Height, v.FPS, nil
+}
+
+// span maps one ffmpeg run onto a slice of the task's progress bar, so
+// multi-pass jobs advance monotonically instead of restarting at 0.
+// Op names the operation in metrics; progress is measured against the
+// part of the input that Clip selects. Output, if set, also gets ffmpeg's
+// stderr, for filters that print their measurements there.
+type span struct {
+	Op       string
+	Stage    string
+	From, To int
+	Clip     Clip
+	Output   io.Writer
 }
 
-func (r *Runner) runWithProgress(taskID string, baseArgs []string, input string) error {
-	ctx := context.Background()
-	dur, derr := r.ffprobeDurationSeconds(input)
+func (r *Runner) runWithProgress(ctx context.Context, taskID, op string, baseArgs []string, input string, clip Clip) error {
+	return r.runSpan(ctx, taskID, baseArgs, input, span{Op: op, Stage: "transcode", From: 0, To: 100, Clip: clip})
+}
+
+func (r *Runner) runSpan(ctx context.Context, taskID string, baseArgs []string, input string, sp span) error {
+	dur, derr := r.ffprobeDurationSeconds(ctx, input)
 	if derr != nil {
 		if r.Logger != nil {
 			r.Logger.Warnf("[%s] duration unknown: %v", taskID, derr)
 		}
 	}
+	if derr == nil && sp.Clip.Start >= dur {
+		return fmt.Errorf("start %.1fs is past the end of the media (%.1fs)", sp.Clip.Start, dur)
+	}
+	dur = sp.Clip.Length(dur)
 	args := append([]string{"-y", "-progress", "pipe:1", "-nostats"}, baseArgs...)
-	cmd := exec.Command("ffmpeg", args...)
+	cmd := execx.Command(ctx, "ffmpeg", args...)
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
 		return err
 	}
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		return err
+	stderr := &execx.Tail{}
+	cmd.Stderr = stderr
+	if sp.Output != nil {
+		cmd.Stderr = io.MultiWriter(stderr, sp.Output)
 	}
+	end := execx.Span(ctx, cmd)
 	if err := cmd.Start(); err != nil {
+		end(err)
 		return err
 	}
+	started := time.Now()
+	done := metrics.Track("ffmpeg")
 	reader := bufio.NewReader(stdout)
 	go func() {
+		lastPct := -1
+		var speed, eta float64
 		for {
 			line, err := reader.ReadString('\n')
 			if line != "" {
+				// speed= follows out_time_ms= in each progress block
+				if strings.HasPrefix(line, "speed=") {
+					v := strings.TrimSuffix(strings.TrimSpace(strings.TrimPrefix(line, "speed=")), "x")
+					if f, err := strconv.ParseFloat(v, 64); err == nil {
+						speed = f
+					}
+				}
 				if strings.HasPrefix(line, "out_time_ms=") {
 					v := strings.TrimSpace(strings.TrimPrefix(line, "out_time_ms="))
 					if ms, err := strconv.ParseFloat(v, 64); err == nil {
@@ -160,6 +130,9 @@ func (r *Runner) runWithProgress(taskID string, baseArgs []string, input string)
 							if pct < 0 {
 								pct = 0
 							}
+							if speed > 0 {
+								eta = (dur - ms/1e6) / speed
+							}
 						} else {
 							seconds := ms / 1e6
 							pct = int(seconds / 120.0 * 90.0)
@@ -170,7 +143,12 @@ func (r *Runner) runWithProgress(taskID string, baseArgs []string, input string)
 								pct = 0
 							}
 						}
-						_ = r.Store.Set(ctx, &store.TaskStatus{ID: taskID, Status: "processing", Stage: "transcode", Percent: pct}, 30*time.Minute)
+						pct = sp.From + pct*(sp.To-sp.From)/100
+						// ffmpeg reports several times a second; only write when the percent moves
+						if pct != lastPct {
+							lastPct = pct
+							_ = r.Store.Set(ctx, &store.TaskStatus{ID: taskID, Status: "processing", Stage: sp.Stage, Percent: pct, Speed: speed, ETASeconds: eta}, 30*time.Minute)
+						}
 					}
 				}
 			}
@@ -179,17 +157,32 @@ func (r *Runner) runWithProgress(taskID string, baseArgs []string, input string)
 			}
 		}
 	}()
-	go func() { io.Copy(io.Discard, stderr) }()
 	err = cmd.Wait()
-	if err == nil {
-		_ = r.Store.Set(ctx, &store.TaskStatus{ID: taskID, Status: "processing", Stage: "transcode", Percent: 100}, 30*time.Minute)
+	end(err)
+	// the log should survive cancellation too
+	_ = r.Store.AppendLog(context.WithoutCancel(ctx), taskID, execx.Transcript(cmd, stderr.String(), err), 30*time.Minute)
+	done()
+	result := "ok"
+	switch {
+	case ctx.Err() != nil:
+		result = "cancelled"
+	case err != nil:
+		result = "error"
+	}
+	metrics.TranscodeSeconds.WithLabelValues(sp.Op, result).Observe(time.Since(started).Seconds())
+	if err != nil {
+		if ctx.Err() != nil {
+			return err
+		}
+		return failure(stderr.String(), err)
 	}
-	return err
+	_ = r.Store.Set(ctx, &store.TaskStatus{ID: taskID, Status: "processing", Stage: sp.Stage, Percent: sp.To}, 30*time.Minute)
+	return nil
 }
 
-func (r *Runner) Compress(taskID, input, output string, crf, maxWidth, fps int) error {
-	// Clamp to source
-	srcW, _, srcFPS, _ := r.VideoProps(input)
+// scaleFPSArgs returns -vf/-r arguments limiting width and fps, clamped to the source.
+func (r *Runner) scaleFPSArgs(ctx context.Context, input string, maxWidth, fps int) []string {
+	srcW, _, srcFPS, _ := r.VideoProps(ctx, input)
 	effWidth := maxWidth
 	if maxWidth > 0 && srcW > 0 && maxWidth > srcW {
 		effWidth = srcW
@@ -204,63 +197,32 @@ func (r *Runner) Compress(taskID, input, output string, crf, maxWidth, fps int)
 			effFPS = 1
 		}
 	}
-	args := []string{"-i", input}
+	var args []string
 	if effWidth > 0 {
 		args = append(args, "-vf", fmt.Sprintf("scale='min(%d,iw)':-2", effWidth))
 	}
 	if effFPS > 0 {
 		args = append(args, "-r", strconv.Itoa(effFPS))
 	}
-	codec := "libx265"
-	audioCodec := "aac"
-	pixFmt := "yuv420p"
-	ext := strings.ToLower(filepath.Ext(output))
-	if ext == ".webm" {
-		codec = "libvpx-vp9"
-		audioCodec = "libopus"
-	}
-	args = append(args, "-c:v", codec, "-preset", "slow", "-crf", strconv.Itoa(crf))
-	if ext != ".webm" {
-		args = append(args, "-pix_fmt", pixFmt)
-	}
-	args = append(args, "-c:a", audioCodec, "-b:a", "96k", output)
-	return r.runWithProgress(taskID, args, input)
+	return args
 }
 
-func (r *Runner) GIF(taskID, input, output string, width, fps int) error {
-	srcW, _, srcFPS, _ := r.VideoProps(input)
-	effWidth := width
-	if width > 0 && srcW > 0 && width > srcW {
-		effWidth = srcW
-	}
-	effFPS := fps
-	if fps > 0 && srcFPS > 0 {
-		srcInt := int(srcFPS + 0.0001)
-		if effFPS > srcInt {
-			effFPS = srcInt
-		}
-		if effFPS < 1 {
-			effFPS = 1
-		}
-	}
-	scaleFilter := "scale=iw:-1:flags=lanczos"
-	if effWidth > 0 {
-		scaleFilter = fmt.Sprintf("scale='min(%d,iw)':-1:flags=lanczos", effWidth)
-	}
-	vf := scaleFilter
-	if effFPS > 0 {
-		vf = fmt.Sprintf("fps=%d,%s", effFPS, scaleFilter)
+// Compress re-encodes input with the encoders chosen in o; o must be normalized.
+func (r *Runner) Compress(ctx context.Context, taskID, input, output string, o CompressOptions) error {
+	// Clamp to source
+	args := append(o.Clip.inputArgs(), "-i", input)
+	args = append(args, o.Clip.outputArgs()...)
+	args = append(args, r.scaleFPSArgs(ctx, input, o.MaxWidth, o.FPS)...)
+	args = append(args, o.codecArgs()...)
+	args = append(args, "-c:a", o.AudioCodec, "-b:a", o.AudioBitrate)
+	if o.Container == "mp4" || o.Container == "mov" {
+		args = append(args, "-movflags", "+faststart")
 	}
-	args := []string{"-i", input, "-vf", vf, output}
-	return r.runWithProgress(taskID, args, input)
-}
-
-func (r *Runner) Audio(taskID, input, output, bitrate string) error {
-	args := []string{"-i", input, "-vn", "-c:a", "libmp3lame", "-b:a", bitrate, output}
-	return r.runWithProgress(taskID, args, input)
+	args = append(args, output)
+	return r.runWithProgress(ctx, taskID, "compress", args, input, o.Clip)
 }
 
-func (r *Runner) Image(taskID, input, output string, quality, maxWidth int) error {
+func (r *Runner) Image(ctx context.Context, taskID, input, output string, quality, maxWidth int) error {
 	// Simple image re-encode via ffmpeg
 	args := []string{"-i", input}
 	if maxWidth > 0 {
@@ -282,5 +244,5 @@ func (r *Runner) Image(taskID, input, output string, quality, maxWidth int) erro
 		args = append(args, "-compression_level", strconv.Itoa(lvl))
 	}
 	args = append(args, output)
-	return r.runWithProgress(taskID, args, input)
+	return r.runWithProgress(ctx, taskID, "image", args, input, Clip{})
 }
@@ -0,0 +1,250 @@
+package ffmpeg
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Choices for video_to_gif, in UI order.
+var (
+	AnimFormats = []string{"gif", "webp", "apng"}
+	StatsModes  = []string{"full", "diff", "single"}
+	Dithers     = []string{"sierra2_4a", "sierra2", "floyd_steinberg", "bayer", "heckbert", "none"}
+)
+
+// Floors of the max size step-down; below them the result is not worth
+// having and the task fails instead.
+const (
+	minAnimFPS      = 6
+	minAnimWidth    = 160
+	minAnimColors   = 32
+	maxAnimAttempts = 8
+)
+
+// GIFOptions configures Runner.GIF. Empty fields get defaults from
+// Normalize; Colors, StatsMode and Dither only apply to GIF output.
+type GIFOptions struct {
+	Format    string
+	MaxWidth  int
+	FPS       int
+	Colors    int
+	StatsMode string
+	Dither    string
+	// Loop is how many times the animation plays; 0 loops forever.
+	Loop int
+	// MaxSizeMB makes GIF step down fps, width and colours until the
+	// output fits.
+	MaxSizeMB float64
+	Clip      Clip
+}
+
+// Normalize fills defaults.
+func (o *GIFOptions) Normalize() {
+	o.Format = strings.ToLower(strings.TrimSpace(o.Format))
+	o.StatsMode = strings.ToLower(strings.TrimSpace(o.StatsMode))
+	o.Dither = strings.ToLower(strings.TrimSpace(o.Dither))
+	if o.Format == "" {
+		o.Format = "gif"
+	}
+	if o.Colors == 0 {
+		o.Colors = 256
+	}
+	if o.StatsMode == "" {
+		o.StatsMode = "full"
+	}
+	if o.Dither == "" {
+		o.Dither = "sierra2_4a"
+	}
+}
+
+// Validate checks the options and that the local ffmpeg can write the format.
+func (o *GIFOptions) Validate(ctx context.Context) error {
+	if !slices.Contains(AnimFormats, o.Format) {
+		return fmt.Errorf("unsupported animation format %q", o.Format)
+	}
+	if o.Colors < 2 || o.Colors > 256 {
+		return fmt.Errorf("colors must be between 2 and 256")
+	}
+	if !slices.Contains(StatsModes, o.StatsMode) {
+		return fmt.Errorf("invalid stats_mode %q", o.StatsMode)
+	}
+	if !slices.Contains(Dithers, o.Dither) {
+		return fmt.Errorf("invalid dither %q", o.Dither)
+	}
+	if o.Loop < 0 || o.Loop > 65535 {
+		return fmt.Errorf("loop must be between 0 and 65535")
+	}
+	if o.MaxSizeMB < 0 {
+		return fmt.Errorf("max_size_mb must not be negative")
+	}
+	if o.Format == "gif" {
+		return nil
+	}
+	enc, err := Encoders(ctx)
+	if err != nil {
+		return err
+	}
+	if o.encoder(enc) == "" {
+		return fmt.Errorf("%s output is not available in this ffmpeg build", o.Format)
+	}
+	return nil
+}
+
+// Ext returns the output file extension. APNG keeps .png, which viewers
+// without animation support still open.
+func (o *GIFOptions) Ext() string {
+	switch o.Format {
+	case "webp":
+		return ".webp"
+	case "apng":
+		return ".png"
+	}
+	return ".gif"
+}
+
+func (o *GIFOptions) encoder(enc map[string]bool) string {
+	switch o.Format {
+	case "webp":
+		for _, name := range []string{"libwebp_anim", "libwebp"} {
+			if enc[name] {
+				return name
+			}
+		}
+	case "apng":
+		if enc["apng"] {
+			return "apng"
+		}
+	}
+	return ""
+}
+
+// GIF renders input as an animation; o must be normalized. With a max size
+// it re-encodes at lower fps, then width, then colours until the output fits.
+func (r *Runner) GIF(ctx context.Context, taskID, input, output string, o GIFOptions) error {
+	srcW, _, srcFPS, _ := r.VideoProps(ctx, input)
+	width := o.MaxWidth
+	if width <= 0 || (srcW > 0 && width > srcW) {
+		width = srcW
+	}
+	fps := o.FPS
+	if srcFPS > 0 && (fps <= 0 || fps > int(srcFPS+0.0001)) {
+		fps = int(srcFPS + 0.0001)
+	}
+	if fps < 0 {
+		fps = 0
+	}
+	colors := o.Colors
+	limit := int64(o.MaxSizeMB * 1024 * 1024)
+	var size int64
+	for attempt := 0; attempt < maxAnimAttempts; attempt++ {
+		stage := "transcode"
+		if attempt > 0 {
+			stage = fmt.Sprintf("retry%d", attempt)
+		}
+		if err := r.encodeAnim(ctx, taskID, input, output, o, width, fps, colors, stage); err != nil {
+			return err
+		}
+		if limit <= 0 {
+			return nil
+		}
+		fi, err := os.Stat(output)
+		if err != nil {
+			return err
+		}
+		size = fi.Size()
+		if size <= limit {
+			return nil
+		}
+		// shrink by about the overshoot, but at most halve fps or area at once
+		shrink := math.Max(float64(limit)/float64(size)*0.9, 0.5)
+		switch {
+		case fps > minAnimFPS:
+			fps = max(minAnimFPS, int(float64(fps)*shrink))
+		case width > minAnimWidth:
+			w := int(float64(width) * math.Sqrt(shrink))
+			width = max(minAnimWidth, w-w%2)
+		case o.Format == "gif" && colors > minAnimColors:
+			colors = max(minAnimColors, colors/2)
+		default:
+			return fmt.Errorf("could not fit %s into %.1f MB (smallest was %.1f MB)", o.Format, o.MaxSizeMB, float64(size)/(1024*1024))
+		}
+		if r.Logger != nil {
+			r.Logger.Infof("[%s] %s is %d bytes, over %d; retrying at %dfps, width %d, %d colours", taskID, o.Format, size, limit, fps, width, colors)
+		}
+	}
+	return fmt.Errorf("could not fit %s into %.1f MB (smallest was %.1f MB)", o.Format, o.MaxSizeMB, float64(size)/(1024*1024))
+}
+
+// encodeAnim runs one encode at the given fps, width and colours (0 keeps
+// the source's).
+func (r *Runner) encodeAnim(ctx context.Context, taskID, input, output string, o GIFOptions, width, fps, colors int, stage string) error {
+	var chain []string
+	if fps > 0 {
+		chain = append(chain, "fps="+strconv.Itoa(fps))
+	}
+	if width > 0 {
+		chain = append(chain, fmt.Sprintf("scale='min(%d,iw)':-1:flags=lanczos", width))
+	}
+	in := append(o.Clip.inputArgs(), "-i", input)
+	if o.Format != "gif" {
+		enc, err := Encoders(ctx)
+		if err != nil {
+			return err
+		}
+		args := append(in, o.Clip.outputArgs()...)
+		if len(chain) > 0 {
+			args = append(args, "-vf", strings.Join(chain, ","))
+		}
+		if o.Format == "webp" {
+			args = append(args, "-c:v", o.encoder(enc), "-lossless", "0", "-q:v", "75", "-loop", strconv.Itoa(o.Loop))
+		} else {
+			args = append(args, "-c:v", "apng", "-plays", strconv.Itoa(o.Loop), "-f", "apng")
+		}
+		args = append(args, "-an", output)
+		return r.runSpan(ctx, taskID, args, input, span{Op: "gif", Stage: stage, From: 0, To: 100, Clip: o.Clip})
+	}
+
+	gen := fmt.Sprintf("palettegen=max_colors=%d:stats_mode=%s", colors, o.StatsMode)
+	use := "paletteuse=dither=" + o.Dither
+	if o.StatsMode == "diff" {
+		// only redraw what changed, matching the palette's focus
+		use += ":diff_mode=rectangle"
+	}
+	// the gif muxer counts repeats after the first play; -1 plays once
+	loop := "0"
+	if o.Loop > 0 {
+		loop = strconv.Itoa(o.Loop - 1)
+		if o.Loop == 1 {
+			loop = "-1"
+		}
+	}
+	pre := "[0:v]"
+	if len(chain) > 0 {
+		pre = "[0:v]" + strings.Join(chain, ",") + ","
+	}
+	if o.StatsMode == "single" {
+		// a new palette per frame has to stream straight into paletteuse
+		args := append(in, o.Clip.outputArgs()...)
+		args = append(args, "-lavfi", pre+"split[a][b];[a]"+gen+"[p];[b][p]"+use+":new=1", "-loop", loop, output)
+		return r.runSpan(ctx, taskID, args, input, span{Op: "gif", Stage: stage, From: 0, To: 100, Clip: o.Clip})
+	}
+
+	// two passes: the palette of the whole clip first, then the GIF with it
+	palette := filepath.Join(filepath.Dir(output), "palette.png")
+	defer os.Remove(palette)
+	pass1 := append(append([]string{}, in...), o.Clip.outputArgs()...)
+	pass1 = append(pass1, "-lavfi", pre+gen, "-update", "1", "-frames:v", "1", palette)
+	if err := r.runSpan(ctx, taskID, pass1, input, span{Op: "gif", Stage: stage, From: 0, To: 40, Clip: o.Clip}); err != nil {
+		return err
+	}
+	pass2 := append(append([]string{}, in...), "-i", palette)
+	pass2 = append(pass2, o.Clip.outputArgs()...)
+	pass2 = append(pass2, "-lavfi", pre+"null[x];[x][1:v]"+use, "-loop", loop, output)
+	return r.runSpan(ctx, taskID, pass2, input, span{Op: "gif", Stage: stage, From: 40, To: 100, Clip: o.Clip})
+}
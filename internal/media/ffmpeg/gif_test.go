@@ -0,0 +1,51 @@
+package ffmpeg
+
+import (
+	"context"
+	"testing"
+)
+
+func TestGIFOptions(t *testing.T) {
+	fakeEncoders(t, "libwebp_anim")
+	tests := []struct {
+		name string
+		o    GIFOptions
+		ext  string
+		ok   bool
+	}{
+		{"defaults", GIFOptions{}, ".gif", true},
+		{"webp", GIFOptions{Format: " WebP "}, ".webp", true},
+		{"apng missing", GIFOptions{Format: "apng"}, ".png", false},
+		{"one colour", GIFOptions{Colors: 1}, ".gif", false},
+		{"bad dither", GIFOptions{Dither: "ordered"}, ".gif", false},
+		{"bad stats mode", GIFOptions{StatsMode: "half"}, ".gif", false},
+		{"loop limit", GIFOptions{Loop: 65536}, ".gif", false},
+		{"negative max size", GIFOptions{MaxSizeMB: -1}, ".gif", false},
+		{"unknown format", GIFOptions{Format: "avif"}, ".gif", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := tt.o
+			o.Normalize()
+			if err := o.Validate(context.Background()); (err == nil) != tt.ok {
+				t.Errorf("Validate(%+v) = %v, want ok=%v", o, err, tt.ok)
+			}
+			if o.Ext() != tt.ext {
+				t.Errorf("Ext = %q, want %q", o.Ext(), tt.ext)
+			}
+		})
+	}
+}
+
+func TestGIFEncoder(t *testing.T) {
+	webp := GIFOptions{Format: "webp"}
+	if got := webp.encoder(map[string]bool{"libwebp": true, "libwebp_anim": true}); got != "libwebp_anim" {
+		t.Errorf("encoder = %q, want libwebp_anim first", got)
+	}
+	if got := webp.encoder(map[string]bool{"libwebp": true}); got != "libwebp" {
+		t.Errorf("encoder = %q, want the libwebp fallback", got)
+	}
+	if got := (&GIFOptions{Format: "gif"}).encoder(map[string]bool{"gif": true}); got != "" {
+		t.Errorf("gif encoder = %q, want none (built in)", got)
+	}
+}
@@ -0,0 +1,220 @@
+package ffmpeg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"comp/internal/execx"
+)
+
+// MediaInfo is the parsed output of ffprobe for one file.
+type MediaInfo struct {
+	FormatName string       `json:"format_name"`
+	FormatLong string       `json:"format_long,omitempty"`
+	Duration   float64      `json:"duration"`
+	Size       int64        `json:"size"`
+	BitRate    int64        `json:"bit_rate"`
+	Title      string       `json:"title,omitempty"`
+	Streams    []StreamInfo `json:"streams"`
+}
+
+// StreamInfo describes one stream; video/audio-only fields stay zero otherwise.
+type StreamInfo struct {
+	Index     int     `json:"index"`
+	Type      string  `json:"type"` // video, audio, subtitle, data, attachment
+	Codec     string  `json:"codec"`
+	CodecLong string  `json:"codec_long,omitempty"`
+	Profile   string  `json:"profile,omitempty"`
+	BitRate   int64   `json:"bit_rate,omitempty"`
+	Duration  float64 `json:"duration,omitempty"`
+	Language  string  `json:"language,omitempty"`
+	Title     string  `json:"title,omitempty"`
+	Default   bool    `json:"default,omitempty"`
+
+	Width          int     `json:"width,omitempty"`
+	Height         int     `json:"height,omitempty"`
+	FPS            float64 `json:"fps,omitempty"`
+	PixFmt         string  `json:"pix_fmt,omitempty"`
+	Rotation       int     `json:"rotation,omitempty"`
+	ColorTransfer  string  `json:"color_transfer,omitempty"`
+	ColorPrimaries string  `json:"color_primaries,omitempty"`
+	HDR            bool    `json:"hdr,omitempty"`
+	// AttachedPic marks cover art stored as a video stream.
+	AttachedPic bool `json:"attached_pic,omitempty"`
+
+	Channels      int    `json:"channels,omitempty"`
+	ChannelLayout string `json:"channel_layout,omitempty"`
+	SampleRate    int    `json:"sample_rate,omitempty"`
+}
+
+// Video returns the first real video stream (cover art is skipped), or nil.
+func (m *MediaInfo) Video() *StreamInfo {
+	for i := range m.Streams {
+		if m.Streams[i].Type == "video" && !m.Streams[i].AttachedPic {
+			return &m.Streams[i]
+		}
+	}
+	return nil
+}
+
+// Audio returns the first audio stream, or nil.
+func (m *MediaInfo) Audio() *StreamInfo {
+	for i := range m.Streams {
+		if m.Streams[i].Type == "audio" {
+			return &m.Streams[i]
+		}
+	}
+	return nil
+}
+
+// Subtitles returns all subtitle tracks.
+func (m *MediaInfo) Subtitles() []StreamInfo {
+	var subs []StreamInfo
+	for _, s := range m.Streams {
+		if s.Type == "subtitle" {
+			subs = append(subs, s)
+		}
+	}
+	return subs
+}
+
+// raw ffprobe JSON; most numbers are encoded as strings.
+type probeOutput struct {
+	Format struct {
+		FormatName     string            `json:"format_name"`
+		FormatLongName string            `json:"format_long_name"`
+		Duration       string            `json:"duration"`
+		Size           string            `json:"size"`
+		BitRate        string            `json:"bit_rate"`
+		Tags           map[string]string `json:"tags"`
+	} `json:"format"`
+	Streams []struct {
+		Index          int               `json:"index"`
+		CodecType      string            `json:"codec_type"`
+		CodecName      string            `json:"codec_name"`
+		CodecLongName  string            `json:"codec_long_name"`
+		Profile        string            `json:"profile"`
+		BitRate        string            `json:"bit_rate"`
+		Duration       string            `json:"duration"`
+		Width          int               `json:"width"`
+		Height         int               `json:"height"`
+		AvgFrameRate   string            `json:"avg_frame_rate"`
+		RFrameRate     string            `json:"r_frame_rate"`
+		PixFmt         string            `json:"pix_fmt"`
+		ColorTransfer  string            `json:"color_transfer"`
+		ColorPrimaries string            `json:"color_primaries"`
+		Channels       int               `json:"channels"`
+		ChannelLayout  string            `json:"channel_layout"`
+		SampleRate     string            `json:"sample_rate"`
+		Disposition    map[string]int    `json:"disposition"`
+		Tags           map[string]string `json:"tags"`
+		SideDataList   []struct {
+			SideDataType string  `json:"side_data_type"`
+			Rotation     float64 `json:"rotation"`
+		} `json:"side_data_list"`
+	} `json:"streams"`
+}
+
+// Probe runs ffprobe on path and returns its streams and container info.
+func Probe(ctx context.Context, path string) (*MediaInfo, error) {
+	out, errStr, err := execx.RunContext(ctx, "ffprobe", "-v", "error", "-print_format", "json", "-show_streams", "-show_format", path)
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe: %v: %s", err, strings.TrimSpace(errStr))
+	}
+	return parseProbe([]byte(out))
+}
+
+func parseProbe(b []byte) (*MediaInfo, error) {
+	var raw probeOutput
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, fmt.Errorf("ffprobe: invalid output: %w", err)
+	}
+	m := &MediaInfo{
+		FormatName: raw.Format.FormatName,
+		FormatLong: raw.Format.FormatLongName,
+		Duration:   parseFloat(raw.Format.Duration),
+		Size:       parseInt(raw.Format.Size),
+		BitRate:    parseInt(raw.Format.BitRate),
+		Title:      raw.Format.Tags["title"],
+	}
+	for _, s := range raw.Streams {
+		si := StreamInfo{
+			Index:          s.Index,
+			Type:           s.CodecType,
+			Codec:          s.CodecName,
+			CodecLong:      s.CodecLongName,
+			Profile:        s.Profile,
+			BitRate:        parseInt(s.BitRate),
+			Duration:       parseFloat(s.Duration),
+			Language:       s.Tags["language"],
+			Title:          s.Tags["title"],
+			Default:        s.Disposition["default"] == 1,
+			Width:          s.Width,
+			Height:         s.Height,
+			PixFmt:         s.PixFmt,
+			ColorTransfer:  s.ColorTransfer,
+			ColorPrimaries: s.ColorPrimaries,
+			AttachedPic:    s.Disposition["attached_pic"] == 1,
+			Channels:       s.Channels,
+			ChannelLayout:  s.ChannelLayout,
+			SampleRate:     int(parseInt(s.SampleRate)),
+		}
+		if s.CodecType == "video" {
+			si.FPS = parseRatio(s.AvgFrameRate)
+			if si.FPS == 0 {
+				si.FPS = parseRatio(s.RFrameRate)
+			}
+			// PQ (HDR10/Dolby Vision) and HLG transfer functions
+			si.HDR = s.ColorTransfer == "smpte2084" || s.ColorTransfer == "arib-std-b67"
+			if v, err := strconv.Atoi(s.Tags["rotate"]); err == nil {
+				si.Rotation = v
+			}
+			for _, sd := range s.SideDataList {
+				if sd.SideDataType == "Display Matrix" && sd.Rotation != 0 {
+					si.Rotation = int(sd.Rotation)
+				}
+			}
+		}
+		m.Streams = append(m.Streams, si)
+	}
+	// some containers (raw streams, fragmented files) only report per-stream durations
+	if m.Duration <= 0 {
+		for _, s := range m.Streams {
+			if s.Duration > m.Duration {
+				m.Duration = s.Duration
+			}
+		}
+	}
+	return m, nil
+}
+
+func parseFloat(s string) float64 {
+	v, _ := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	return v
+}
+
+func parseInt(s string) int64 {
+	v, _ := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	return v
+}
+
+// parseRatio parses ffprobe frame rates such as "30000/1001".
+func parseRatio(s string) float64 {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "N/A" {
+		return 0
+	}
+	if num, den, ok := strings.Cut(s, "/"); ok {
+		n, _ := strconv.ParseFloat(num, 64)
+		d, _ := strconv.ParseFloat(den, 64)
+		if d != 0 {
+			return n / d
+		}
+		return 0
+	}
+	v, _ := strconv.ParseFloat(s, 64)
+	return v
+}
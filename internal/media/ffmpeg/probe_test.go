@@ -0,0 +1,53 @@
+package ffmpeg
+
+import "testing"
+
+const probeJSON = `{
+  "format": {"format_name": "mov,mp4,m4a,3gp,3g2,mj2", "duration": "N/A", "size": "1048576", "bit_rate": "800000", "tags": {"title": "Clip"}},
+  "streams": [
+    {"index": 0, "codec_type": "video", "codec_name": "mjpeg", "width": 300, "height": 300, "disposition": {"attached_pic": 1}},
+    {"index": 1, "codec_type": "video", "codec_name": "hevc", "width": 3840, "height": 2160, "duration": "12.5",
+     "avg_frame_rate": "0/0", "r_frame_rate": "30000/1001", "color_transfer": "smpte2084",
+     "side_data_list": [{"side_data_type": "Display Matrix", "rotation": -90}], "disposition": {"default": 1}},
+    {"index": 2, "codec_type": "audio", "codec_name": "aac", "channels": 2, "sample_rate": "48000", "duration": "12.48", "tags": {"language": "eng"}},
+    {"index": 3, "codec_type": "subtitle", "codec_name": "mov_text"}
+  ]
+}`
+
+func TestParseProbe(t *testing.T) {
+	m, err := parseProbe([]byte(probeJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Size != 1<<20 || m.BitRate != 800000 || m.Title != "Clip" {
+		t.Errorf("format = %+v", m)
+	}
+	// the container has no duration, so the longest stream's is used
+	if m.Duration != 12.5 {
+		t.Errorf("Duration = %v, want 12.5", m.Duration)
+	}
+	v := m.Video()
+	if v == nil || v.Codec != "hevc" {
+		t.Fatalf("Video = %+v, want the hevc stream rather than the cover", v)
+	}
+	if v.FPS < 29.97 || v.FPS > 29.98 || !v.HDR || v.Rotation != -90 || !v.Default {
+		t.Errorf("video = %+v", v)
+	}
+	if a := m.Audio(); a == nil || a.SampleRate != 48000 || a.Language != "eng" {
+		t.Errorf("Audio = %+v", a)
+	}
+	if subs := m.Subtitles(); len(subs) != 1 || subs[0].Codec != "mov_text" {
+		t.Errorf("Subtitles = %+v", subs)
+	}
+	if _, err := parseProbe([]byte("not json")); err == nil {
+		t.Error("invalid output accepted")
+	}
+}
+
+func TestParseRatio(t *testing.T) {
+	for in, want := range map[string]float64{"25/1": 25, "24": 24, "0/0": 0, "N/A": 0, "": 0} {
+		if got := parseRatio(in); got != want {
+			t.Errorf("parseRatio(%q) = %v, want %v", in, got, want)
+		}
+	}
+}
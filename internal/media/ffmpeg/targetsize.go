@@ -0,0 +1,119 @@
+package ffmpeg
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"comp/internal/store"
+)
+
+// minVideoKbps is the lowest video bitrate we are willing to encode at;
+// below it the result is unwatchable and the request is rejected instead.
+const minVideoKbps = 50
+
+// targetBitrates splits the bit budget for targetBytes over dur seconds into
+// video and audio kbps. A small share is reserved for container overhead.
+func targetBitrates(targetBytes int64, dur float64) (videoKbps, audioKbps int, err error) {
+	totalKbps := float64(targetBytes) * 8 / dur / 1000 * 0.97
+	switch {
+	case totalKbps >= 1000:
+		audioKbps = 128
+	case totalKbps >= 400:
+		audioKbps = 96
+	case totalKbps >= 200:
+		audioKbps = 64
+	default:
+		audioKbps = 32
+	}
+	videoKbps = int(totalKbps) - audioKbps
+	if videoKbps < minVideoKbps {
+		return 0, 0, fmt.Errorf("target size too small for %.0fs of video", dur)
+	}
+	return videoKbps, audioKbps, nil
+}
+
+// TargetSize encodes input with two-pass H.264 so the output fits into
+// targetMB megabytes. If the result still overshoots, it retries at a lower
+// bitrate up to twice (see fitTarget).
+func (r *Runner) TargetSize(ctx context.Context, taskID, input, output string, targetMB float64, maxWidth, fps int) error {
+	if targetMB <= 0 {
+		return fmt.Errorf("target size must be positive")
+	}
+	dur, err := r.ffprobeDurationSeconds(ctx, input)
+	if err != nil {
+		return err
+	}
+	targetBytes := int64(targetMB * 1024 * 1024)
+	videoKbps, audioKbps, err := targetBitrates(targetBytes, dur)
+	if err != nil {
+		return err
+	}
+	filters := r.scaleFPSArgs(ctx, input, maxWidth, fps)
+	passLog := filepath.Join(filepath.Dir(output), "ffmpeg2pass")
+	defer func() {
+		matches, _ := filepath.Glob(passLog + "*")
+		for _, m := range matches {
+			_ = os.Remove(m)
+		}
+	}()
+	err = r.fitTarget(taskID, targetMB, videoKbps, audioKbps, func(videoKbps int, sp span) (int64, error) {
+		common := append([]string{"-i", input}, filters...)
+		common = append(common, "-c:v", "libx264", "-preset", "medium", "-b:v", strconv.Itoa(videoKbps)+"k", "-pix_fmt", "yuv420p", "-passlogfile", passLog)
+		mid := (sp.From + sp.To) / 2
+		pass1 := append(append([]string{}, common...), "-pass", "1", "-an", "-f", "null", os.DevNull)
+		if err := r.runSpan(ctx, taskID, pass1, input, span{Op: sp.Op, Stage: sp.Stage, From: sp.From, To: mid}); err != nil {
+			return 0, err
+		}
+		pass2 := append(append([]string{}, common...), "-pass", "2", "-c:a", "aac", "-b:a", strconv.Itoa(audioKbps)+"k", "-movflags", "+faststart", output)
+		if err := r.runSpan(ctx, taskID, pass2, input, span{Op: sp.Op, Stage: sp.Stage, From: mid, To: sp.To}); err != nil {
+			return 0, err
+		}
+		fi, err := os.Stat(output)
+		if err != nil {
+			return 0, err
+		}
+		return fi.Size(), nil
+	})
+	if err != nil {
+		return err
+	}
+	// the attempts leave the last percent to the one that fits
+	_ = r.Store.Set(ctx, &store.TaskStatus{ID: taskID, Status: "processing", Stage: "transcode", Percent: 100}, 30*time.Minute)
+	return nil
+}
+
+// attemptSpans divides the progress of the transcode stage between the
+// encode attempts: the first takes most of it and each retry a share of
+// the rest, so progress keeps moving forward when a retry is needed.
+var attemptSpans = [][2]int{{0, 90}, {90, 96}, {96, 99}}
+
+// fitTarget runs encode until the output fits into targetMB, lowering the
+// video bitrate by the overshoot after each try, for at most
+// len(attemptSpans) tries.
+func (r *Runner) fitTarget(taskID string, targetMB float64, videoKbps, audioKbps int, encode func(videoKbps int, sp span) (int64, error)) error {
+	targetBytes := int64(targetMB * 1024 * 1024)
+	for attempt, bounds := range attemptSpans {
+		size, err := encode(videoKbps, span{Op: "target_size", Stage: "transcode", From: bounds[0], To: bounds[1]})
+		if err != nil {
+			return err
+		}
+		if size <= targetBytes {
+			return nil
+		}
+		// scale the video budget by the overshoot, with a bit of headroom
+		ratio := float64(targetBytes) / float64(size)
+		next := int(float64(videoKbps+audioKbps)*ratio*0.95) - audioKbps
+		if next < minVideoKbps || attempt == len(attemptSpans)-1 {
+			break
+		}
+		if r.Logger != nil {
+			r.Logger.Infof("[%s] output %d bytes exceeds target %d, retrying at %dk", taskID, size, targetBytes, next)
+		}
+		videoKbps = next
+	}
+	return fmt.Errorf("could not fit output into %.1f MB", targetMB)
+}
@@ -0,0 +1,97 @@
+package ffmpeg
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestTargetBitrates(t *testing.T) {
+	tests := []struct {
+		name         string
+		bytes        int64
+		dur          float64
+		video, audio int
+		ok           bool
+	}{
+		{"128k audio", 10 << 20, 60, 1228, 128, true},
+		{"96k audio", 3 << 20, 60, 310, 96, true},
+		{"64k audio", 2 << 20, 60, 207, 64, true},
+		{"32k audio", 1 << 20, 60, 103, 32, true},
+		// 1020 kbps before the 3% container overhead, 989 after
+		{"overhead reserved", 1_020_000, 8, 893, 96, true},
+		{"at the floor", 106_315, 10, minVideoKbps, 32, true},
+		{"below the floor", 105_026, 10, 0, 0, false},
+		{"too long", 512 << 10, 60, 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			video, audio, err := targetBitrates(tt.bytes, tt.dur)
+			if (err == nil) != tt.ok || video != tt.video || audio != tt.audio {
+				t.Errorf("targetBitrates(%d, %g) = %d, %d, %v; want %d, %d, ok=%v", tt.bytes, tt.dur, video, audio, err, tt.video, tt.audio, tt.ok)
+			}
+		})
+	}
+}
+
+func TestFitTarget(t *testing.T) {
+	const mb = 1 << 20
+	tests := []struct {
+		name string
+		// sizes are the outputs of the successive attempts
+		sizes   []int64
+		kbps    []int
+		retries int
+		ok      bool
+	}{
+		{"fits at once", []int64{mb}, []int{900}, 0, true},
+		// 1000k total * (1/1.25) * 0.95 = 760k, minus 100k audio
+		{"fits on retry", []int64{mb * 5 / 4, mb}, []int{900, 660}, 1, true},
+		{"never fits", []int64{2 * mb, 2 * mb, 2 * mb}, []int{900, 375, 125}, 2, false},
+		// 1000k * 0.1 * 0.95 - 100k is below the floor, so there is no retry
+		{"hopeless overshoot", []int64{10 * mb}, []int{900}, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			core, logs := observer.New(zap.InfoLevel)
+			r := &Runner{Logger: zap.New(core).Sugar()}
+			var kbps []int
+			var spans []span
+			err := r.fitTarget("t1", 1, 900, 100, func(videoKbps int, sp span) (int64, error) {
+				kbps = append(kbps, videoKbps)
+				spans = append(spans, sp)
+				return tt.sizes[len(kbps)-1], nil
+			})
+			if (err == nil) != tt.ok {
+				t.Errorf("fitTarget = %v, want ok=%v", err, tt.ok)
+			}
+			if !slices.Equal(kbps, tt.kbps) {
+				t.Errorf("video bitrates = %v, want %v", kbps, tt.kbps)
+			}
+			// only a retry that actually follows is announced
+			if n := logs.FilterMessageSnippet("retrying").Len(); n != tt.retries {
+				t.Errorf("logged %d retries, want %d", n, tt.retries)
+			}
+			for i, sp := range spans {
+				if sp.Stage != "transcode" || sp.From >= sp.To || (i > 0 && sp.From < spans[i-1].To) {
+					t.Errorf("attempt %d spans %+v after %+v; progress must keep moving forward in one stage", i, sp, spans[max(i-1, 0)])
+				}
+			}
+		})
+	}
+}
+
+func TestFitTargetStopsOnError(t *testing.T) {
+	boom := errors.New("boom")
+	calls := 0
+	err := (&Runner{}).fitTarget("t1", 1, 900, 100, func(int, span) (int64, error) {
+		calls++
+		return 0, boom
+	})
+	if !errors.Is(err, boom) || calls != 1 {
+		t.Errorf("fitTarget = %v after %d calls, want boom after 1", err, calls)
+	}
+}
@@ -0,0 +1,41 @@
+package yt
+
+import (
+	"regexp"
+	"strings"
+
+	"comp/internal/execx"
+)
+
+// errorRules turn yt-dlp's stderr into the reason shown on a failed task.
+var errorRules = []execx.Rule{
+	execx.NewRule(`HTTP Error 403`, "access denied by the source (HTTP 403)"),
+	execx.NewRule(`HTTP Error 404`, "not found at the source (HTTP 404)"),
+	execx.NewRule(`HTTP Error 429`, "rate limited by the source (HTTP 429)"),
+	execx.NewRule(`HTTP Error (5\d\d)`, "source server error (HTTP $1)"),
+	execx.NewRule(`(?i)not (?:made )?available in your (?:country|location)|geo.?restrict`, "video is not available in this region (geo-blocked)"),
+	execx.NewRule(`(?i)private video|video is private`, "video is private"),
+	execx.NewRule(`(?i)sign in to confirm your age|age.?restricted`, "video is age-restricted"),
+	execx.NewRule(`(?i)members.only|join this channel`, "video is for channel members only"),
+	execx.NewRule(`(?i)sign in to confirm you.re not a bot`, "source requires sign-in (bot check)"),
+	execx.NewRule(`(?i)this live event will begin|premieres in`, "live stream or premiere has not started yet"),
+	execx.NewRule(`Requested format is not available`, "requested format is not available"),
+	execx.NewRule(`Unsupported URL`, "unsupported URL"),
+	execx.NewRule(`(?i)video unavailable|has been removed`, "video is unavailable"),
+	execx.NewRule(`(?i)name or service not known|failed to resolve|temporary failure in name resolution|connection refused|timed out`, "could not reach the source"),
+	execx.NewRule(`No space left on device`, "no space left on device"),
+}
+
+// extractorPrefix matches "ERROR: [youtube] dQw4w9WgXcQ: " style prefixes.
+var extractorPrefix = regexp.MustCompile(`^ERROR: (?:\[[^\]]+\] (?:[^:]+: )?)?`)
+
+// failure wraps err with a reason read from yt-dlp's stderr.
+func failure(stderr string, err error) error {
+	reason := execx.Reason(stderr, errorRules)
+	if reason == "" {
+		reason = extractorPrefix.ReplaceAllString(execx.LastLine(stderr, func(l string) bool {
+			return strings.HasPrefix(l, "ERROR:")
+		}), "")
+	}
+	return &execx.Error{Reason: reason, Err: err}
+}
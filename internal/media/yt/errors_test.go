@@ -0,0 +1,33 @@
+package yt
+
+import (
+	"errors"
+	"testing"
+
+	"comp/internal/execx"
+)
+
+func TestFailure(t *testing.T) {
+	exit := errors.New("exit status 1")
+	tests := []struct {
+		stderr string
+		want   string
+	}{
+		{"[youtube] abc: Downloading webpage\nERROR: [youtube] abc: Private video. Sign in if you've been granted access\n", "video is private"},
+		{"ERROR: unable to download video data: HTTP Error 503: Service Unavailable\n", "source server error (HTTP 503)"},
+		{"ERROR: [generic] Unsupported URL: https://example.com/\n", "unsupported URL"},
+		// unknown errors show yt-dlp's message without the extractor prefix
+		{"WARNING: something\nERROR: [vimeo] 12345: Something odd happened\n[debug] trailing\n", "Something odd happened"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		err := failure(tt.stderr, exit)
+		var xe *execx.Error
+		if !errors.As(err, &xe) || !errors.Is(err, exit) {
+			t.Fatalf("failure(%q) = %v, want an execx.Error wrapping the exit error", tt.stderr, err)
+		}
+		if xe.Reason != tt.want {
+			t.Errorf("failure(%q) reason = %q, want %q", tt.stderr, xe.Reason, tt.want)
+		}
+	}
+}
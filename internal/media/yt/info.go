@@ -0,0 +1,162 @@
+package yt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"comp/internal/execx"
+)
+
+// Info is the metadata of a single video as shown before downloading it.
+// The top-level fields describe the format yt-dlp would pick by default.
+type Info struct {
+	Title    string   `json:"title"`
+	Ext      string   `json:"ext"`
+	Format   string   `json:"format"`
+	Filesize float64  `json:"filesize"`
+	Duration float64  `json:"duration"`
+	Width    float64  `json:"width"`
+	Height   float64  `json:"height"`
+	FPS      float64  `json:"fps"`
+	Bitrate  float64  `json:"bitrate"`
+	Formats  []Format `json:"formats"`
+}
+
+// Format is one of the downloadable formats of a video. Its ID can be sent
+// back as format_id; video-only formats need "+bestaudio" to keep sound.
+type Format struct {
+	ID        string  `json:"format_id"`
+	Ext       string  `json:"ext"`
+	Note      string  `json:"note,omitempty"`
+	Width     int     `json:"width,omitempty"`
+	Height    int     `json:"height,omitempty"`
+	FPS       float64 `json:"fps,omitempty"`
+	VCodec    string  `json:"vcodec,omitempty"`
+	ACodec    string  `json:"acodec,omitempty"`
+	Filesize  float64 `json:"filesize,omitempty"`
+	Bitrate   float64 `json:"bitrate,omitempty"`
+	AudioOnly bool    `json:"audio_only,omitempty"`
+	VideoOnly bool    `json:"video_only,omitempty"`
+}
+
+type formatJSON struct {
+	FormatID       string  `json:"format_id"`
+	Format         string  `json:"format"`
+	FormatNote     string  `json:"format_note"`
+	Ext            string  `json:"ext"`
+	Width          float64 `json:"width"`
+	Height         float64 `json:"height"`
+	FPS            float64 `json:"fps"`
+	VCodec         string  `json:"vcodec"`
+	ACodec         string  `json:"acodec"`
+	Filesize       float64 `json:"filesize"`
+	FilesizeApprox float64 `json:"filesize_approx"`
+	TBR            float64 `json:"tbr"`
+}
+
+type infoJSON struct {
+	formatJSON
+	Title    string            `json:"title"`
+	Duration float64           `json:"duration"`
+	Formats  []formatJSON      `json:"formats"`
+	Entries  []json.RawMessage `json:"entries"`
+}
+
+// GetInfo asks yt-dlp about url without downloading it. For a playlist URL
+// it describes the first entry.
+func GetInfo(ctx context.Context, url, proxy string) (*Info, error) {
+	args := []string{"--dump-single-json", "--no-playlist", "--skip-download", url}
+	if strings.TrimSpace(proxy) != "" {
+		args = append([]string{"--proxy", proxy}, args...)
+	}
+	out, stderr, err := execx.RunContext(ctx, binaryPath(), args...)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		return nil, failure(stderr, err)
+	}
+	var raw infoJSON
+	if err := json.Unmarshal([]byte(out), &raw); err != nil {
+		return nil, fmt.Errorf("invalid info format: %w", err)
+	}
+	// pick first entry if playlist wrapper
+	if len(raw.Entries) > 0 {
+		first := raw.Entries[0]
+		raw = infoJSON{}
+		if err := json.Unmarshal(first, &raw); err != nil {
+			return nil, fmt.Errorf("invalid info format: %w", err)
+		}
+	}
+	info := &Info{
+		Title:    raw.Title,
+		Ext:      raw.Ext,
+		Format:   raw.Format,
+		Filesize: raw.Filesize,
+		Duration: raw.Duration,
+		Width:    raw.Width,
+		Height:   raw.Height,
+		FPS:      raw.FPS,
+		Bitrate:  raw.TBR,
+		Formats:  []Format{},
+	}
+	if info.Format == "" {
+		info.Format = raw.FormatID
+	}
+	if info.Filesize == 0 {
+		info.Filesize = raw.FilesizeApprox
+	}
+	for _, f := range raw.Formats {
+		hasVideo := f.VCodec != "" && f.VCodec != "none"
+		hasAudio := f.ACodec != "" && f.ACodec != "none"
+		// storyboards and other image-only entries
+		if f.FormatID == "" || f.Ext == "mhtml" || (!hasVideo && !hasAudio && f.Height == 0) {
+			continue
+		}
+		size := f.Filesize
+		if size == 0 {
+			size = f.FilesizeApprox
+		}
+		info.Formats = append(info.Formats, Format{
+			ID:        f.FormatID,
+			Ext:       f.Ext,
+			Note:      f.FormatNote,
+			Width:     int(f.Width),
+			Height:    int(f.Height),
+			FPS:       f.FPS,
+			VCodec:    strings.TrimPrefix(f.VCodec, "none"),
+			ACodec:    strings.TrimPrefix(f.ACodec, "none"),
+			Filesize:  size,
+			Bitrate:   f.TBR,
+			AudioOnly: hasAudio && !hasVideo && f.Height == 0,
+			VideoOnly: hasVideo && !hasAudio,
+		})
+	}
+	return info, nil
+}
+
+// formatIDRe limits format_id to what yt-dlp format IDs and simple merges
+// such as "137+140" or "137+bestaudio" are made of.
+var formatIDRe = regexp.MustCompile(`^[A-Za-z0-9_.-]+(\+[A-Za-z0-9_.-]+)?$`)
+
+// FormatSelector builds the -f argument for a download: formatID as given
+// (falling back to the default if it went away), otherwise the best
+// formats no taller than maxHeight, or just the audio when audioOnly.
+// It returns "" for yt-dlp's default.
+func FormatSelector(formatID string, maxHeight int, audioOnly bool) (string, error) {
+	switch {
+	case formatID != "":
+		if !formatIDRe.MatchString(formatID) {
+			return "", fmt.Errorf("invalid format_id %q", formatID)
+		}
+		return formatID + "/b", nil
+	case audioOnly:
+		return "ba/b", nil
+	case maxHeight > 0:
+		return fmt.Sprintf("bv*[height<=%d]+ba/b[height<=%d]/b", maxHeight, maxHeight), nil
+	}
+	return "", nil
+}
@@ -0,0 +1,28 @@
+package yt
+
+import "testing"
+
+func TestFormatSelector(t *testing.T) {
+	tests := []struct {
+		id        string
+		maxHeight int
+		audio     bool
+		want      string
+		ok        bool
+	}{
+		{"", 0, false, "", true},
+		{"137+140", 720, false, "137+140/b", true},
+		{"hls-1080p", 0, false, "hls-1080p/b", true},
+		{"", 720, false, "bv*[height<=720]+ba/b[height<=720]/b", true},
+		{"", 720, true, "ba/b", true},
+		{"137+140+251", 0, false, "", false},
+		{"best; rm -rf", 0, false, "", false},
+		{"bv*[height<=720]", 0, false, "", false},
+	}
+	for _, tt := range tests {
+		got, err := FormatSelector(tt.id, tt.maxHeight, tt.audio)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("FormatSelector(%q, %d, %v) = %q, %v; want %q, ok=%v", tt.id, tt.maxHeight, tt.audio, got, err, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package yt
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Metadata is what a download with Download.Metadata tells about the video.
+type Metadata struct {
+	Title  string
+	Artist string
+	Album  string
+	// Date is the release or upload year.
+	Date string
+	// Thumbnail is the path of the JPEG thumbnail, empty if there is none.
+	Thumbnail string
+}
+
+// ReadMetadata reads the info JSON and thumbnail that yt-dlp wrote next to
+// the downloaded file at path. Music sites fill artist and album; for other
+// videos the uploader stands in for the artist.
+func ReadMetadata(path string) (*Metadata, error) {
+	base := strings.TrimSuffix(path, filepath.Ext(path))
+	b, err := os.ReadFile(base + ".info.json")
+	if err != nil {
+		return nil, err
+	}
+	var raw struct {
+		Title       string `json:"title"`
+		Track       string `json:"track"`
+		Artist      string `json:"artist"`
+		Creator     string `json:"creator"`
+		Uploader    string `json:"uploader"`
+		Channel     string `json:"channel"`
+		Album       string `json:"album"`
+		ReleaseYear int    `json:"release_year"`
+		UploadDate  string `json:"upload_date"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, err
+	}
+	m := &Metadata{
+		Title:  first(raw.Track, raw.Title),
+		Artist: first(raw.Artist, raw.Creator, raw.Uploader, raw.Channel),
+		Album:  raw.Album,
+	}
+	if raw.ReleaseYear > 0 {
+		m.Date = strconv.Itoa(raw.ReleaseYear)
+	} else if len(raw.UploadDate) >= 4 {
+		m.Date = raw.UploadDate[:4]
+	}
+	if _, err := os.Stat(base + ".jpg"); err == nil {
+		m.Thumbnail = base + ".jpg"
+	}
+	return m, nil
+}
+
+func first(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
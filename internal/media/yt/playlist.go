@@ -0,0 +1,80 @@
+package yt
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"comp/internal/execx"
+)
+
+// Entry is one video of an expanded playlist.
+type Entry struct {
+	URL      string  `json:"url"`
+	Title    string  `json:"title,omitempty"`
+	Duration float64 `json:"duration,omitempty"`
+}
+
+// Playlist is what Expand found behind a URL.
+type Playlist struct {
+	Title   string  `json:"title"`
+	Entries []Entry `json:"entries"`
+}
+
+// Expand lists the videos behind url without downloading them. A playlist
+// or channel yields its entries; a single video yields itself.
+func Expand(ctx context.Context, url, proxy string) (*Playlist, error) {
+	args := []string{"--flat-playlist", "--dump-single-json", url}
+	if strings.TrimSpace(proxy) != "" {
+		args = append([]string{"--proxy", proxy}, args...)
+	}
+	out, stderr, err := execx.RunContext(ctx, binaryPath(), args...)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		return nil, failure(stderr, err)
+	}
+	var raw struct {
+		entryJSON
+		Entries []entryJSON `json:"entries"`
+	}
+	if err := json.Unmarshal([]byte(out), &raw); err != nil {
+		return nil, err
+	}
+	pl := &Playlist{Title: raw.Title, Entries: []Entry{}}
+	if raw.Entries == nil {
+		e := raw.entry()
+		if e.URL == "" {
+			e.URL = url
+		}
+		pl.Entries = append(pl.Entries, e)
+		return pl, nil
+	}
+	for _, r := range raw.Entries {
+		// nested playlists (channel tabs) are not expanded further
+		if e := r.entry(); e.URL != "" && r.Type != "playlist" {
+			pl.Entries = append(pl.Entries, e)
+		}
+	}
+	return pl, nil
+}
+
+type entryJSON struct {
+	Type       string  `json:"_type"`
+	URL        string  `json:"url"`
+	WebpageURL string  `json:"webpage_url"`
+	Title      string  `json:"title"`
+	Duration   float64 `json:"duration"`
+}
+
+func (r entryJSON) entry() Entry {
+	u := r.WebpageURL
+	if !strings.HasPrefix(u, "http") {
+		u = r.URL
+	}
+	if !strings.HasPrefix(u, "http") {
+		u = ""
+	}
+	return Entry{URL: u, Title: r.Title, Duration: r.Duration}
+}
@@ -13,6 +13,8 @@ import (
 
 	"go.uber.org/zap"
 
+	"comp/internal/execx"
+	"comp/internal/metrics"
 	"comp/internal/store"
 )
 
@@ -24,28 +26,62 @@ func binaryPath() string {
 	return "yt-dlp"
 }
 
-// DownloadWithProgress downloads URL into jobDir using yt-dlp and updates Store with download stage percent.
+// Download describes what DownloadWithProgress fetches.
+type Download struct {
+	URL   string
+	Proxy string
+	// Dir receives the file, named after the video title.
+	Dir string
+	// Format is passed as -f unless empty (see FormatSelector).
+	Format string
+	// Section ("*start-end") downloads only that range, cut at exact frames.
+	Section string
+	// Metadata also keeps the info JSON and a JPEG thumbnail next to the
+	// file, for ReadMetadata.
+	Metadata bool
+}
+
+// DownloadWithProgress downloads d.URL into d.Dir using yt-dlp and updates Store with download stage percent.
 // Returns the downloaded file path.
-func DownloadWithProgress(ctx context.Context, st store.Store, log *zap.SugaredLogger, taskID, url, proxy, jobDir string) (string, error) {
+func DownloadWithProgress(ctx context.Context, st store.Store, log *zap.SugaredLogger, taskID string, d Download) (string, error) {
 	bin := binaryPath()
-	outputPattern := filepath.Join(jobDir, "%(title)s.%(ext)s")
+	url, proxy := d.URL, d.Proxy
+	outputPattern := filepath.Join(d.Dir, "%(title)s.%(ext)s")
+	var selectArgs []string
+	if d.Format != "" {
+		selectArgs = []string{"-f", d.Format}
+	}
+	if d.Section != "" {
+		selectArgs = append(selectArgs, "--download-sections", d.Section, "--force-keyframes-at-cuts")
+	}
 	// First, resolve future file name
-	argsName := []string{"--get-filename", "-o", outputPattern, "--restrict-filenames", url}
+	argsName := append([]string{"--get-filename", "-o", outputPattern, "--restrict-filenames", "--no-playlist"}, selectArgs...)
+	argsName = append(argsName, url)
 	if strings.TrimSpace(proxy) != "" {
 		argsName = append([]string{"--proxy", proxy}, argsName...)
 	}
 	if log != nil {
 		log.Infof("yt-dlp get-filename: %s %v", bin, argsName)
 	}
-	cmdName := exec.CommandContext(ctx, bin, argsName...)
+	cmdName := execx.Command(ctx, bin, argsName...)
+	stderr := &execx.Tail{}
+	cmdName.Stderr = stderr
+	end := execx.Span(ctx, cmdName)
+	done := metrics.Track("yt-dlp")
 	b, err := cmdName.Output()
+	done()
+	end(err)
 	if err != nil {
-		return "", err
+		return "", downloadFailed(ctx, st, taskID, cmdName, stderr, err)
 	}
 	filename := strings.TrimSpace(string(b))
 
 	// Now download with progress
-	args := []string{"-o", outputPattern, "--restrict-filenames", "--newline", url}
+	args := append([]string{"-o", outputPattern, "--restrict-filenames", "--newline", "--no-playlist"}, selectArgs...)
+	if d.Metadata {
+		args = append(args, "--write-info-json", "--write-thumbnail", "--convert-thumbnails", "jpg")
+	}
+	args = append(args, url)
 	if strings.TrimSpace(proxy) != "" {
 		args = append([]string{"--proxy", proxy}, args...)
 	}
@@ -53,14 +89,20 @@ func DownloadWithProgress(ctx context.Context, st store.Store, log *zap.SugaredL
 		log.Infof("yt-dlp download: %s %v", bin, args)
 	}
 	_ = st.Set(ctx, &store.TaskStatus{ID: taskID, Status: "processing", Stage: "download", Percent: 0}, 30*time.Minute)
-	cmd := exec.CommandContext(ctx, bin, args...)
+	cmd := execx.Command(ctx, bin, args...)
 	stdout, _ := cmd.StdoutPipe()
-	stderr, _ := cmd.StderrPipe()
+	stderr = &execx.Tail{}
+	cmd.Stderr = stderr
+	end = execx.Span(ctx, cmd)
 	if err := cmd.Start(); err != nil {
+		end(err)
 		return "", err
 	}
-	re := regexp.MustCompile(`(?i)(\d{1,3}\.\d+)%`) // 12.3%
+	done = metrics.Track("yt-dlp")
+	re := regexp.MustCompile(`(?i)(\d{1,3}\.\d+)%`)           // 12.3%
+	etaRe := regexp.MustCompile(`ETA (?:(\d+):)?(\d+):(\d+)`) // ETA 01:02:03 or 02:03
 	go func() {
+		lastPct := -1
 		r := bufio.NewScanner(stdout)
 		for r.Scan() {
 			line := r.Text()
@@ -74,15 +116,46 @@ func DownloadWithProgress(ctx context.Context, st store.Store, log *zap.SugaredL
 					if pct < 0 {
 						pct = 0
 					}
-					_ = st.Set(ctx, &store.TaskStatus{ID: taskID, Status: "processing", Stage: "download", Percent: pct}, 30*time.Minute)
+					if pct != lastPct {
+						lastPct = pct
+						_ = st.Set(ctx, &store.TaskStatus{ID: taskID, Status: "processing", Stage: "download", Percent: pct, ETASeconds: parseETA(etaRe, line)}, 30*time.Minute)
+					}
 				}
 			}
 		}
 	}()
-	go func() { _ = bufio.NewScanner(stderr).Err() }()
-	if err := cmd.Wait(); err != nil {
-		return "", err
+	err = cmd.Wait()
+	done()
+	end(err)
+	if err != nil {
+		return "", downloadFailed(ctx, st, taskID, cmd, stderr, err)
+	}
+	// warnings are worth keeping even when the download worked
+	if out := stderr.String(); out != "" {
+		_ = st.AppendLog(ctx, taskID, execx.Transcript(cmd, out, nil), 30*time.Minute)
 	}
 	_ = st.Set(ctx, &store.TaskStatus{ID: taskID, Status: "processing", Stage: "download", Percent: 100}, 30*time.Minute)
 	return filename, nil
 }
+
+// downloadFailed logs a failed yt-dlp run to the task log and returns the
+// error with a reason taken from its stderr.
+func downloadFailed(ctx context.Context, st store.Store, taskID string, cmd *exec.Cmd, stderr *execx.Tail, err error) error {
+	_ = st.AppendLog(context.WithoutCancel(ctx), taskID, execx.Transcript(cmd, stderr.String(), err), 30*time.Minute)
+	if ctx.Err() != nil {
+		return err
+	}
+	metrics.DownloadFailures.Inc()
+	return failure(stderr.String(), err)
+}
+
+func parseETA(re *regexp.Regexp, line string) float64 {
+	m := re.FindStringSubmatch(line)
+	if m == nil {
+		return 0
+	}
+	h, _ := strconv.Atoi(m[1])
+	mins, _ := strconv.Atoi(m[2])
+	sec, _ := strconv.Atoi(m[3])
+	return float64(h*3600 + mins*60 + sec)
+}
@@ -0,0 +1,115 @@
+// Package metrics holds the Prometheus collectors exported on /metrics.
+package metrics
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	// JobsTotal counts finished jobs by processing type and final status.
+	JobsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "comp_jobs_total",
+		Help: "Finished jobs by type and final status.",
+	}, []string{"type", "status"})
+
+	// ActiveProcesses is the number of running external tools (ffmpeg, yt-dlp).
+	ActiveProcesses = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "comp_active_processes",
+		Help: "Running ffmpeg/yt-dlp processes.",
+	}, []string{"binary"})
+
+	// TranscodeSeconds times each ffmpeg run.
+	TranscodeSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "comp_transcode_duration_seconds",
+		Help:    "Wall time of ffmpeg runs by operation and outcome.",
+		Buckets: []float64{1, 5, 15, 30, 60, 120, 300, 600, 1200, 2400, 3600},
+	}, []string{"op", "result"})
+
+	BytesIn = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "comp_input_bytes_total",
+		Help: "Bytes of source media processed, by type.",
+	}, []string{"type"})
+	BytesOut = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "comp_output_bytes_total",
+		Help: "Bytes of output media produced, by type.",
+	}, []string{"type"})
+
+	// CompressionRatio observes input/output size of completed jobs.
+	CompressionRatio = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "comp_compression_ratio",
+		Help:    "Input size divided by output size of completed jobs.",
+		Buckets: []float64{0.5, 1, 1.5, 2, 3, 5, 10, 20, 50},
+	}, []string{"type"})
+
+	DownloadFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "comp_download_failures_total",
+		Help: "Failed yt-dlp downloads.",
+	})
+
+	// StoreErrors counts failed store operations (Redis errors).
+	StoreErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "comp_store_errors_total",
+		Help: "Store operations that failed, by operation.",
+	}, []string{"op"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "comp_http_request_duration_seconds",
+		Help:    "HTTP request latency by route.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "code"})
+)
+
+// QueueDepth exports the number of waiting jobs, read from length on scrape.
+func QueueDepth(length func() (int, error)) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "comp_queue_depth",
+		Help: "Jobs waiting in the queue.",
+	}, func() float64 {
+		n, err := length()
+		if err != nil {
+			return -1
+		}
+		return float64(n)
+	})
+}
+
+// Track marks one running process of binary and returns the func ending it.
+func Track(binary string) func() {
+	g := ActiveProcesses.WithLabelValues(binary)
+	g.Inc()
+	return g.Dec
+}
+
+// Middleware records request latency labelled with the route pattern, so
+// /status/:id is one series rather than one per task.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		httpDuration.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).Observe(time.Since(start).Seconds())
+	}
+}
+
+// Handler serves the metrics; a non-empty token must be sent as a bearer token.
+func Handler(token string) gin.HandlerFunc {
+	h := promhttp.Handler()
+	return func(c *gin.Context) {
+		if token != "" && subtle.ConstantTimeCompare([]byte(c.GetHeader("Authorization")), []byte("Bearer "+token)) != 1 {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(c.Writer, c.Request)
+	}
+}
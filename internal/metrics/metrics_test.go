@@ -0,0 +1,50 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestHandlerToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Middleware())
+	r.GET("/metrics", Handler("tok"))
+	r.GET("/status/:id", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+	get := func(path, auth string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	for _, auth := range []string{"", "Bearer nope", "tok"} {
+		if w := get("/metrics", auth); w.Code != http.StatusUnauthorized {
+			t.Errorf("Authorization %q: %d, want 401", auth, w.Code)
+		}
+	}
+
+	done := Track("ffmpeg")
+	get("/status/abc", "")
+	get("/status/def", "")
+	w := get("/metrics", "Bearer tok")
+	done()
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /metrics: %d", w.Code)
+	}
+	body := w.Body.String()
+	// one series per route pattern, not per task
+	if !strings.Contains(body, `route="/status/:id"`) || strings.Contains(body, "/status/abc") {
+		t.Error("request latency is not labelled by route pattern")
+	}
+	if !strings.Contains(body, `comp_active_processes{binary="ffmpeg"} 1`) {
+		t.Error("running ffmpeg process not counted")
+	}
+}
@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"comp/internal/config"
+)
+
+// ErrNotFound is returned by Get and Delete for unknown keys.
+var ErrNotFound = errors.New("object not found")
+
+// ErrNoSignedURL is returned by backends that cannot hand out direct links;
+// callers then stream the object themselves.
+var ErrNoSignedURL = errors.New("signed urls not supported")
+
+// Object describes a stored file.
+type Object struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// ReadSeekCloser is what Get returns; seeking lets HTTP handlers serve ranges.
+type ReadSeekCloser interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+// Storage keeps task sources, outputs and the chunks of resumable uploads.
+// Keys are slash-separated, e.g. "outputs/<task>/<name>", "incoming/<task>/<file>"
+// or "partial/<upload>/<offset>".
+type Storage interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	Get(ctx context.Context, key string) (ReadSeekCloser, Object, error)
+	Delete(ctx context.Context, key string) error
+	// SignedURL returns a time-limited direct download URL that makes the
+	// client save the object as filename.
+	SignedURL(ctx context.Context, key, filename string, ttl time.Duration) (string, error)
+	// List returns all objects whose key starts with prefix.
+	List(ctx context.Context, prefix string) ([]Object, error)
+}
+
+// New builds the configured backend; localDir is the root for "local".
+func New(ctx context.Context, cfg config.StorageConfig, localDir string) (Storage, error) {
+	switch cfg.Backend {
+	case "", "local":
+		return NewLocal(localDir)
+	case "s3":
+		return NewS3(ctx, cfg.S3)
+	}
+	return nil, fmt.Errorf("unknown storage backend %q", cfg.Backend)
+}
@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"comp/internal/config"
+)
+
+// conformance checks the behaviour every backend must share.
+func conformance(t *testing.T, s Storage) {
+	ctx := context.Background()
+	body := "hello storage"
+	if err := s.Put(ctx, "outputs/a.txt", strings.NewReader(body), int64(len(body)), "text/plain"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	r, obj, err := s.Get(ctx, "outputs/a.txt")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	got, _ := io.ReadAll(r)
+	r.Close()
+	if string(got) != body || obj.Size != int64(len(body)) || obj.Key != "outputs/a.txt" {
+		t.Errorf("Get = %q, %+v", got, obj)
+	}
+
+	objs, err := s.List(ctx, "outputs/")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	keys := make([]string, 0, len(objs))
+	for _, o := range objs {
+		keys = append(keys, o.Key)
+	}
+	if !slices.Contains(keys, "outputs/a.txt") {
+		t.Errorf("List = %v, want outputs/a.txt", keys)
+	}
+
+	if err := s.Delete(ctx, "outputs/a.txt"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, _, err := s.Get(ctx, "outputs/a.txt"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after Delete = %v, want ErrNotFound", err)
+	}
+	if err := s.Delete(ctx, "outputs/a.txt"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second Delete = %v, want ErrNotFound", err)
+	}
+}
+
+func TestLocal(t *testing.T) {
+	l, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conformance(t, l)
+}
+
+func TestLocalRejectsEscapingKeys(t *testing.T) {
+	root := t.TempDir()
+	l, err := NewLocal(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := l.path("../../etc/passwd")
+	if err != nil || !strings.HasPrefix(p, root) {
+		t.Errorf("path = %q, %v; want a path below %s", p, err, root)
+	}
+	if _, err := l.path("/"); err == nil {
+		t.Error("empty key accepted")
+	}
+}
+
+// TestS3 runs against a real S3-compatible service such as the minio
+// service in docker-compose.yml:
+//
+//	docker compose --profile s3 up -d minio
+//	STORAGE_TEST_S3_ENDPOINT=localhost:9000 go test ./internal/storage
+func TestS3(t *testing.T) {
+	endpoint := os.Getenv("STORAGE_TEST_S3_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("STORAGE_TEST_S3_ENDPOINT not set")
+	}
+	s, err := NewS3(context.Background(), config.S3Config{
+		Endpoint:  endpoint,
+		Region:    "us-east-1",
+		Bucket:    "storage-test",
+		AccessKey: envOr("STORAGE_TEST_S3_ACCESS_KEY", "minioadmin"),
+		SecretKey: envOr("STORAGE_TEST_S3_SECRET_KEY", "minioadmin"),
+		Prefix:    t.Name(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conformance(t, s)
+}
+
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// TestS3DeleteMissing checks the NoSuchKey mapping against a stub that
+// knows the bucket but no objects.
+func TestS3DeleteMissing(t *testing.T) {
+	var deletes int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/bucket" || r.URL.Path == "/bucket/":
+			w.WriteHeader(http.StatusOK)
+		case r.Method == http.MethodDelete:
+			deletes++
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+	s, err := NewS3(context.Background(), config.S3Config{Endpoint: u.Host, Region: "us-east-1", Bucket: "bucket", AccessKey: "k", SecretKey: "s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(context.Background(), "outputs/missing.mp4"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Delete = %v, want ErrNotFound", err)
+	}
+	if deletes != 0 {
+		t.Errorf("sent %d DELETE requests for a missing key", deletes)
+	}
+}
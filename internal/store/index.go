@@ -0,0 +1,200 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+// Secondary indexes. In Redis each is a sorted set of task IDs scored by
+// creation time (unix ms):
+//
+//	tasks:created          every task
+//	tasks:status:<status>  tasks currently in that status
+//	tasks:owner:<owner>    tasks of one owner (written by SetOwner)
+//
+// Writes trim entries created more than Retain ago from tasks:created,
+// the owner sets and the sets of finished statuses. Entries can still
+// outlive their task record; List drops those it runs into.
+const createdKey = "tasks:created"
+
+func statusKey(status string) string {
+	return "tasks:status:" + status
+}
+
+func ownerTasksKey(owner string) string {
+	return "tasks:owner:" + owner
+}
+
+// TaskQuery filters List. Zero fields match everything.
+type TaskQuery struct {
+	Status string
+	Type   string
+	// Owner restricts the result to one owner; nil means any owner.
+	Owner *string
+	// Since and Until bound the creation time.
+	Since, Until time.Time
+	// Offset skips that many matching tasks and Limit caps the result
+	// (0 means no cap); together they page through the matches.
+	Offset, Limit int
+}
+
+// page collects the matches of one List call.
+type page struct {
+	q    TaskQuery
+	skip int
+	out  []*TaskStatus
+	next int
+}
+
+// add takes the next match and reports whether the page is complete. One
+// match past the page tells that another page follows.
+func (p *page) add(t *TaskStatus) bool {
+	if p.skip < p.q.Offset {
+		p.skip++
+		return false
+	}
+	if p.q.Limit > 0 && len(p.out) >= p.q.Limit {
+		p.next = p.q.Offset + len(p.out)
+		return true
+	}
+	p.out = append(p.out, t)
+	return false
+}
+
+func (q TaskQuery) match(t *TaskStatus) bool {
+	if q.Status != "" && t.Status != q.Status {
+		return false
+	}
+	if q.Type != "" && t.Type != q.Type {
+		return false
+	}
+	if t.CreatedAt != nil {
+		if !q.Since.IsZero() && t.CreatedAt.Before(q.Since) {
+			return false
+		}
+		if !q.Until.IsZero() && t.CreatedAt.After(q.Until) {
+			return false
+		}
+	}
+	return true
+}
+
+// index queues the index updates for a merge of cur into next.
+func (s *RedisStore) index(ctx context.Context, p redis.Pipeliner, cur, next *TaskStatus) {
+	score := float64(time.Now().UnixMilli())
+	if next.CreatedAt != nil {
+		score = float64(next.CreatedAt.UnixMilli())
+	}
+	if cur == nil {
+		p.ZAdd(ctx, createdKey, redis.Z{Score: score, Member: next.ID})
+		s.trim(ctx, p, createdKey)
+	}
+	if cur == nil || cur.Status != next.Status {
+		if cur != nil {
+			p.ZRem(ctx, statusKey(cur.Status), next.ID)
+		}
+		p.ZAdd(ctx, statusKey(next.Status), redis.Z{Score: score, Member: next.ID})
+		// tasks leave the other status sets when they finish
+		if Finished(next.Status) {
+			s.trim(ctx, p, statusKey(next.Status))
+		}
+	}
+}
+
+// trim drops index entries created more than Retain ago.
+func (s *RedisStore) trim(ctx context.Context, p redis.Pipeliner, key string) {
+	if s.Retain <= 0 {
+		return
+	}
+	cutoff := time.Now().Add(-s.Retain).UnixMilli()
+	p.ZRemRangeByScore(ctx, key, "-inf", "("+strconv.FormatInt(cutoff, 10))
+}
+
+func (s *RedisStore) List(ctx context.Context, q TaskQuery) ([]*TaskStatus, int, error) {
+	if s.Rdb == nil {
+		return s.mem.List(ctx, q)
+	}
+	// walk the narrowest index; the other filters are checked per record
+	key := createdKey
+	switch {
+	case q.Owner != nil:
+		key = ownerTasksKey(*q.Owner)
+	case q.Status != "":
+		key = statusKey(q.Status)
+	}
+	max := "+inf"
+	if !q.Until.IsZero() {
+		max = strconv.FormatInt(q.Until.UnixMilli(), 10)
+	}
+	min := "-inf"
+	if !q.Since.IsZero() {
+		min = strconv.FormatInt(q.Since.UnixMilli(), 10)
+	}
+	const batch = 200
+	pg := page{q: q}
+	for offset := int64(0); ; offset += batch {
+		ids, err := s.Rdb.ZRevRangeByScore(ctx, key, &redis.ZRangeBy{Min: min, Max: max, Offset: offset, Count: batch}).Result()
+		if err != nil {
+			return pg.out, 0, countErr("list", err)
+		}
+		if len(ids) == 0 {
+			return pg.out, 0, nil
+		}
+		keys := make([]string, len(ids))
+		for i, id := range ids {
+			keys[i] = "task:" + id
+		}
+		vals, err := s.Rdb.MGet(ctx, keys...).Result()
+		if err != nil {
+			return pg.out, 0, countErr("list", err)
+		}
+		var gone []any
+		for i, v := range vals {
+			str, ok := v.(string)
+			if !ok {
+				gone = append(gone, ids[i])
+				continue
+			}
+			var t TaskStatus
+			if json.Unmarshal([]byte(str), &t) != nil || !q.match(&t) {
+				continue
+			}
+			if pg.add(&t) {
+				return pg.out, pg.next, nil
+			}
+		}
+		if len(gone) > 0 {
+			// expired records; removing them shifts the window, so step back
+			_ = s.Rdb.ZRem(ctx, key, gone...).Err()
+			offset -= int64(len(gone))
+		}
+		if len(ids) < batch {
+			return pg.out, 0, nil
+		}
+	}
+}
+
+func (m *MemoryStore) List(_ context.Context, q TaskQuery) ([]*TaskStatus, int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	ids := m.order
+	if q.Owner != nil {
+		ids = m.byOwner[*q.Owner]
+	}
+	now := m.now()
+	pg := page{q: q}
+	for i := len(ids) - 1; i >= 0; i-- {
+		t, ok := m.data[ids[i]]
+		if !ok || expired(m.expires, ids[i], now) || !q.match(&t) {
+			continue
+		}
+		if pg.add(&t) {
+			break
+		}
+	}
+	return pg.out, pg.next, nil
+}
@@ -0,0 +1,121 @@
+package store
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	redis "github.com/redis/go-redis/v9"
+)
+
+func ids(tasks []*TaskStatus) []string {
+	out := make([]string, len(tasks))
+	for i, t := range tasks {
+		out[i] = t.ID
+	}
+	return out
+}
+
+func TestList(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range queues(t) {
+		t.Run(name, func(t *testing.T) {
+			alice, bob := "alice", "bob"
+			for _, tk := range []struct{ id, owner, status, typ string }{
+				{"a1", alice, "completed", "video_compress"},
+				{"b1", bob, "failed", "video_compress"},
+				{"a2", alice, "processing", "video_to_gif"},
+				{"a3", alice, "completed", "video_to_gif"},
+			} {
+				_ = s.SetOwner(ctx, tk.id, tk.owner, 0)
+				_ = s.Set(ctx, &TaskStatus{ID: tk.id, Status: tk.status, Type: tk.typ}, time.Hour)
+				time.Sleep(2 * time.Millisecond) // distinct creation times
+			}
+			tests := []struct {
+				name string
+				q    TaskQuery
+				want []string
+				next int
+			}{
+				{"all, newest first", TaskQuery{}, []string{"a3", "a2", "b1", "a1"}, 0},
+				{"status", TaskQuery{Status: "completed"}, []string{"a3", "a1"}, 0},
+				{"type", TaskQuery{Type: "video_compress"}, []string{"b1", "a1"}, 0},
+				{"owner", TaskQuery{Owner: &alice}, []string{"a3", "a2", "a1"}, 0},
+				{"owner and status", TaskQuery{Owner: &bob, Status: "completed"}, nil, 0},
+				{"limit", TaskQuery{Limit: 2}, []string{"a3", "a2"}, 2},
+				{"second page", TaskQuery{Offset: 2, Limit: 2}, []string{"b1", "a1"}, 0},
+				{"page of an owner", TaskQuery{Owner: &alice, Offset: 1, Limit: 1}, []string{"a2"}, 2},
+				{"offset past the end", TaskQuery{Offset: 4, Limit: 2}, nil, 0},
+				{"until the past", TaskQuery{Until: time.Now().Add(-time.Hour)}, nil, 0},
+				{"since the future", TaskQuery{Since: time.Now().Add(time.Hour)}, nil, 0},
+				{"since an hour ago", TaskQuery{Since: time.Now().Add(-time.Hour), Status: "failed"}, []string{"b1"}, 0},
+			}
+			for _, tt := range tests {
+				got, next, err := s.List(ctx, tt.q)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !slices.Equal(ids(got), tt.want) || next != tt.next {
+					t.Errorf("%s: List = %v, next %d; want %v, next %d", tt.name, ids(got), next, tt.want, tt.next)
+				}
+			}
+		})
+	}
+}
+
+func TestListDropsExpiredEntries(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer rdb.Close()
+	s := NewRedisStore(rdb)
+	_ = s.Set(ctx, &TaskStatus{ID: "old", Status: "completed"}, time.Minute)
+	_ = s.Set(ctx, &TaskStatus{ID: "new", Status: "completed"}, time.Hour)
+	mr.FastForward(2 * time.Minute)
+
+	got, _, err := s.List(ctx, TaskQuery{Status: "completed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(ids(got), []string{"new"}) {
+		t.Errorf("List = %v, want [new]", ids(got))
+	}
+	if members, _ := mr.ZMembers(statusKey("completed")); slices.Contains(members, "old") {
+		t.Error("expired task left in the status index")
+	}
+}
+
+func TestIndexesFollowRetain(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer rdb.Close()
+	s := NewRedisStore(rdb)
+	s.Retain = 72 * time.Hour
+	alice := "alice"
+
+	_ = s.SetOwner(ctx, "t1", alice, 0)
+	_ = s.Set(ctx, &TaskStatus{ID: "t1", Status: "completed"}, time.Hour)
+	mr.FastForward(25 * time.Hour)
+	// still listed for its owner while the record is retained
+	if got, _, _ := s.List(ctx, TaskQuery{Owner: &alice}); !slices.Equal(ids(got), []string{"t1"}) {
+		t.Errorf("owner List after 25h = %v, want [t1]", ids(got))
+	}
+
+	// entries are scored by real creation time, so age them by hand
+	old := float64(time.Now().Add(-100 * time.Hour).UnixMilli())
+	for _, key := range []string{createdKey, statusKey("completed"), ownerTasksKey(alice)} {
+		if _, err := mr.ZAdd(key, old, "stale"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = s.SetOwner(ctx, "t2", alice, 0)
+	_ = s.Set(ctx, &TaskStatus{ID: "t2", Status: "completed"}, time.Hour)
+	for _, key := range []string{createdKey, statusKey("completed"), ownerTasksKey(alice)} {
+		if members, _ := mr.ZMembers(key); slices.Contains(members, "stale") {
+			t.Errorf("%s keeps an entry older than Retain", key)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	redis "github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+
+	"comp/internal/tracing"
+)
+
+// DefaultLogLimit is the task log size kept when RedisStore.LogLimit is unset.
+const DefaultLogLimit = 64 << 10
+
+func logKey(id string) string { return "task:" + id + ":log" }
+
+// AppendLog adds text to the task's log, keeping only the newest LogLimit
+// bytes. The log lives at least as long as finished task records.
+func (s *RedisStore) AppendLog(ctx context.Context, id, text string, ttl time.Duration) (err error) {
+	ctx, span := traceWrite(ctx, "store.AppendLog", attribute.String("task.id", id))
+	defer func() { tracing.End(span, err) }()
+	limit := s.LogLimit
+	if limit <= 0 {
+		limit = DefaultLogLimit
+	}
+	if ttl < s.Retain {
+		ttl = s.Retain
+	}
+	if s.Rdb == nil {
+		s.mem.appendLog(id, text, limit, ttl)
+		return nil
+	}
+	key := logKey(id)
+	var n *redis.IntCmd
+	_, err = s.Rdb.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		n = p.Append(ctx, key, text)
+		p.Expire(ctx, key, ttl)
+		return nil
+	})
+	if err != nil {
+		return countErr("append_log", err)
+	}
+	if n.Val() > int64(limit) {
+		// one writer per task, so trimming outside the transaction is fine
+		tail, err := s.Rdb.GetRange(ctx, key, -int64(limit), -1).Result()
+		if err != nil {
+			return countErr("append_log", err)
+		}
+		return countErr("append_log", s.Rdb.Set(ctx, key, trimLog(tail, limit), redis.KeepTTL).Err())
+	}
+	return nil
+}
+
+// Log returns the task's log.
+func (s *RedisStore) Log(ctx context.Context, id string) (string, bool) {
+	if s.Rdb == nil {
+		return s.mem.Log(ctx, id)
+	}
+	v, err := s.Rdb.Get(ctx, logKey(id)).Result()
+	if err != nil {
+		countErr("get_log", err)
+		return "", false
+	}
+	return v, true
+}
+
+// trimLog keeps the newest limit bytes of log, starting at a line boundary
+// when one is near.
+func trimLog(log string, limit int) string {
+	if len(log) <= limit {
+		return log
+	}
+	log = log[len(log)-limit:]
+	for i := 0; i < len(log) && i < 512; i++ {
+		if log[i] == '\n' {
+			return log[i+1:]
+		}
+	}
+	return log
+}
+
+type memLogs struct {
+	// LogLimit caps each task log in bytes; zero means DefaultLogLimit.
+	LogLimit int
+	lmu      sync.Mutex
+	logs     map[string]memLog
+	lpruned  time.Time
+}
+
+type memLog struct {
+	text    string
+	expires time.Time
+}
+
+func (m *MemoryStore) appendLog(id, text string, limit int, ttl time.Duration) {
+	m.lmu.Lock()
+	defer m.lmu.Unlock()
+	if m.logs == nil {
+		m.logs = make(map[string]memLog)
+	}
+	now := m.now()
+	m.pruneLogs(now)
+	e := m.logs[id]
+	if !e.expires.IsZero() && now.After(e.expires) {
+		e.text = ""
+	}
+	m.logs[id] = memLog{text: trimLog(e.text+text, limit), expires: deadline(now, ttl)}
+}
+
+// pruneLogs drops expired logs at most once a minute.
+func (m *MemoryStore) pruneLogs(now time.Time) {
+	if now.Sub(m.lpruned) < time.Minute {
+		return
+	}
+	m.lpruned = now
+	for id, e := range m.logs {
+		if !e.expires.IsZero() && now.After(e.expires) {
+			delete(m.logs, id)
+		}
+	}
+}
+
+func (m *MemoryStore) AppendLog(_ context.Context, id, text string, ttl time.Duration) error {
+	limit := m.LogLimit
+	if limit <= 0 {
+		limit = DefaultLogLimit
+	}
+	if ttl < m.Retain {
+		ttl = m.Retain
+	}
+	m.appendLog(id, text, limit, ttl)
+	return nil
+}
+
+func (m *MemoryStore) Log(_ context.Context, id string) (string, bool) {
+	m.lmu.Lock()
+	defer m.lmu.Unlock()
+	e, ok := m.logs[id]
+	if !ok || !e.expires.IsZero() && m.now().After(e.expires) {
+		return "", false
+	}
+	return e.text, true
+}
@@ -0,0 +1,62 @@
+package store
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAppendLogLimit(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range queues(t) {
+		t.Run(name, func(t *testing.T) {
+			s.LogLimit = 1024
+			line := strings.Repeat("x", 99) + "\n"
+			for i := 0; i < 50; i++ {
+				if err := s.AppendLog(ctx, "t1", line, time.Minute); err != nil {
+					t.Fatal(err)
+				}
+			}
+			log, ok := s.Log(ctx, "t1")
+			if !ok || len(log) > 1024 || len(log) < 900 {
+				t.Errorf("log has %d bytes, want at most 1024", len(log))
+			}
+			if !strings.HasPrefix(log, "x") {
+				t.Errorf("log starts mid-line: %q", log[:10])
+			}
+		})
+	}
+}
+
+func TestMemoryLogLimit(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryStore()
+	m.LogLimit = 10
+	_ = m.AppendLog(ctx, "t1", "0123456789abc", 0)
+	if log, _ := m.Log(ctx, "t1"); log != "3456789abc" {
+		t.Errorf("Log = %q, want the newest 10 bytes", log)
+	}
+	m.LogLimit = 0
+	_ = m.AppendLog(ctx, "t2", strings.Repeat("y", DefaultLogLimit+5), 0)
+	if log, _ := m.Log(ctx, "t2"); len(log) != DefaultLogLimit {
+		t.Errorf("Log has %d bytes, want DefaultLogLimit", len(log))
+	}
+}
+
+func TestTrimLog(t *testing.T) {
+	tests := []struct {
+		log   string
+		limit int
+		want  string
+	}{
+		{"short", 10, "short"},
+		{"line one\nline two\n", 12, "line two\n"},
+		{"abcdefgh", 4, "efgh"},
+	}
+	for _, tt := range tests {
+		if got := trimLog(tt.log, tt.limit); got != tt.want {
+			t.Errorf("trimLog(%q, %d) = %q, want %q", tt.log, tt.limit, got, tt.want)
+		}
+	}
+}
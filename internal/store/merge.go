@@ -0,0 +1,117 @@
+package store
+
+import (
+	"reflect"
+	"time"
+)
+
+// maxHistory caps TaskStatus.History.
+const maxHistory = 50
+
+// Finished reports whether status is terminal.
+func Finished(status string) bool {
+	switch status {
+	case "completed", "failed", "cancelled", "interrupted":
+		return true
+	}
+	return false
+}
+
+// merge applies update u to the current record cur (nil if none) and
+// reports whether anything changed. Writers only send the fields they know,
+// so zero values mean "keep". The rules keep records consistent when
+// updates race:
+//   - a finished task keeps its status, stage, percent and error; late
+//     progress writes cannot bring it back to processing
+//   - within a stage the percent never goes down; a new stage starts afresh
+//   - timestamps and history are maintained here, not by the writers
+func merge(cur *TaskStatus, u TaskStatus, now time.Time) (TaskStatus, bool) {
+	var t TaskStatus
+	if cur != nil {
+		t = *cur
+		t.History = append([]StageEntry(nil), cur.History...)
+	} else {
+		t.ID = u.ID
+		t.CreatedAt = &now
+	}
+
+	if !Finished(t.Status) {
+		if u.Stage != "" && u.Stage != t.Stage {
+			t.Stage = u.Stage
+			t.Percent = u.Percent
+			t.ETASeconds, t.Speed = 0, 0
+		} else if u.Percent > t.Percent {
+			t.Percent = u.Percent
+		}
+		if u.Status != "" {
+			t.Status = u.Status
+		}
+		if u.Error != "" {
+			t.Error = u.Error
+		}
+		if u.ETASeconds > 0 {
+			t.ETASeconds = u.ETASeconds
+		}
+		if u.Speed > 0 {
+			t.Speed = u.Speed
+		}
+	}
+	if u.OutputFile != "" {
+		t.OutputFile = u.OutputFile
+	}
+	if u.StoredFile != "" {
+		t.StoredFile = u.StoredFile
+	}
+	if u.Type != "" {
+		t.Type = u.Type
+	}
+	if u.Params != nil {
+		t.Params = u.Params
+	}
+	if u.SourceName != "" {
+		t.SourceName = u.SourceName
+	}
+	if u.Callback != nil {
+		cb := *u.Callback
+		t.Callback = &cb
+	}
+	if u.Parent != "" {
+		t.Parent = u.Parent
+	}
+	if u.Children != nil {
+		t.Children = u.Children
+	}
+	if u.Batch != nil {
+		b := *u.Batch
+		t.Batch = &b
+	}
+	if u.InputSize > 0 {
+		t.InputSize = u.InputSize
+	}
+	if u.OutputSize > 0 {
+		t.OutputSize = u.OutputSize
+	}
+	if t.InputSize > 0 && t.OutputSize > 0 {
+		t.CompressionRatio = float64(t.InputSize) / float64(t.OutputSize)
+	}
+
+	if t.Status == "processing" && t.StartedAt == nil {
+		t.StartedAt = &now
+	}
+	if Finished(t.Status) && t.FinishedAt == nil {
+		t.FinishedAt = &now
+		t.ETASeconds = 0
+	}
+	if n := len(t.History); n == 0 || t.History[n-1].Status != t.Status || t.History[n-1].Stage != t.Stage {
+		t.History = append(t.History, StageEntry{Status: t.Status, Stage: t.Stage, At: now})
+		if len(t.History) > maxHistory {
+			t.History = t.History[len(t.History)-maxHistory:]
+		}
+	}
+	t.QueuePosition, t.DownloadURL, t.Items = 0, "", nil
+
+	if cur == nil {
+		return t, true
+	}
+	return t, !reflect.DeepEqual(t, *cur)
+}
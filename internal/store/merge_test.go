@@ -0,0 +1,89 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMergeNew(t *testing.T) {
+	now := time.Now()
+	got, changed := merge(nil, TaskStatus{ID: "t1", Status: "queued", Stage: "queued"}, now)
+	if !changed {
+		t.Error("new record reported unchanged")
+	}
+	if got.CreatedAt == nil || !got.CreatedAt.Equal(now) || got.StartedAt != nil {
+		t.Errorf("timestamps = created %v, started %v", got.CreatedAt, got.StartedAt)
+	}
+	if len(got.History) != 1 || got.History[0].Status != "queued" {
+		t.Errorf("History = %+v, want one queued entry", got.History)
+	}
+}
+
+func TestMergeProgress(t *testing.T) {
+	now := time.Now()
+	cur, _ := merge(nil, TaskStatus{ID: "t1", Status: "processing", Stage: "transcode", Percent: 40, Speed: 1.5, ETASeconds: 30}, now)
+	if cur.StartedAt == nil {
+		t.Error("StartedAt not set on processing")
+	}
+
+	// a late write with an older percent does not move progress back
+	got, changed := merge(&cur, TaskStatus{Percent: 20}, now)
+	if changed || got.Percent != 40 {
+		t.Errorf("stale percent: %d, changed %v; want 40, unchanged", got.Percent, changed)
+	}
+
+	// a new stage starts from its own percent and drops the old estimates
+	got, _ = merge(&cur, TaskStatus{Stage: "retry1", Percent: 5}, now)
+	if got.Stage != "retry1" || got.Percent != 5 || got.Speed != 0 || got.ETASeconds != 0 {
+		t.Errorf("new stage = %+v", got)
+	}
+	if n := len(got.History); n != 2 || got.History[1].Stage != "retry1" {
+		t.Errorf("History = %+v, want the new stage appended", got.History)
+	}
+}
+
+func TestMergeFinishedSticks(t *testing.T) {
+	now := time.Now()
+	cur, _ := merge(nil, TaskStatus{ID: "t1", Status: "processing", Stage: "transcode", Percent: 90, ETASeconds: 5}, now)
+	cur, _ = merge(&cur, TaskStatus{Status: "completed", Percent: 100, InputSize: 1000, OutputSize: 250}, now.Add(time.Second))
+	if cur.FinishedAt == nil || cur.ETASeconds != 0 || cur.CompressionRatio != 4 {
+		t.Errorf("completed = %+v", cur)
+	}
+
+	// progress of a worker that has not noticed yet
+	got, changed := merge(&cur, TaskStatus{Status: "processing", Stage: "finalize", Percent: 95, Error: "late"}, now.Add(2*time.Second))
+	if changed {
+		t.Errorf("finished task changed: %+v", got)
+	}
+	if got.Status != "completed" || got.Stage != "transcode" || got.Percent != 100 || got.Error != "" {
+		t.Errorf("finished task reopened: %+v", got)
+	}
+
+	// fields outside the state machine still apply
+	got, _ = merge(&cur, TaskStatus{Callback: &Callback{URL: "https://hooks.example", State: "delivered"}}, now)
+	if got.Callback == nil || got.Callback.State != "delivered" {
+		t.Errorf("Callback = %+v", got.Callback)
+	}
+}
+
+func TestMergeHistoryCap(t *testing.T) {
+	now := time.Now()
+	cur, _ := merge(nil, TaskStatus{ID: "t1", Status: "processing"}, now)
+	for i := 0; i < maxHistory+10; i++ {
+		stage := "a"
+		if i%2 == 1 {
+			stage = "b"
+		}
+		cur, _ = merge(&cur, TaskStatus{Stage: stage}, now)
+	}
+	if len(cur.History) != maxHistory {
+		t.Errorf("History has %d entries, want %d", len(cur.History), maxHistory)
+	}
+}
+
+func TestMergeDropsComputedFields(t *testing.T) {
+	got, _ := merge(nil, TaskStatus{ID: "t1", Status: "queued", QueuePosition: 3, DownloadURL: "/d/x"}, time.Now())
+	if got.QueuePosition != 0 || got.DownloadURL != "" {
+		t.Errorf("computed fields stored: %+v", got)
+	}
+}
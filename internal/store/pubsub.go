@@ -0,0 +1,95 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+)
+
+func eventsChannel(id string) string { return "task:events:" + id }
+
+func (s *RedisStore) Subscribe(ctx context.Context, id string) (<-chan TaskStatus, func()) {
+	if s.Rdb == nil {
+		return s.mem.Subscribe(ctx, id)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	sub := s.Rdb.Subscribe(ctx, eventsChannel(id))
+	// wait for the subscription to be confirmed so callers can safely Get afterwards
+	_, _ = sub.Receive(ctx)
+	out := make(chan TaskStatus, 16)
+	go func() {
+		defer close(out)
+		defer sub.Close()
+		ch := sub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-ch:
+				if !ok {
+					return
+				}
+				var t TaskStatus
+				if json.Unmarshal([]byte(msg.Payload), &t) != nil {
+					continue
+				}
+				select {
+				case out <- t:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out, cancel
+}
+
+// memPubSub fans Set calls out to in-process subscribers. Slow subscribers
+// drop intermediate updates rather than block writers.
+type memPubSub struct {
+	smu  sync.Mutex
+	subs map[string]map[chan TaskStatus]struct{}
+}
+
+func (m *MemoryStore) Subscribe(ctx context.Context, id string) (<-chan TaskStatus, func()) {
+	ch := make(chan TaskStatus, 16)
+	m.smu.Lock()
+	if m.subs[id] == nil {
+		m.subs[id] = make(map[chan TaskStatus]struct{})
+	}
+	m.subs[id][ch] = struct{}{}
+	m.smu.Unlock()
+	var once sync.Once
+	done := make(chan struct{})
+	stop := func() {
+		once.Do(func() {
+			close(done)
+			m.smu.Lock()
+			delete(m.subs[id], ch)
+			if len(m.subs[id]) == 0 {
+				delete(m.subs, id)
+			}
+			m.smu.Unlock()
+			close(ch)
+		})
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			stop()
+		case <-done:
+		}
+	}()
+	return ch, stop
+}
+
+func (m *MemoryStore) publish(t TaskStatus) {
+	m.smu.Lock()
+	defer m.smu.Unlock()
+	for ch := range m.subs[t.ID] {
+		select {
+		case ch <- t:
+		default:
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// nextUpdate waits for the next update on ch.
+func nextUpdate(t *testing.T, ch <-chan TaskStatus) (TaskStatus, bool) {
+	t.Helper()
+	select {
+	case u, ok := <-ch:
+		return u, ok
+	case <-time.After(2 * time.Second):
+		t.Fatal("no update")
+		return TaskStatus{}, false
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range queues(t) {
+		t.Run(name, func(t *testing.T) {
+			updates, stop := s.Subscribe(ctx, "t1")
+			_ = s.Set(ctx, &TaskStatus{ID: "t2", Status: "processing"}, time.Hour)
+			_ = s.Set(ctx, &TaskStatus{ID: "t1", Status: "processing", Stage: "transcode", Percent: 10}, time.Hour)
+			// subscribers get the merged record, not the partial write
+			_ = s.Set(ctx, &TaskStatus{ID: "t1", Percent: 40}, time.Hour)
+
+			u, _ := nextUpdate(t, updates)
+			if u.ID != "t1" || u.Stage != "transcode" || u.Percent != 10 {
+				t.Errorf("first update = %+v, want t1 transcode 10%%", u)
+			}
+			u, _ = nextUpdate(t, updates)
+			if u.Status != "processing" || u.Stage != "transcode" || u.Percent != 40 {
+				t.Errorf("second update = %+v, want processing transcode 40%%", u)
+			}
+			stop()
+			for {
+				if _, ok := nextUpdate(t, updates); !ok {
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestSubscribeEndsWithContext(t *testing.T) {
+	for name, s := range queues(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			updates, stop := s.Subscribe(ctx, "t1")
+			defer stop()
+			cancel()
+			for {
+				if _, ok := nextUpdate(t, updates); !ok {
+					break
+				}
+			}
+		})
+	}
+}
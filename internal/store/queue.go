@@ -0,0 +1,312 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	redis "github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+
+	"comp/internal/tracing"
+)
+
+// ErrQueueEmpty is returned by Dequeue when no job arrived before the timeout.
+var ErrQueueEmpty = errors.New("queue empty")
+
+// Queue is a FIFO of job IDs with an opaque payload stored next to each ID.
+// A dequeued job is claimed by the worker that took it and stays in its
+// processing list until the worker acks or requeues it, so a job survives
+// the worker dying with it.
+type Queue interface {
+	Enqueue(ctx context.Context, id string, payload []byte) error
+	// Dequeue blocks up to timeout and moves the oldest job to worker's
+	// processing list. A job whose payload is gone comes back with a nil
+	// payload.
+	Dequeue(ctx context.Context, worker string, timeout time.Duration) (string, []byte, error)
+	// Ack drops a job worker has claimed, together with its payload.
+	Ack(ctx context.Context, worker, id string) error
+	// Requeue puts a job worker has claimed back at the head of the queue
+	// with payload.
+	Requeue(ctx context.Context, worker, id string, payload []byte) error
+	// Claims returns the jobs held in every worker's processing list.
+	Claims(ctx context.Context) ([]Claim, error)
+	// Position returns the 1-based position of id in the queue, or 0 if absent.
+	Position(ctx context.Context, id string) (int, error)
+	Len(ctx context.Context) (int, error)
+	// Remove drops id from the queue and reports whether it was there.
+	Remove(ctx context.Context, id string) (bool, error)
+}
+
+// Claim is a job taken from the queue by Worker and not yet acked.
+type Claim struct {
+	Worker  string
+	ID      string
+	Payload []byte
+}
+
+const queueKey = "queue:jobs"
+
+func processingKey(worker string) string { return "queue:processing:" + worker }
+
+func (s *RedisStore) Enqueue(ctx context.Context, id string, payload []byte) (err error) {
+	ctx, span := traceWrite(ctx, "store.Enqueue", attribute.String("task.id", id))
+	defer func() { tracing.End(span, err) }()
+	if s.Rdb == nil {
+		return s.mem.Enqueue(ctx, id, payload)
+	}
+	_, err = s.Rdb.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.Set(ctx, "job:"+id, payload, 0)
+		p.RPush(ctx, queueKey, id)
+		return nil
+	})
+	return countErr("enqueue", err)
+}
+
+func (s *RedisStore) Dequeue(ctx context.Context, worker string, timeout time.Duration) (string, []byte, error) {
+	if s.Rdb == nil {
+		return s.mem.Dequeue(ctx, worker, timeout)
+	}
+	id, err := s.Rdb.BLMove(ctx, queueKey, processingKey(worker), "LEFT", "RIGHT", timeout).Result()
+	if err == redis.Nil {
+		return "", nil, ErrQueueEmpty
+	}
+	if err != nil {
+		if ctx.Err() == nil {
+			countErr("dequeue", err)
+		}
+		return "", nil, err
+	}
+	payload, err := s.Rdb.Get(ctx, "job:"+id).Bytes()
+	if err == redis.Nil {
+		return id, nil, nil
+	}
+	if err != nil {
+		// leave it for another try rather than holding it here
+		bg := context.WithoutCancel(ctx)
+		_, _ = s.Rdb.TxPipelined(bg, func(p redis.Pipeliner) error {
+			p.LRem(bg, processingKey(worker), 1, id)
+			p.LPush(bg, queueKey, id)
+			return nil
+		})
+		return "", nil, countErr("dequeue", err)
+	}
+	return id, payload, nil
+}
+
+func (s *RedisStore) Ack(ctx context.Context, worker, id string) error {
+	if s.Rdb == nil {
+		return s.mem.Ack(ctx, worker, id)
+	}
+	_, err := s.Rdb.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.LRem(ctx, processingKey(worker), 0, id)
+		p.Del(ctx, "job:"+id)
+		return nil
+	})
+	return countErr("ack", err)
+}
+
+func (s *RedisStore) Requeue(ctx context.Context, worker, id string, payload []byte) error {
+	if s.Rdb == nil {
+		return s.mem.Requeue(ctx, worker, id, payload)
+	}
+	_, err := s.Rdb.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.Set(ctx, "job:"+id, payload, 0)
+		p.LRem(ctx, processingKey(worker), 0, id)
+		p.LPush(ctx, queueKey, id)
+		return nil
+	})
+	return countErr("requeue", err)
+}
+
+func (s *RedisStore) Claims(ctx context.Context) ([]Claim, error) {
+	if s.Rdb == nil {
+		return s.mem.Claims(ctx)
+	}
+	prefix := processingKey("")
+	var out []Claim
+	iter := s.Rdb.Scan(ctx, 0, prefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		ids, err := s.Rdb.LRange(ctx, key, 0, -1).Result()
+		if err != nil {
+			return out, countErr("claims", err)
+		}
+		for _, id := range ids {
+			payload, err := s.Rdb.Get(ctx, "job:"+id).Bytes()
+			if err != nil && err != redis.Nil {
+				return out, countErr("claims", err)
+			}
+			out = append(out, Claim{Worker: key[len(prefix):], ID: id, Payload: payload})
+		}
+	}
+	return out, countErr("claims", iter.Err())
+}
+
+func (s *RedisStore) Position(ctx context.Context, id string) (int, error) {
+	if s.Rdb == nil {
+		return s.mem.Position(ctx, id)
+	}
+	pos, err := s.Rdb.LPos(ctx, queueKey, id, redis.LPosArgs{}).Result()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return int(pos) + 1, nil
+}
+
+func (s *RedisStore) Len(ctx context.Context) (int, error) {
+	if s.Rdb == nil {
+		return s.mem.Len(ctx)
+	}
+	n, err := s.Rdb.LLen(ctx, queueKey).Result()
+	return int(n), err
+}
+
+func (s *RedisStore) Remove(ctx context.Context, id string) (bool, error) {
+	if s.Rdb == nil {
+		return s.mem.Remove(ctx, id)
+	}
+	n, err := s.Rdb.LRem(ctx, queueKey, 0, id).Result()
+	if err != nil {
+		return false, countErr("remove", err)
+	}
+	if n > 0 {
+		_ = s.Rdb.Del(ctx, "job:"+id).Err()
+	}
+	return n > 0, nil
+}
+
+type memJob struct {
+	id      string
+	payload []byte
+}
+
+// memQueue is embedded in MemoryStore; ready is signalled on every Enqueue.
+type memQueue struct {
+	qmu     sync.Mutex
+	jobs    []memJob
+	claimed map[string][]memJob
+	ready   chan struct{}
+}
+
+func (m *MemoryStore) Enqueue(_ context.Context, id string, payload []byte) error {
+	m.qmu.Lock()
+	m.jobs = append(m.jobs, memJob{id: id, payload: payload})
+	m.qmu.Unlock()
+	m.signal()
+	return nil
+}
+
+func (m *MemoryStore) signal() {
+	select {
+	case m.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (m *MemoryStore) Dequeue(ctx context.Context, worker string, timeout time.Duration) (string, []byte, error) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		m.qmu.Lock()
+		if len(m.jobs) > 0 {
+			j := m.jobs[0]
+			m.jobs = m.jobs[1:]
+			if m.claimed == nil {
+				m.claimed = make(map[string][]memJob)
+			}
+			m.claimed[worker] = append(m.claimed[worker], j)
+			more := len(m.jobs) > 0
+			m.qmu.Unlock()
+			if more {
+				m.signal()
+			}
+			return j.id, j.payload, nil
+		}
+		m.qmu.Unlock()
+		select {
+		case <-m.ready:
+		case <-deadline.C:
+			return "", nil, ErrQueueEmpty
+		case <-ctx.Done():
+			return "", nil, ctx.Err()
+		}
+	}
+}
+
+func (m *MemoryStore) Ack(_ context.Context, worker, id string) error {
+	m.qmu.Lock()
+	m.unclaim(worker, id)
+	m.qmu.Unlock()
+	return nil
+}
+
+func (m *MemoryStore) Requeue(_ context.Context, worker, id string, payload []byte) error {
+	m.qmu.Lock()
+	m.unclaim(worker, id)
+	m.jobs = append([]memJob{{id: id, payload: payload}}, m.jobs...)
+	m.qmu.Unlock()
+	m.signal()
+	return nil
+}
+
+// unclaim drops id from worker's claims; qmu must be held.
+func (m *MemoryStore) unclaim(worker, id string) {
+	jobs := m.claimed[worker]
+	for i, j := range jobs {
+		if j.id == id {
+			jobs = append(jobs[:i], jobs[i+1:]...)
+			break
+		}
+	}
+	if len(jobs) == 0 {
+		delete(m.claimed, worker)
+	} else {
+		m.claimed[worker] = jobs
+	}
+}
+
+func (m *MemoryStore) Claims(_ context.Context) ([]Claim, error) {
+	m.qmu.Lock()
+	defer m.qmu.Unlock()
+	var out []Claim
+	for w, jobs := range m.claimed {
+		for _, j := range jobs {
+			out = append(out, Claim{Worker: w, ID: j.id, Payload: j.payload})
+		}
+	}
+	return out, nil
+}
+
+func (m *MemoryStore) Position(_ context.Context, id string) (int, error) {
+	m.qmu.Lock()
+	defer m.qmu.Unlock()
+	for i, j := range m.jobs {
+		if j.id == id {
+			return i + 1, nil
+		}
+	}
+	return 0, nil
+}
+
+func (m *MemoryStore) Len(_ context.Context) (int, error) {
+	m.qmu.Lock()
+	defer m.qmu.Unlock()
+	return len(m.jobs), nil
+}
+
+func (m *MemoryStore) Remove(_ context.Context, id string) (bool, error) {
+	m.qmu.Lock()
+	defer m.qmu.Unlock()
+	for i, j := range m.jobs {
+		if j.id == id {
+			m.jobs = append(m.jobs[:i], m.jobs[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,114 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	redis "github.com/redis/go-redis/v9"
+)
+
+// queues returns a memory-backed and a Redis-backed store.
+func queues(t *testing.T) map[string]*RedisStore {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	return map[string]*RedisStore{
+		"memory": NewRedisStore(nil),
+		"redis":  NewRedisStore(rdb),
+	}
+}
+
+func TestQueueClaimAck(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range queues(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := s.Enqueue(ctx, "a", []byte("pa")); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Enqueue(ctx, "b", []byte("pb")); err != nil {
+				t.Fatal(err)
+			}
+			id, payload, err := s.Dequeue(ctx, "w1", time.Second)
+			if err != nil || id != "a" || string(payload) != "pa" {
+				t.Fatalf("Dequeue = %q, %q, %v; want a, pa", id, payload, err)
+			}
+			if n, _ := s.Len(ctx); n != 1 {
+				t.Errorf("Len = %d, want 1", n)
+			}
+			claims, err := s.Claims(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(claims) != 1 || claims[0].Worker != "w1" || claims[0].ID != "a" || string(claims[0].Payload) != "pa" {
+				t.Fatalf("Claims = %+v, want a held by w1", claims)
+			}
+			if err := s.Ack(ctx, "w1", "a"); err != nil {
+				t.Fatal(err)
+			}
+			if claims, _ := s.Claims(ctx); len(claims) != 0 {
+				t.Errorf("Claims after Ack = %+v, want none", claims)
+			}
+		})
+	}
+}
+
+func TestQueueRequeueGoesFirst(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range queues(t) {
+		t.Run(name, func(t *testing.T) {
+			_ = s.Enqueue(ctx, "a", []byte("pa"))
+			_ = s.Enqueue(ctx, "b", []byte("pb"))
+			id, _, err := s.Dequeue(ctx, "w1", time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Requeue(ctx, "w1", id, []byte("pa2")); err != nil {
+				t.Fatal(err)
+			}
+			if pos, _ := s.Position(ctx, "a"); pos != 1 {
+				t.Errorf("Position(a) = %d, want 1", pos)
+			}
+			if claims, _ := s.Claims(ctx); len(claims) != 0 {
+				t.Errorf("Claims after Requeue = %+v, want none", claims)
+			}
+			id, payload, err := s.Dequeue(ctx, "w2", time.Second)
+			if err != nil || id != "a" || string(payload) != "pa2" {
+				t.Fatalf("Dequeue = %q, %q, %v; want a, pa2", id, payload, err)
+			}
+		})
+	}
+}
+
+func TestQueueRemoveAndEmpty(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range queues(t) {
+		t.Run(name, func(t *testing.T) {
+			_ = s.Enqueue(ctx, "a", []byte("pa"))
+			if ok, err := s.Remove(ctx, "a"); !ok || err != nil {
+				t.Fatalf("Remove = %v, %v; want true", ok, err)
+			}
+			if ok, _ := s.Remove(ctx, "a"); ok {
+				t.Error("second Remove reported the job as queued")
+			}
+			_, _, err := s.Dequeue(ctx, "w1", 50*time.Millisecond)
+			if !errors.Is(err, ErrQueueEmpty) {
+				t.Fatalf("Dequeue on empty queue: %v, want ErrQueueEmpty", err)
+			}
+		})
+	}
+}
+
+func TestRedisDequeueLostPayload(t *testing.T) {
+	ctx := context.Background()
+	s := queues(t)["redis"]
+	_ = s.Enqueue(ctx, "a", []byte("pa"))
+	s.Rdb.Del(ctx, "job:a")
+	id, payload, err := s.Dequeue(ctx, "w1", time.Second)
+	if err != nil || id != "a" || payload != nil {
+		t.Fatalf("Dequeue = %q, %q, %v; want a with nil payload", id, payload, err)
+	}
+}
@@ -7,26 +7,125 @@ import (
 	"time"
 
 	redis "github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"comp/internal/metrics"
+	"comp/internal/tracing"
 )
 
+var tracer = otel.Tracer("comp/internal/store")
+
 type TaskStatus struct {
 	ID         string `json:"id"`
 	Status     string `json:"status"`
 	Error      string `json:"error,omitempty"`
 	OutputFile string `json:"output_file,omitempty"`
+	// StoredFile is the storage key of the output.
+	StoredFile string `json:"stored_file,omitempty" openapi:"-"`
 	Stage      string `json:"stage,omitempty"`
 	Percent    int    `json:"percent,omitempty"`
+
+	// What was asked for: processing type, its options and the uploaded
+	// file name or source URL.
+	Type       string            `json:"type,omitempty"`
+	Params     map[string]string `json:"params,omitempty"`
+	SourceName string            `json:"source_name,omitempty"`
+
+	CreatedAt  *time.Time `json:"created_at,omitempty"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+
+	InputSize  int64 `json:"input_size,omitempty"`
+	OutputSize int64 `json:"output_size,omitempty"`
+	// CompressionRatio is InputSize/OutputSize, e.g. 4 for a 4x smaller file.
+	CompressionRatio float64 `json:"compression_ratio,omitempty"`
+	// ETASeconds estimates the time left in the current stage; Speed is the
+	// encoder speed as a multiple of realtime.
+	ETASeconds float64 `json:"eta_seconds,omitempty"`
+	Speed      float64 `json:"speed,omitempty"`
+	// History lists every status/stage transition in order.
+	History []StageEntry `json:"history,omitempty"`
+	// Callback is the webhook requested with the task and its delivery state.
+	Callback *Callback `json:"callback,omitempty"`
+	// Parent is the batch an item task belongs to. A batch lists its items
+	// in Children and counts their outcomes in Batch.
+	Parent   string      `json:"parent,omitempty"`
+	Children []string    `json:"children,omitempty"`
+	Batch    *BatchStats `json:"batch,omitempty"`
+
+	// QueuePosition, DownloadURL and Items are filled on read; they are not persisted.
+	QueuePosition int         `json:"queue_position,omitempty"`
+	DownloadURL   string      `json:"download_url,omitempty"`
+	Items         []BatchItem `json:"items,omitempty"`
+}
+
+// BatchStats counts the items of a batch; Failed includes cancelled ones.
+type BatchStats struct {
+	Total     int `json:"total"`
+	Finished  int `json:"finished"`
+	Completed int `json:"completed"`
+	Failed    int `json:"failed"`
+}
+
+// BatchItem summarizes one item of a batch for its owner.
+type BatchItem struct {
+	ID          string `json:"id"`
+	Status      string `json:"status"`
+	Percent     int    `json:"percent,omitempty"`
+	SourceName  string `json:"source_name,omitempty"`
+	OutputFile  string `json:"output_file,omitempty"`
+	Error       string `json:"error,omitempty"`
+	DownloadURL string `json:"download_url,omitempty"`
 }
 
+// Callback tracks delivery of a task's webhook.
+type Callback struct {
+	URL string `json:"url"`
+	// State is pending, delivered or failed (gave up).
+	State       string     `json:"state"`
+	Attempts    int        `json:"attempts,omitempty"`
+	LastStatus  int        `json:"last_status,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+}
+
+type StageEntry struct {
+	Status string    `json:"status"`
+	Stage  string    `json:"stage,omitempty"`
+	At     time.Time `json:"at"`
+}
+
+// Store keeps task records. Set merges t into the stored record atomically
+// (see merge) and publishes the result to subscribers of that task.
 type Store interface {
 	Set(ctx context.Context, t *TaskStatus, ttl time.Duration) error
 	Get(ctx context.Context, id string) (*TaskStatus, bool)
+	// Subscribe streams updates of task id until ctx is done or the returned
+	// stop func is called.
+	Subscribe(ctx context.Context, id string) (<-chan TaskStatus, func())
+	// SetOwner records who created a task; Owner reads it back. Later Set
+	// calls give the owner record the TTL of the task record.
+	SetOwner(ctx context.Context, id, owner string, ttl time.Duration) error
+	Owner(ctx context.Context, id string) (string, bool)
+	// List returns tasks matching q, newest first, and the Offset of the
+	// next page, 0 if there is none (see index.go).
+	List(ctx context.Context, q TaskQuery) ([]*TaskStatus, int, error)
+	// AppendLog adds tool output to the task's log; Log reads it back (see logs.go).
+	AppendLog(ctx context.Context, id, text string, ttl time.Duration) error
+	Log(ctx context.Context, id string) (string, bool)
 }
 
 // Redis-backed store with graceful fallback to memory when redis is nil.
 type RedisStore struct {
 	Rdb *redis.Client
-	mem *MemoryStore
+	// Retain is the minimum lifetime of finished task records, so listings
+	// still show them after the writer's own TTL; zero keeps writer TTLs.
+	Retain time.Duration
+	// LogLimit caps each task log in bytes; zero means DefaultLogLimit.
+	LogLimit int
+	mem      *MemoryStore
 }
 
 func NewRedisStore(rdb *redis.Client) *RedisStore {
@@ -34,11 +133,61 @@ func NewRedisStore(rdb *redis.Client) *RedisStore {
 }
 
 func (s *RedisStore) Set(ctx context.Context, t *TaskStatus, ttl time.Duration) error {
+	ctx, span := traceWrite(ctx, "store.Set", attribute.String("task.id", t.ID))
+	return tracing.End(span, s.set(ctx, t, ttl))
+}
+
+func (s *RedisStore) set(ctx context.Context, t *TaskStatus, ttl time.Duration) error {
 	if s.Rdb == nil {
-		return s.mem.Set(ctx, t, ttl)
+		s.mem.set(t, ttl, s.Retain)
+		return nil
+	}
+	key := "task:" + t.ID
+	// optimistic read-merge-write; a concurrent writer makes Exec fail and we retry
+	for i := 0; i < 10; i++ {
+		var b []byte
+		err := s.Rdb.Watch(ctx, func(tx *redis.Tx) error {
+			var cur *TaskStatus
+			if v, err := tx.Get(ctx, key).Bytes(); err == nil {
+				var old TaskStatus
+				if json.Unmarshal(v, &old) == nil {
+					cur = &old
+				}
+			} else if err != redis.Nil {
+				return err
+			}
+			next, changed := merge(cur, *t, time.Now())
+			if !changed {
+				b = nil
+				return nil
+			}
+			b, _ = json.Marshal(next)
+			if Finished(next.Status) && ttl < s.Retain {
+				ttl = s.Retain
+			}
+			_, err := tx.TxPipelined(ctx, func(p redis.Pipeliner) error {
+				p.Set(ctx, key, b, ttl)
+				// the owner record must not expire before the task does,
+				// or the task would read as anonymous
+				if ttl > 0 {
+					p.Expire(ctx, ownerKey(t.ID), ttl)
+				} else {
+					p.Persist(ctx, ownerKey(t.ID))
+				}
+				s.index(ctx, p, cur, &next)
+				return nil
+			})
+			return err
+		}, key)
+		if err == redis.TxFailedErr {
+			continue
+		}
+		if err != nil || b == nil {
+			return countErr("set", err)
+		}
+		return countErr("publish", s.Rdb.Publish(ctx, eventsChannel(t.ID), b).Err())
 	}
-	b, _ := json.Marshal(t)
-	return s.Rdb.Set(ctx, "task:"+t.ID, b, ttl).Err()
+	return countErr("set", redis.TxFailedErr)
 }
 
 func (s *RedisStore) Get(ctx context.Context, id string) (*TaskStatus, bool) {
@@ -47,6 +196,7 @@ func (s *RedisStore) Get(ctx context.Context, id string) (*TaskStatus, bool) {
 	}
 	v, err := s.Rdb.Get(ctx, "task:"+id).Result()
 	if err != nil {
+		countErr("get", err)
 		return nil, false
 	}
 	var t TaskStatus
@@ -56,28 +206,244 @@ func (s *RedisStore) Get(ctx context.Context, id string) (*TaskStatus, bool) {
 	return &t, true
 }
 
-// Simple in-memory implementation
+func ownerKey(id string) string { return "task:" + id + ":owner" }
+
+func (s *RedisStore) SetOwner(ctx context.Context, id, owner string, ttl time.Duration) (err error) {
+	ctx, span := traceWrite(ctx, "store.SetOwner", attribute.String("task.id", id))
+	defer func() { tracing.End(span, err) }()
+	if s.Rdb == nil {
+		return s.mem.SetOwner(ctx, id, owner, ttl)
+	}
+	now := time.Now()
+	key := ownerTasksKey(owner)
+	_, err = s.Rdb.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.Set(ctx, ownerKey(id), owner, ttl)
+		// NX keeps the creation time when the TTL is refreshed
+		p.ZAddNX(ctx, key, redis.Z{Score: float64(now.UnixMilli()), Member: id})
+		s.trim(ctx, p, key)
+		return nil
+	})
+	return countErr("set_owner", err)
+}
+
+func (s *RedisStore) Owner(ctx context.Context, id string) (string, bool) {
+	if s.Rdb == nil {
+		return s.mem.Owner(ctx, id)
+	}
+	v, err := s.Rdb.Get(ctx, ownerKey(id)).Result()
+	if err != nil {
+		return "", false
+	}
+	return v, true
+}
+
+// traceWrite starts the span of a store write.
+func traceWrite(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// countErr records a failed Redis call in metrics and returns err unchanged.
+// redis.Nil is a miss, not a failure.
+func countErr(op string, err error) error {
+	if err != nil && err != redis.Nil {
+		metrics.StoreErrors.WithLabelValues(op).Inc()
+	}
+	return err
+}
+
+// Simple in-memory implementation. Records expire like their Redis
+// counterparts: reads skip expired ones and writes prune them at most once
+// a minute.
 type MemoryStore struct {
-	mu   sync.RWMutex
-	data map[string]TaskStatus
+	// Retain is the minimum lifetime of finished task records and of task
+	// logs, as in RedisStore; zero keeps writer TTLs.
+	Retain time.Duration
+	mu     sync.RWMutex
+	data   map[string]TaskStatus
+	owners map[string]string
+	// expires and ownerExpires hold the deadlines of task and owner
+	// records; a missing entry never expires.
+	expires      map[string]time.Time
+	ownerExpires map[string]time.Time
+	// order holds all task IDs and byOwner those of each owner, both in
+	// creation order; they back List like the Redis sorted sets do.
+	order   []string
+	byOwner map[string][]string
+	pruned  time.Time
+	now     func() time.Time
+	memQueue
+	memPubSub
+	memUploads
+	memLogs
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		data:         make(map[string]TaskStatus),
+		owners:       make(map[string]string),
+		expires:      make(map[string]time.Time),
+		ownerExpires: make(map[string]time.Time),
+		byOwner:      make(map[string][]string),
+		now:          time.Now,
+		memQueue:     memQueue{ready: make(chan struct{}, 1)},
+		memPubSub:    memPubSub{subs: make(map[string]map[chan TaskStatus]struct{})},
+	}
+}
+
+// deadline is the expiry of a record written now with ttl; zero means none,
+// as a zero TTL in Redis.
+func deadline(now time.Time, ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return now.Add(ttl)
+}
+
+func expired(at map[string]time.Time, id string, now time.Time) bool {
+	t, ok := at[id]
+	return ok && now.After(t)
 }
 
-func NewMemoryStore() *MemoryStore { return &MemoryStore{data: make(map[string]TaskStatus)} }
+func (m *MemoryStore) Set(_ context.Context, t *TaskStatus, ttl time.Duration) error {
+	m.set(t, ttl, m.Retain)
+	return nil
+}
 
-func (m *MemoryStore) Set(_ context.Context, t *TaskStatus, _ time.Duration) error {
+// set merges t like Set; finished records live at least retain.
+func (m *MemoryStore) set(t *TaskStatus, ttl, retain time.Duration) {
 	m.mu.Lock()
-	m.data[t.ID] = *t
+	now := m.now()
+	m.prune(now)
+	if expired(m.expires, t.ID, now) {
+		m.dropTask(t.ID)
+	}
+	var cur *TaskStatus
+	if v, ok := m.data[t.ID]; ok {
+		cur = &v
+	}
+	next, changed := merge(cur, *t, now)
+	if changed {
+		if cur == nil {
+			m.order = append(m.order, t.ID)
+		}
+		m.data[t.ID] = next
+		if Finished(next.Status) && ttl < retain {
+			ttl = retain
+		}
+		m.setDeadline(m.expires, t.ID, deadline(now, ttl))
+		// the owner record expires with the task, as in Redis
+		if _, ok := m.owners[t.ID]; ok {
+			m.setDeadline(m.ownerExpires, t.ID, deadline(now, ttl))
+		}
+	}
 	m.mu.Unlock()
-	return nil
+	if changed {
+		m.publish(next)
+	}
+}
+
+func (m *MemoryStore) setDeadline(at map[string]time.Time, id string, t time.Time) {
+	if t.IsZero() {
+		delete(at, id)
+		return
+	}
+	at[id] = t
+}
+
+// dropTask removes an expired task record ahead of the next prune, so that
+// its ID can be indexed again.
+func (m *MemoryStore) dropTask(id string) {
+	delete(m.data, id)
+	delete(m.expires, id)
+	m.order = keep(m.order, func(v string) bool { return v != id })
+}
+
+// dropOwner is dropTask for owner records.
+func (m *MemoryStore) dropOwner(id string) {
+	owner := m.owners[id]
+	delete(m.owners, id)
+	delete(m.ownerExpires, id)
+	m.byOwner[owner] = keep(m.byOwner[owner], func(v string) bool { return v != id })
+}
+
+// prune drops expired records and their index entries at most once a minute.
+func (m *MemoryStore) prune(now time.Time) {
+	if now.Sub(m.pruned) < time.Minute {
+		return
+	}
+	m.pruned = now
+	for id, t := range m.expires {
+		if now.After(t) {
+			delete(m.data, id)
+			delete(m.expires, id)
+		}
+	}
+	for id, t := range m.ownerExpires {
+		if now.After(t) {
+			delete(m.owners, id)
+			delete(m.ownerExpires, id)
+		}
+	}
+	m.order = keep(m.order, func(id string) bool {
+		_, ok := m.data[id]
+		return ok
+	})
+	for owner, ids := range m.byOwner {
+		ids = keep(ids, func(id string) bool { return m.owners[id] == owner })
+		if len(ids) == 0 {
+			delete(m.byOwner, owner)
+			continue
+		}
+		m.byOwner[owner] = ids
+	}
+}
+
+// keep filters ids in place.
+func keep(ids []string, ok func(string) bool) []string {
+	out := ids[:0]
+	for _, id := range ids {
+		if ok(id) {
+			out = append(out, id)
+		}
+	}
+	return out
 }
 
 func (m *MemoryStore) Get(_ context.Context, id string) (*TaskStatus, bool) {
 	m.mu.RLock()
 	v, ok := m.data[id]
+	gone := expired(m.expires, id, m.now())
 	m.mu.RUnlock()
-	if !ok {
+	if !ok || gone {
 		return nil, false
 	}
 	vv := v
 	return &vv, true
 }
+
+func (m *MemoryStore) SetOwner(_ context.Context, id, owner string, ttl time.Duration) error {
+	m.mu.Lock()
+	now := m.now()
+	m.prune(now)
+	if expired(m.ownerExpires, id, now) {
+		m.dropOwner(id)
+	}
+	if _, ok := m.owners[id]; !ok {
+		m.byOwner[owner] = append(m.byOwner[owner], id)
+	}
+	m.owners[id] = owner
+	m.setDeadline(m.ownerExpires, id, deadline(now, ttl))
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *MemoryStore) Owner(_ context.Context, id string) (string, bool) {
+	m.mu.RLock()
+	v, ok := m.owners[id]
+	gone := expired(m.ownerExpires, id, m.now())
+	m.mu.RUnlock()
+	if !ok || gone {
+		return "", false
+	}
+	return v, ok
+}
@@ -0,0 +1,108 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	redis "github.com/redis/go-redis/v9"
+)
+
+func TestOwnerLivesAsLongAsTask(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer rdb.Close()
+	s := NewRedisStore(rdb)
+	s.Retain = 72 * time.Hour
+
+	_ = s.SetOwner(ctx, "t1", "alice", 0)
+	_ = s.Set(ctx, &TaskStatus{ID: "t1", Status: "queued"}, 0)
+	if ttl := mr.TTL(ownerKey("t1")); ttl != 0 {
+		t.Errorf("owner TTL while queued = %s, want none", ttl)
+	}
+	_ = s.Set(ctx, &TaskStatus{ID: "t1", Status: "processing"}, 30*time.Minute)
+	_ = s.Set(ctx, &TaskStatus{ID: "t1", Status: "completed"}, 30*time.Minute)
+	if ttl := mr.TTL(ownerKey("t1")); ttl != mr.TTL("task:t1") || ttl != s.Retain {
+		t.Errorf("owner TTL %s, task TTL %s; want both %s", ttl, mr.TTL("task:t1"), s.Retain)
+	}
+
+	mr.FastForward(25 * time.Hour)
+	if _, ok := s.Get(ctx, "t1"); !ok {
+		t.Fatal("task record gone")
+	}
+	if owner, ok := s.Owner(ctx, "t1"); !ok || owner != "alice" {
+		t.Errorf("Owner = %q, %v; want alice", owner, ok)
+	}
+	mr.FastForward(48 * time.Hour)
+	if _, ok := s.Owner(ctx, "t1"); ok {
+		t.Error("owner record outlived the task")
+	}
+}
+
+func TestMemoryStoreExpires(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	m := NewMemoryStore()
+	m.now = func() time.Time { return now }
+	m.Retain = time.Hour
+	alice := "alice"
+
+	_ = m.SetOwner(ctx, "t1", alice, 0)
+	_ = m.Set(ctx, &TaskStatus{ID: "t1", Status: "completed"}, time.Minute)
+	_ = m.AppendLog(ctx, "t1", "done\n", time.Minute)
+	_ = m.Set(ctx, &TaskStatus{ID: "t2", Status: "processing"}, time.Minute)
+	_ = m.Set(ctx, &TaskStatus{ID: "t3", Status: "queued"}, 0)
+
+	now = now.Add(30 * time.Minute)
+	if _, ok := m.Get(ctx, "t2"); ok {
+		t.Error("unfinished task outlived its TTL")
+	}
+	if _, ok := m.Get(ctx, "t1"); !ok {
+		t.Error("finished task expired before Retain")
+	}
+	if _, ok := m.Log(ctx, "t1"); !ok {
+		t.Error("log expired before Retain")
+	}
+	if got, _, _ := m.List(ctx, TaskQuery{}); len(got) != 2 {
+		t.Errorf("List returned %d tasks, want t1 and t3", len(got))
+	}
+
+	now = now.Add(time.Hour)
+	if _, ok := m.Owner(ctx, "t1"); ok {
+		t.Error("owner record outlived the task")
+	}
+	if got, _, _ := m.List(ctx, TaskQuery{Owner: &alice}); len(got) != 0 {
+		t.Errorf("List by owner returned %d expired tasks", len(got))
+	}
+	_ = m.Set(ctx, &TaskStatus{ID: "t4", Status: "queued"}, 0)
+	m.mu.RLock()
+	if len(m.data) != 2 || len(m.order) != 2 || len(m.owners) != 0 || len(m.byOwner) != 0 {
+		t.Errorf("after pruning: %d records, %d indexed, %d owners, %d owner indexes; want 2, 2, 0, 0",
+			len(m.data), len(m.order), len(m.owners), len(m.byOwner))
+	}
+	m.mu.RUnlock()
+	_ = m.AppendLog(ctx, "t4", "x", 0)
+	if _, ok := m.Log(ctx, "t1"); ok || len(m.logs) != 1 {
+		t.Errorf("expired log kept, %d logs", len(m.logs))
+	}
+}
+
+func TestMemoryStoreReusesExpiredID(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	m := NewMemoryStore()
+	m.now = func() time.Time { return now }
+
+	_ = m.Set(ctx, &TaskStatus{ID: "t1", Status: "failed", Error: "boom"}, time.Minute)
+	now = now.Add(2 * time.Minute)
+	_ = m.Set(ctx, &TaskStatus{ID: "t1", Status: "queued"}, 0)
+	got, ok := m.Get(ctx, "t1")
+	if !ok || got.Status != "queued" || got.Error != "" {
+		t.Fatalf("Get = %+v, %v; want a fresh queued record", got, ok)
+	}
+	if list, _, _ := m.List(ctx, TaskQuery{}); len(list) != 1 {
+		t.Errorf("List returned %d tasks, want 1", len(list))
+	}
+}
@@ -0,0 +1,122 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"comp/internal/tracing"
+)
+
+// Upload is the state of a resumable (tus) upload. The received bytes live
+// as chunk objects in storage; Offset says how many of them are committed.
+type Upload struct {
+	ID     string `json:"id"`
+	Owner  string `json:"owner,omitempty"`
+	Length int64  `json:"length"`
+	Offset int64  `json:"offset"`
+	// Filename is already sanitized; Params are the processing form fields
+	// sent as Upload-Metadata.
+	Filename string            `json:"filename"`
+	Params   map[string]string `json:"params,omitempty"`
+	// TaskID is set once the assembled file was handed to the pipeline.
+	TaskID    string    `json:"task_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Uploads keeps resumable upload state.
+type Uploads interface {
+	SetUpload(ctx context.Context, u *Upload, ttl time.Duration) error
+	GetUpload(ctx context.Context, id string) (*Upload, bool)
+	DeleteUpload(ctx context.Context, id string) error
+}
+
+func (s *RedisStore) SetUpload(ctx context.Context, u *Upload, ttl time.Duration) (err error) {
+	ctx, span := traceWrite(ctx, "store.SetUpload", attribute.String("upload.id", u.ID))
+	defer func() { tracing.End(span, err) }()
+	if s.Rdb == nil {
+		return s.mem.SetUpload(ctx, u, ttl)
+	}
+	b, _ := json.Marshal(u)
+	return s.Rdb.Set(ctx, "upload:"+u.ID, b, ttl).Err()
+}
+
+func (s *RedisStore) GetUpload(ctx context.Context, id string) (*Upload, bool) {
+	if s.Rdb == nil {
+		return s.mem.GetUpload(ctx, id)
+	}
+	b, err := s.Rdb.Get(ctx, "upload:"+id).Bytes()
+	if err != nil {
+		return nil, false
+	}
+	var u Upload
+	if json.Unmarshal(b, &u) != nil {
+		return nil, false
+	}
+	return &u, true
+}
+
+func (s *RedisStore) DeleteUpload(ctx context.Context, id string) (err error) {
+	ctx, span := traceWrite(ctx, "store.DeleteUpload", attribute.String("upload.id", id))
+	defer func() { tracing.End(span, err) }()
+	if s.Rdb == nil {
+		return s.mem.DeleteUpload(ctx, id)
+	}
+	return s.Rdb.Del(ctx, "upload:"+id).Err()
+}
+
+// memUploads expires entries lazily on read and prunes them on writes.
+type memUploads struct {
+	umu     sync.Mutex
+	uploads map[string]memUpload
+	upruned time.Time
+}
+
+type memUpload struct {
+	u       Upload
+	expires time.Time
+}
+
+func (m *memUploads) SetUpload(_ context.Context, u *Upload, ttl time.Duration) error {
+	m.umu.Lock()
+	defer m.umu.Unlock()
+	if m.uploads == nil {
+		m.uploads = make(map[string]memUpload)
+	}
+	now := time.Now()
+	if now.Sub(m.upruned) >= time.Minute {
+		m.upruned = now
+		for id, e := range m.uploads {
+			if now.After(e.expires) {
+				delete(m.uploads, id)
+			}
+		}
+	}
+	m.uploads[u.ID] = memUpload{u: *u, expires: now.Add(ttl)}
+	return nil
+}
+
+func (m *memUploads) GetUpload(_ context.Context, id string) (*Upload, bool) {
+	m.umu.Lock()
+	defer m.umu.Unlock()
+	e, ok := m.uploads[id]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(e.expires) {
+		delete(m.uploads, id)
+		return nil, false
+	}
+	u := e.u
+	return &u, true
+}
+
+func (m *memUploads) DeleteUpload(_ context.Context, id string) error {
+	m.umu.Lock()
+	delete(m.uploads, id)
+	m.umu.Unlock()
+	return nil
+}
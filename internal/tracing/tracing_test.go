@@ -0,0 +1,113 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"comp/internal/config"
+)
+
+// spans records the spans of all tests. The package tracer binds to the
+// first provider installed, so there is one for the whole package.
+var spans = tracetest.NewSpanRecorder()
+
+func TestMain(m *testing.M) {
+	if _, err := Setup(context.Background(), config.TracingConfig{}); err != nil {
+		panic(err)
+	}
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)))
+	os.Exit(m.Run())
+}
+
+// ended returns the spans finished after the first mark ones.
+func ended(mark int) []sdktrace.ReadOnlySpan {
+	return spans.Ended()[mark:]
+}
+
+func TestInjectExtract(t *testing.T) {
+	if Inject(context.Background()) != nil {
+		t.Error("Inject without a span returned a carrier")
+	}
+	ctx, span := otel.Tracer("test").Start(context.Background(), "submit")
+	carrier := Inject(ctx)
+	span.End()
+	if carrier["traceparent"] == "" {
+		t.Fatalf("carrier = %v, want a traceparent", carrier)
+	}
+	// a worker continues the trace from the stored job
+	got := trace.SpanContextFromContext(Extract(context.Background(), carrier))
+	if got.TraceID() != span.SpanContext().TraceID() {
+		t.Errorf("trace %s, want %s", got.TraceID(), span.SpanContext().TraceID())
+	}
+	if Extract(ctx, nil) != ctx {
+		t.Error("Extract without a carrier replaced the context")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	mark := len(spans.Ended())
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Middleware())
+	r.GET("/tasks/:id", func(c *gin.Context) { c.Status(http.StatusBadGateway) })
+
+	parent, span := otel.Tracer("test").Start(context.Background(), "client")
+	span.End()
+	req := httptest.NewRequest(http.MethodGet, "/tasks/abc", nil)
+	otel.GetTextMapPropagator().Inject(parent, propagation.HeaderCarrier(req.Header))
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	got := ended(mark)
+	s := got[len(got)-1]
+	if s.Name() != "GET /tasks/:id" {
+		t.Errorf("span name %q, want the route pattern", s.Name())
+	}
+	if s.Parent().TraceID() != span.SpanContext().TraceID() {
+		t.Error("request span does not continue the traceparent header")
+	}
+	if s.Status().Code != codes.Error {
+		t.Errorf("status %v, want an error for a 502", s.Status())
+	}
+}
+
+func TestEndAndQueueWait(t *testing.T) {
+	mark := len(spans.Ended())
+	boom := errors.New("boom")
+	_, span := otel.Tracer("test").Start(context.Background(), "op")
+	if err := End(span, boom); err != boom {
+		t.Errorf("End returned %v", err)
+	}
+	queued := time.Now().Add(-time.Second)
+	QueueWait(context.Background(), "t1", queued)
+	QueueWait(context.Background(), "t2", time.Time{})
+
+	got := ended(mark)
+	if len(got) != 2 {
+		t.Fatalf("%d spans, want op and one queue.wait", len(got))
+	}
+	if got[0].Status().Code != codes.Error || len(got[0].Events()) == 0 {
+		t.Error("End did not record the error")
+	}
+	if w := got[1]; w.Name() != "queue.wait" || !w.StartTime().Equal(queued) {
+		t.Errorf("queue span %q starts %v, want %v", w.Name(), w.StartTime(), queued)
+	}
+}
+
+func TestSetupUnknownExporter(t *testing.T) {
+	if _, err := Setup(context.Background(), config.TracingConfig{Exporter: "zipkin"}); err == nil {
+		t.Error("unknown exporter accepted")
+	}
+}
@@ -0,0 +1,148 @@
+package webhook
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+// Deliveries wait in the sorted set webhooks:due, task IDs scored by the
+// time of their next attempt (unix ms). An instance claims a due entry by
+// pushing its score claimTimeout ahead, so an attempt lost with a crashed
+// instance is retried after that.
+const (
+	dueKey       = "webhooks:due"
+	claimTimeout = time.Minute
+	claimBatch   = 100
+)
+
+// claimScript moves member ARGV[1] to score ARGV[3] if it is due at ARGV[2].
+var claimScript = redis.NewScript(`
+local s = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if s and tonumber(s) <= tonumber(ARGV[2]) then
+	redis.call('ZADD', KEYS[1], ARGV[3], ARGV[1])
+	return 1
+end
+return 0
+`)
+
+func (n *Notifier) schedule(ctx context.Context, id string, at time.Time) error {
+	if n.Redis == nil {
+		n.mu.Lock()
+		if n.due == nil {
+			n.due = make(map[string]time.Time)
+		}
+		n.due[id] = at
+		n.mu.Unlock()
+		return nil
+	}
+	return n.Redis.ZAdd(ctx, dueKey, redis.Z{Score: float64(at.UnixMilli()), Member: id}).Err()
+}
+
+func (n *Notifier) unschedule(id string) {
+	if n.Redis == nil {
+		n.mu.Lock()
+		delete(n.due, id)
+		n.mu.Unlock()
+		return
+	}
+	_ = n.Redis.ZRem(context.Background(), dueKey, id).Err()
+}
+
+// claim returns the deliveries that are due and now reserved for this
+// instance.
+func (n *Notifier) claim(ctx context.Context) ([]string, error) {
+	now := time.Now()
+	var ids []string
+	if n.Redis == nil {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		for id, at := range n.due {
+			if !at.After(now) {
+				n.due[id] = now.Add(claimTimeout)
+				ids = append(ids, id)
+			}
+		}
+		return ids, nil
+	}
+	due, err := n.Redis.ZRangeByScore(ctx, dueKey, &redis.ZRangeBy{
+		Min: "-inf", Max: strconv.FormatInt(now.UnixMilli(), 10), Count: claimBatch,
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range due {
+		ok, err := claimScript.Run(ctx, n.Redis, []string{dueKey}, id, now.UnixMilli(), now.Add(claimTimeout).UnixMilli()).Int()
+		if err != nil {
+			return ids, err
+		}
+		if ok == 1 {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// Start sends due callbacks, including those left by a previous run, until
+// Shutdown.
+func (n *Notifier) Start(ctx context.Context) {
+	loop, stop := context.WithCancel(ctx)
+	// attempts outlive the loop so Shutdown can let them finish
+	sends, abort := context.WithCancel(context.WithoutCancel(ctx))
+	n.stop, n.abort = stop, abort
+	poll := n.PollInterval
+	if poll <= 0 {
+		poll = time.Second
+	}
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		t := time.NewTicker(poll)
+		defer t.Stop()
+		for {
+			ids, err := n.claim(loop)
+			if err != nil && loop.Err() == nil && n.Logger != nil {
+				n.Logger.Warnf("claim callbacks: %v", err)
+			}
+			for _, id := range ids {
+				n.wg.Add(1)
+				go func() {
+					defer n.wg.Done()
+					n.attempt(sends, id)
+				}()
+			}
+			select {
+			case <-loop.Done():
+				return
+			case <-t.C:
+			case <-n.wake:
+			}
+		}
+	}()
+}
+
+// Shutdown stops taking due callbacks and waits for running attempts until
+// ctx is done; then it cancels them. Cancelled attempts stay due for the
+// next start. It returns ctx.Err() if it had to cancel.
+func (n *Notifier) Shutdown(ctx context.Context) error {
+	if n.stop == nil {
+		return nil
+	}
+	n.stop()
+	done := make(chan struct{})
+	go func() {
+		n.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		n.abort()
+		return nil
+	case <-ctx.Done():
+	}
+	n.abort()
+	<-done
+	return ctx.Err()
+}
@@ -0,0 +1,231 @@
+// Package webhook delivers task completion callbacks.
+//
+// Each delivery is a JSON POST of Payload with the headers
+//
+//	X-Webhook-Event:     task.completed | task.failed | task.cancelled | task.interrupted
+//	X-Webhook-Timestamp: unix seconds
+//	X-Webhook-Signature: sha256=<hex hmac-sha256(secret, timestamp + "." + body)>
+//
+// Receivers verify the signature with Sign and should reject stale
+// timestamps. Non-2xx answers and network errors are retried with
+// exponential backoff; the outcome is recorded in TaskStatus.Callback.
+// Pending deliveries are kept in Redis (see schedule.go), so a restart
+// resumes them and any instance may send them.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	redis "github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+
+	"comp/internal/store"
+)
+
+// Payload is the body of a callback.
+type Payload struct {
+	Event string            `json:"event"`
+	Task  *store.TaskStatus `json:"task"`
+}
+
+// Notifier sends callbacks for finished tasks.
+type Notifier struct {
+	Secret []byte
+	Store  store.Store
+	// Redis holds the delivery schedule; without it the schedule is kept
+	// in memory and lost on restart.
+	Redis  *redis.Client
+	Logger *zap.SugaredLogger
+	Client *http.Client
+	// MaxAttempts bounds deliveries per task; BaseDelay is the wait before
+	// the first retry and doubles after each one.
+	MaxAttempts int
+	BaseDelay   time.Duration
+	// PollInterval is how often Start looks for due deliveries.
+	PollInterval time.Duration
+
+	mu    sync.Mutex
+	due   map[string]time.Time // schedule without Redis
+	wake  chan struct{}
+	stop  context.CancelFunc // ends the Start loop
+	abort context.CancelFunc // cancels running attempts
+	wg    sync.WaitGroup
+}
+
+// New returns a Notifier whose client refuses private and loopback
+// addresses unless allowPrivate is set.
+func New(secret string, st store.Store, rdb *redis.Client, logger *zap.SugaredLogger, maxAttempts int, allowPrivate bool) *Notifier {
+	return &Notifier{
+		Secret:       []byte(secret),
+		Store:        st,
+		Redis:        rdb,
+		Logger:       logger,
+		Client:       NewClient(allowPrivate),
+		MaxAttempts:  maxAttempts,
+		BaseDelay:    2 * time.Second,
+		PollInterval: time.Second,
+		wake:         make(chan struct{}, 1),
+	}
+}
+
+// ValidateURL checks a user-supplied callback URL.
+func ValidateURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("callback_url must be an absolute http(s) URL")
+	}
+	return nil
+}
+
+// errPrivate is returned when a callback resolves to an internal address.
+var errPrivate = errors.New("callback address is not public")
+
+// NewClient returns an HTTP client for callbacks. The address check runs
+// on the resolved IP at dial time, so DNS tricks cannot reach internal hosts.
+func NewClient(allowPrivate bool) *http.Client {
+	d := &net.Dialer{Timeout: 10 * time.Second}
+	if !allowPrivate {
+		d.Control = func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() || ip.IsMulticast() {
+				return errPrivate
+			}
+			return nil
+		}
+	}
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.DialContext = d.DialContext
+	tr.Proxy = nil
+	return &http.Client{
+		Transport: tr,
+		Timeout:   15 * time.Second,
+		// receivers must answer directly; a 3xx counts as a failed attempt
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+}
+
+// Sign computes the X-Webhook-Signature value for body sent at timestamp.
+func Sign(secret []byte, timestamp string, body []byte) string {
+	m := hmac.New(sha256.New, secret)
+	m.Write([]byte(timestamp))
+	m.Write([]byte("."))
+	m.Write(body)
+	return "sha256=" + hex.EncodeToString(m.Sum(nil))
+}
+
+// Notify schedules delivery for task id if it is finished and has a
+// pending callback; Start sends it. Without a secret nothing is sent and
+// the callback is marked failed.
+func (n *Notifier) Notify(id string) {
+	ctx := context.Background()
+	t, ok := n.Store.Get(ctx, id)
+	if !ok || !store.Finished(t.Status) || t.Callback == nil || t.Callback.State != "pending" {
+		return
+	}
+	if len(n.Secret) == 0 {
+		cb := *t.Callback
+		cb.State, cb.LastError = "failed", "webhook_secret is not configured"
+		n.record(id, cb)
+		return
+	}
+	if err := n.schedule(ctx, id, time.Now()); err != nil && n.Logger != nil {
+		n.Logger.Errorf("[%s] schedule callback: %v", id, err)
+	}
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+}
+
+// attempt makes one delivery of task id's callback and schedules the next
+// one if it failed and attempts are left. An attempt cut short by Shutdown
+// does not count; the callback stays due for the next start.
+func (n *Notifier) attempt(ctx context.Context, id string) {
+	t, ok := n.Store.Get(ctx, id)
+	if !ok || t.Callback == nil || t.Callback.State != "pending" {
+		n.unschedule(id)
+		return
+	}
+	cb := *t.Callback
+	t.StoredFile = ""
+	t.Callback = nil
+	body, _ := json.Marshal(Payload{Event: "task." + t.Status, Task: t})
+	attempts := n.MaxAttempts
+	if attempts <= 0 {
+		attempts = 1
+	}
+	code, err := n.post(ctx, cb.URL, "task."+t.Status, body)
+	if err != nil && ctx.Err() != nil {
+		_ = n.schedule(context.WithoutCancel(ctx), id, time.Now())
+		return
+	}
+	cb.Attempts++
+	cb.LastStatus = code
+	cb.LastError = ""
+	if err == nil {
+		now := time.Now()
+		cb.State = "delivered"
+		cb.DeliveredAt = &now
+		n.record(id, cb)
+		n.unschedule(id)
+		return
+	}
+	cb.LastError = err.Error()
+	if n.Logger != nil {
+		n.Logger.Warnf("[%s] callback attempt %d failed: %v", id, cb.Attempts, err)
+	}
+	if cb.Attempts >= attempts || errors.Is(err, errPrivate) {
+		cb.State = "failed"
+		n.record(id, cb)
+		n.unschedule(id)
+		return
+	}
+	n.record(id, cb)
+	_ = n.schedule(context.WithoutCancel(ctx), id, time.Now().Add(n.BaseDelay<<(cb.Attempts-1)))
+}
+
+func (n *Notifier) post(ctx context.Context, target, event string, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "comp-webhook/1")
+	req.Header.Set("X-Webhook-Event", event)
+	req.Header.Set("X-Webhook-Timestamp", ts)
+	req.Header.Set("X-Webhook-Signature", Sign(n.Secret, ts, body))
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver answered %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+func (n *Notifier) record(id string, cb store.Callback) {
+	_ = n.Store.Set(context.Background(), &store.TaskStatus{ID: id, Callback: &cb}, 30*time.Minute)
+}
@@ -0,0 +1,260 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	redis "github.com/redis/go-redis/v9"
+
+	"comp/internal/store"
+)
+
+const testSecret = "s3cret"
+
+// finishedTask stores a completed task with a pending callback to url.
+func finishedTask(t *testing.T, st store.Store, id, url string) {
+	t.Helper()
+	err := st.Set(context.Background(), &store.TaskStatus{ID: id, Status: "completed", OutputFile: "out.mp4",
+		Callback: &store.Callback{URL: url, State: "pending"}}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// waitCallback waits until the task's callback leaves the pending state.
+func waitCallback(t *testing.T, st store.Store, id string) store.Callback {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if tk, ok := st.Get(context.Background(), id); ok && tk.Callback != nil && tk.Callback.State != "pending" {
+			return *tk.Callback
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("callback of %s still pending", id)
+	return store.Callback{}
+}
+
+// newNotifier returns a started Notifier with short delays.
+func newNotifier(t *testing.T, st store.Store, rdb *redis.Client, secret string, allowPrivate bool) *Notifier {
+	n := New(secret, st, rdb, nil, 3, allowPrivate)
+	n.BaseDelay = time.Millisecond
+	n.PollInterval = 5 * time.Millisecond
+	n.Start(context.Background())
+	t.Cleanup(func() { _ = n.Shutdown(context.Background()) })
+	return n
+}
+
+func TestDeliverSigned(t *testing.T) {
+	got := make(chan Payload, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		want := Sign([]byte(testSecret), r.Header.Get("X-Webhook-Timestamp"), body)
+		if r.Header.Get("X-Webhook-Signature") != want {
+			t.Errorf("signature %q, want %q", r.Header.Get("X-Webhook-Signature"), want)
+		}
+		if ev := r.Header.Get("X-Webhook-Event"); ev != "task.completed" {
+			t.Errorf("event header %q, want task.completed", ev)
+		}
+		var p Payload
+		if err := json.Unmarshal(body, &p); err != nil {
+			t.Errorf("body: %v", err)
+		}
+		got <- p
+	}))
+	defer srv.Close()
+
+	st := store.NewRedisStore(nil)
+	finishedTask(t, st, "t1", srv.URL)
+	newNotifier(t, st, nil, testSecret, true).Notify("t1")
+
+	cb := waitCallback(t, st, "t1")
+	if cb.State != "delivered" || cb.Attempts != 1 || cb.LastStatus != http.StatusOK || cb.DeliveredAt == nil {
+		t.Errorf("callback = %+v, want delivered on the first attempt", cb)
+	}
+	p := <-got
+	if p.Event != "task.completed" || p.Task == nil || p.Task.ID != "t1" || p.Task.OutputFile != "out.mp4" {
+		t.Errorf("payload = %+v", p)
+	}
+	if p.Task.Callback != nil || p.Task.StoredFile != "" {
+		t.Errorf("payload leaks internal fields: %+v", p.Task)
+	}
+}
+
+func TestSignatureRejectsOtherSecret(t *testing.T) {
+	body := []byte(`{"event":"task.failed"}`)
+	sig := Sign([]byte(testSecret), "1700000000", body)
+	if Sign([]byte("other"), "1700000000", body) == sig {
+		t.Error("signature does not depend on the secret")
+	}
+	if Sign([]byte(testSecret), "1700000001", body) == sig {
+		t.Error("signature does not depend on the timestamp")
+	}
+}
+
+func TestDeliverRetriesServerErrors(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer srv.Close()
+
+	st := store.NewRedisStore(nil)
+	finishedTask(t, st, "t1", srv.URL)
+	newNotifier(t, st, nil, testSecret, true).Notify("t1")
+
+	cb := waitCallback(t, st, "t1")
+	if cb.State != "delivered" || cb.Attempts != 3 || cb.LastStatus != http.StatusOK || cb.LastError != "" {
+		t.Errorf("callback = %+v, want delivered on attempt 3", cb)
+	}
+}
+
+func TestDeliverGivesUp(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	st := store.NewRedisStore(nil)
+	finishedTask(t, st, "t1", srv.URL)
+	newNotifier(t, st, nil, testSecret, true).Notify("t1")
+
+	cb := waitCallback(t, st, "t1")
+	if cb.State != "failed" || cb.Attempts != 3 || cb.LastStatus != http.StatusServiceUnavailable || cb.LastError == "" {
+		t.Errorf("callback = %+v, want failed after 3 attempts", cb)
+	}
+	if n := calls.Load(); n != 3 {
+		t.Errorf("receiver got %d requests, want 3", n)
+	}
+}
+
+func TestDeliverRefusesPrivateAddress(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { calls.Add(1) }))
+	defer srv.Close()
+
+	st := store.NewRedisStore(nil)
+	finishedTask(t, st, "t1", srv.URL)
+	newNotifier(t, st, nil, testSecret, false).Notify("t1")
+
+	cb := waitCallback(t, st, "t1")
+	if cb.State != "failed" || cb.Attempts != 1 {
+		t.Errorf("callback = %+v, want failed without retries", cb)
+	}
+	if calls.Load() != 0 {
+		t.Error("loopback receiver was reached")
+	}
+}
+
+func TestNotifyWithoutSecret(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { calls.Add(1) }))
+	defer srv.Close()
+
+	st := store.NewRedisStore(nil)
+	finishedTask(t, st, "t1", srv.URL)
+	newNotifier(t, st, nil, "", true).Notify("t1")
+
+	cb := waitCallback(t, st, "t1")
+	if cb.State != "failed" || cb.Attempts != 0 {
+		t.Errorf("callback = %+v, want failed without attempts", cb)
+	}
+	if calls.Load() != 0 {
+		t.Error("unsigned callback was sent")
+	}
+}
+
+func TestValidateURL(t *testing.T) {
+	for raw, ok := range map[string]bool{
+		"https://example.com/hook": true,
+		"http://example.com:8080/": true,
+		"ftp://example.com/":       false,
+		"/relative":                false,
+		"https://":                 false,
+	} {
+		if err := ValidateURL(raw); (err == nil) != ok {
+			t.Errorf("ValidateURL(%q) = %v, want ok=%v", raw, err, ok)
+		}
+	}
+}
+
+func TestDeliveryResumesAfterRestart(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer rdb.Close()
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { calls.Add(1) }))
+	defer srv.Close()
+
+	st := store.NewRedisStore(rdb)
+	finishedTask(t, st, "t1", srv.URL)
+	// the instance that finished the task stops before sending
+	n := New(testSecret, st, rdb, nil, 3, true)
+	n.Notify("t1")
+	if due, _ := mr.ZMembers(dueKey); len(due) != 1 || due[0] != "t1" {
+		t.Fatalf("schedule = %v, want [t1]", due)
+	}
+	if calls.Load() != 0 {
+		t.Fatal("sent without Start")
+	}
+
+	newNotifier(t, st, rdb, testSecret, true)
+	cb := waitCallback(t, st, "t1")
+	if cb.State != "delivered" || calls.Load() != 1 {
+		t.Errorf("callback = %+v after %d requests, want delivered once", cb, calls.Load())
+	}
+	if ok, _ := rdb.ZScore(ctx, dueKey, "t1").Result(); ok != 0 {
+		t.Error("delivered callback still scheduled")
+	}
+}
+
+func TestShutdownKeepsInterruptedDelivery(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer rdb.Close()
+	started, release := make(chan struct{}, 1), make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	st := store.NewRedisStore(rdb)
+	finishedTask(t, st, "t1", srv.URL)
+	n := New(testSecret, st, rdb, nil, 3, true)
+	n.Start(context.Background())
+	n.Notify("t1")
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := n.Shutdown(ctx); err == nil {
+		t.Error("Shutdown did not report the cancelled attempt")
+	}
+	tk, _ := st.Get(context.Background(), "t1")
+	if tk.Callback.State != "pending" || tk.Callback.Attempts != 0 {
+		t.Errorf("callback = %+v, want pending without a counted attempt", tk.Callback)
+	}
+	if due, _ := mr.ZMembers(dueKey); len(due) != 1 {
+		t.Errorf("schedule = %v, want t1 still due", due)
+	}
+}